@@ -0,0 +1,77 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	ctx2 "go-walle/app/api/ctx"
+	"go-walle/app/global"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/internal/response"
+	"go-walle/app/service/adhoc"
+	"go.uber.org/zap"
+)
+
+type AdhocCtl struct {
+	service *adhoc.Service
+}
+
+func (ctl *AdhocCtl) List(ctx *gin.Context) {
+	params := adhoc.ListReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBind(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	total, items, err := ctl.service.List(&params)
+	response.PageData(ctx, total, items, err)
+}
+
+func (ctl *AdhocCtl) Detail(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	data, err := ctl.service.Detail(spaceAndId)
+	response.Response(ctx, err, data)
+}
+
+// Create 开始批量执行，返回的id用于连接控制台
+func (ctl *AdhocCtl) Create(ctx *gin.Context) {
+	params := adhoc.CreateReq{SpaceId: ctx2.GetSpaceId(ctx), UserId: ctx2.UserId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	data, err := ctl.service.Create(&params)
+	response.Response(ctx, err, data)
+}
+
+func (ctl *AdhocCtl) Stop(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.Stop(spaceAndId), nil)
+}
+
+// Console websocket 实时查看每台服务器的输出和退出码
+func (ctl *AdhocCtl) Console(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	wsConn, err := ctx2.UpGrader(ctx)
+	if err != nil {
+		response.Fail(ctx, err)
+		return
+	}
+	defer func() {
+		_ = wsConn.Close()
+	}()
+	if err = ctl.service.Console(wsConn, spaceAndId); err != nil {
+		global.Log.Error("adhoc console error", zap.Error(err))
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/wuzfei/cfgstruct/cfgstruct"
 	"go-walle/app/global"
 	"go-walle/app/internal/validate"
+	"go-walle/app/service/mirror"
+	"go-walle/app/service/recording"
 	"golang.org/x/sync/errgroup"
 	"net"
 	"net/http"
@@ -51,6 +53,14 @@ func (s *Server) Run(ctx context.Context) error {
 		<-ctx.Done()
 		return s.server.Shutdown(context.Background())
 	})
+	//后台同步仓库镜像
+	group.Go(func() error {
+		return mirror.NewService(global.Log, global.DB, global.Repo, s.config.Repo.FetchInterval).Run(ctx)
+	})
+	//定时清理过期的终端录像
+	group.Go(func() error {
+		return recording.NewService(global.Log, global.DB, &s.config.Recording).Run(ctx)
+	})
 	group.Go(func() error {
 		defer cancel()
 		_err := s.server.Serve(listener)
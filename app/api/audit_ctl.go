@@ -0,0 +1,24 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	ctx2 "go-walle/app/api/ctx"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/internal/response"
+	"go-walle/app/service/audit"
+)
+
+type AuditCtl struct {
+	service *audit.Service
+}
+
+func (ctl *AuditCtl) List(ctx *gin.Context) {
+	params := audit.ListReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBind(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	total, items, err := ctl.service.List(&params)
+	response.PageData(ctx, total, items, err)
+}
@@ -23,6 +23,31 @@ func (ctl *DeployCtl) Create(ctx *gin.Context) {
 	response.Response(ctx, ctl.service.Create(&params), nil)
 }
 
+// UploadArtifact 上传构建产物，表单字段file
+func (ctl *DeployCtl) UploadArtifact(ctx *gin.Context) {
+	params := deploy.UploadArtifactReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBind(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	f, err := fileHeader.Open()
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	data, err := ctl.service.UploadArtifact(&params, fileHeader.Filename, f)
+	response.Response(ctx, err, data)
+}
+
 func (ctl *DeployCtl) List(ctx *gin.Context) {
 	params := deploy.ListReq{SpaceId: ctx2.GetSpaceId(ctx)}
 	err := ctx.ShouldBind(&params)
@@ -45,6 +45,16 @@ func (ctl *EnvironmentCtl) Update(ctx *gin.Context) {
 	response.Response(ctx, ctl.service.Update(&params), nil)
 }
 
+func (ctl *EnvironmentCtl) SignPolicy(ctx *gin.Context) {
+	params := environment.SignPolicyReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.SignPolicy(&params), nil)
+}
+
 func (ctl *EnvironmentCtl) Delete(ctx *gin.Context) {
 	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
 	if err != nil {
@@ -75,3 +85,33 @@ func (ctl *EnvironmentCtl) Options(ctx *gin.Context) {
 	})}
 	response.Success(ctx, res)
 }
+
+func (ctl *EnvironmentCtl) SigningKeyList(ctx *gin.Context) {
+	params := environment.SigningKeyListReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBind(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	total, items, err := ctl.service.SigningKeyList(&params)
+	response.PageData(ctx, total, items, err)
+}
+
+func (ctl *EnvironmentCtl) SigningKeyCreate(ctx *gin.Context) {
+	params := environment.SigningKeyCreateReq{SpaceId: ctx2.GetSpaceId(ctx), UserId: ctx2.UserId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.SigningKeyCreate(&params), nil)
+}
+
+func (ctl *EnvironmentCtl) SigningKeyDelete(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.SigningKeyDelete(spaceAndId), nil)
+}
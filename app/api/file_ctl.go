@@ -0,0 +1,132 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	ctx2 "go-walle/app/api/ctx"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/internal/response"
+	"go-walle/app/service/files"
+	"mime"
+)
+
+type FileCtl struct {
+	service *files.Service
+}
+
+func (ctl *FileCtl) Policy(ctx *gin.Context) {
+	data, err := ctl.service.Policy(ctx2.GetSpaceId(ctx))
+	response.Response(ctx, err, data)
+}
+
+func (ctl *FileCtl) PolicyUpdate(ctx *gin.Context) {
+	params := files.PolicyReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.PolicyUpdate(&params), nil)
+}
+
+// List 列出服务器目录，参数path
+func (ctl *FileCtl) List(ctx *gin.Context) {
+	params, ok := ctl.pathReq(ctx)
+	if !ok {
+		return
+	}
+	data, err := ctl.service.List(params)
+	response.Response(ctx, err, data)
+}
+
+func (ctl *FileCtl) Stat(ctx *gin.Context) {
+	params, ok := ctl.pathReq(ctx)
+	if !ok {
+		return
+	}
+	data, err := ctl.service.Stat(params)
+	response.Response(ctx, err, data)
+}
+
+func (ctl *FileCtl) Download(ctx *gin.Context) {
+	params, ok := ctl.pathReq(ctx)
+	if !ok {
+		return
+	}
+	f, info, err := ctl.service.Download(params)
+	if err != nil {
+		response.Fail(ctx, err)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	ctx.DataFromReader(200, info.Size, "application/octet-stream", f, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": info.Name}),
+	})
+}
+
+// Content 读取文本文件用于在线编辑
+func (ctl *FileCtl) Content(ctx *gin.Context) {
+	params, ok := ctl.pathReq(ctx)
+	if !ok {
+		return
+	}
+	data, err := ctl.service.Content(params)
+	response.Response(ctx, err, data)
+}
+
+func (ctl *FileCtl) Save(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	params := files.SaveReq{SpaceId: spaceAndId.SpaceId, ID: spaceAndId.ID, UserId: ctx2.UserId(ctx)}
+	if err = ctx.ShouldBindJSON(&params); err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.Save(&params), nil)
+}
+
+// Upload 上传文件，表单字段dir和file
+func (ctl *FileCtl) Upload(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	params := files.UploadReq{SpaceId: spaceAndId.SpaceId, ID: spaceAndId.ID, UserId: ctx2.UserId(ctx)}
+	if err = ctx.ShouldBind(&params); err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	f, err := fileHeader.Open()
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	response.Response(ctx, ctl.service.Upload(&params, fileHeader.Filename, f, fileHeader.Size), nil)
+}
+
+func (ctl *FileCtl) pathReq(ctx *gin.Context) (*files.PathReq, bool) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return nil, false
+	}
+	params := &files.PathReq{SpaceId: spaceAndId.SpaceId, ID: spaceAndId.ID}
+	if err = ctx.ShouldBindQuery(params); err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return nil, false
+	}
+	return params, true
+}
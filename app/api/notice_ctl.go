@@ -0,0 +1,97 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	ctx2 "go-walle/app/api/ctx"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/internal/response"
+	"go-walle/app/service/notice"
+)
+
+type NoticeCtl struct {
+	service *notice.Service
+}
+
+// Subscriptions 当前用户的通知订阅设置
+func (ctl *NoticeCtl) Subscriptions(ctx *gin.Context) {
+	res, err := ctl.service.Subscriptions(ctx2.UserId(ctx))
+	response.Response(ctx, err, res)
+}
+
+// Subscribe 订阅或者退订通知
+func (ctl *NoticeCtl) Subscribe(ctx *gin.Context) {
+	params := notice.SubscribeReq{UserId: ctx2.UserId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.Subscribe(&params), nil)
+}
+
+func (ctl *NoticeCtl) WebhookList(ctx *gin.Context) {
+	params := notice.WebhookListReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBind(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	total, items, err := ctl.service.WebhookList(&params)
+	response.PageData(ctx, total, items, err)
+}
+
+func (ctl *NoticeCtl) WebhookCreate(ctx *gin.Context) {
+	params := notice.WebhookCreateReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.WebhookCreate(&params), nil)
+}
+
+func (ctl *NoticeCtl) WebhookUpdate(ctx *gin.Context) {
+	params := notice.WebhookUpdateReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.WebhookUpdate(&params), nil)
+}
+
+func (ctl *NoticeCtl) WebhookDelete(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.WebhookDelete(spaceAndId), nil)
+}
+
+// Deliveries webhook推送记录
+func (ctl *NoticeCtl) Deliveries(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	params := notice.DeliveryListReq{SpaceId: spaceAndId.SpaceId, WebhookId: spaceAndId.ID}
+	if err = ctx.ShouldBind(&params); err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	total, items, err := ctl.service.Deliveries(&params)
+	response.PageData(ctx, total, items, err)
+}
+
+// Replay 重新推送某次webhook
+func (ctl *NoticeCtl) Replay(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	res, err := ctl.service.Replay(spaceAndId)
+	response.Response(ctx, err, res)
+}
@@ -7,11 +7,13 @@ import (
 	"go-walle/app/internal/errcode"
 	"go-walle/app/internal/response"
 	"go-walle/app/model"
+	"go-walle/app/service/mirror"
 	"go-walle/app/service/project"
 )
 
 type ProjectCtl struct {
 	service *project.Service
+	mirror  *mirror.Service
 }
 
 func (ctl *ProjectCtl) Create(ctx *gin.Context) {
@@ -36,7 +38,7 @@ func (ctl *ProjectCtl) List(ctx *gin.Context) {
 }
 
 func (ctl *ProjectCtl) Update(ctx *gin.Context) {
-	params := project.UpdateReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	params := project.UpdateReq{SpaceId: ctx2.GetSpaceId(ctx), UserId: ctx2.UserId(ctx)}
 	err := ctx.ShouldBindJSON(&params)
 	if err != nil {
 		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
@@ -130,3 +132,25 @@ func (ctl *ProjectCtl) Commits(ctx *gin.Context) {
 	res, err := ctl.service.GetCommits(spaceAndId, ctx.Query("branch"))
 	response.Response(ctx, err, res)
 }
+
+// RotateDeployKey 重新生成部署密钥
+func (ctl *ProjectCtl) RotateDeployKey(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	res, err := ctl.service.RotateDeployKey(spaceAndId)
+	response.Response(ctx, err, res)
+}
+
+// Fetch 立即同步代码仓库
+func (ctl *ProjectCtl) Fetch(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	res, err := ctl.mirror.Fetch(spaceAndId)
+	response.Response(ctx, err, res)
+}
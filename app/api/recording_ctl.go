@@ -0,0 +1,40 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	ctx2 "go-walle/app/api/ctx"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/internal/response"
+	"go-walle/app/service/recording"
+)
+
+type RecordingCtl struct {
+	service *recording.Service
+}
+
+func (ctl *RecordingCtl) List(ctx *gin.Context) {
+	params := recording.ListReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBind(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	total, items, err := ctl.service.List(&params)
+	response.PageData(ctx, total, items, err)
+}
+
+// Cast 返回asciinema v2格式的录像，用于浏览器回放
+func (ctl *RecordingCtl) Cast(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	file, err := ctl.service.File(spaceAndId)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrNotFound.Wrap(err))
+		return
+	}
+	ctx.Header("Content-Type", "application/x-asciicast")
+	ctx.File(file)
+}
@@ -6,13 +6,20 @@ import (
 	"go-walle/app/api/middleware"
 	"go-walle/app/global"
 	"go-walle/app/internal/constants"
+	"go-walle/app/service/adhoc"
+	"go-walle/app/service/audit"
 	"go-walle/app/service/deploy"
 	"go-walle/app/service/environment"
+	"go-walle/app/service/files"
 	"go-walle/app/service/member"
+	"go-walle/app/service/mirror"
+	"go-walle/app/service/notice"
 	"go-walle/app/service/project"
+	"go-walle/app/service/recording"
 	server2 "go-walle/app/service/server"
 	"go-walle/app/service/space"
 	"go-walle/app/service/user"
+	"go-walle/app/service/webhook"
 	"io"
 	"mime"
 	"path"
@@ -66,10 +73,22 @@ func apiRoutes(r *gin.RouterGroup, s *Server) {
 	r.POST("/login", loginCtl.Login)
 	r.POST("/refresh_token", loginCtl.RefreshToken)
 
+	//代码仓库webhook，通过签名校验，不需要登陆
+	webhookCtl := WebhookCtl{service: webhook.NewService(global.Log, global.DB, deploy.NewService(),
+		mirror.NewService(global.Log, global.DB, global.Repo, global.Cfg.Repo.FetchInterval))}
+	r.POST("/webhook/:provider/:id", webhookCtl.Receive)
+
 	authRouter := r.Group("", middleware.Auth)
 	authRouter.POST("/logout", loginCtl.Logout)
 	authRouter.GET("/user_info", loginCtl.UserInfo)
 
+	//通知订阅设置
+	{
+		ctl := &NoticeCtl{service: notice.NewService(global.Log, global.DB, &global.Cfg.Notice)}
+		authRouter.GET("/notice/subscription", ctl.Subscriptions)
+		authRouter.PUT("/notice/subscription", ctl.Subscribe)
+	}
+
 	superPermRouter := authRouter.Group("", middleware.Permission(userService, constants.RoleSuper))
 	ownerPermRouter := authRouter.Group("", middleware.Permission(userService, constants.RoleOwner))
 	masterPermRouter := authRouter.Group("", middleware.Permission(userService, constants.RoleMaster))
@@ -85,6 +104,17 @@ func apiRoutes(r *gin.RouterGroup, s *Server) {
 		superPermRouter.GET("/user/options", ctl.Options)
 	}
 
+	//webhook推送管理
+	{
+		ctl := &NoticeCtl{service: notice.NewService(global.Log, global.DB, &global.Cfg.Notice)}
+		ownerPermRouter.GET("/notice/webhook", ctl.WebhookList)
+		ownerPermRouter.POST("/notice/webhook", ctl.WebhookCreate)
+		ownerPermRouter.PUT("/notice/webhook", ctl.WebhookUpdate)
+		ownerPermRouter.DELETE("/notice/webhook/:id", ctl.WebhookDelete)
+		ownerPermRouter.GET("/notice/webhook/:id/delivery", ctl.Deliveries)
+		ownerPermRouter.POST("/notice/delivery/:id/replay", ctl.Replay)
+	}
+
 	//成员管理
 	{
 		ctl := &MemberCtl{service: member.NewService()}
@@ -102,9 +132,12 @@ func apiRoutes(r *gin.RouterGroup, s *Server) {
 		superPermRouter.PUT("/space", ctl.Update)
 	}
 
+	serverService := server2.NewService(global.Log, global.DB, global.Ssh,
+		recording.NewService(global.Log, global.DB, &global.Cfg.Recording), audit.NewService(global.Log, global.DB))
+
 	//服务器管理
 	{
-		ctl := &ServerCtl{service: server2.NewService(global.Log, global.DB, global.Ssh)}
+		ctl := &ServerCtl{service: serverService}
 		ownerPermRouter.GET("/server", ctl.List)
 		ownerPermRouter.POST("/server", ctl.Create)
 		ownerPermRouter.DELETE("/server/:id", ctl.Delete)
@@ -115,6 +148,61 @@ func apiRoutes(r *gin.RouterGroup, s *Server) {
 		ownerPermRouter.POST("/server/set_authorized", ctl.SetAuthorized)
 		//websocket 连接终端
 		ownerPermRouter.GET("/server/:id/terminal", ctl.Terminal)
+		//主机公钥
+		ownerPermRouter.GET("/server/:id/host_key", ctl.HostKey)
+		ownerPermRouter.POST("/server/:id/host_key", ctl.AcceptHostKey)
+		ownerPermRouter.DELETE("/server/:id/host_key", ctl.ResetHostKey)
+		ownerPermRouter.POST("/server/known_hosts", ctl.ImportKnownHosts)
+		//登录凭证
+		ownerPermRouter.GET("/credential", ctl.CredentialList)
+		ownerPermRouter.POST("/credential", ctl.CredentialCreate)
+		ownerPermRouter.PUT("/credential", ctl.CredentialUpdate)
+		ownerPermRouter.DELETE("/credential/:id", ctl.CredentialDelete)
+		//加入正在进行的终端会话
+		ownerPermRouter.GET("/terminal_session/live", ctl.LiveSessions)
+		ownerPermRouter.GET("/terminal_session/:id/attach", ctl.Attach)
+		//终端策略，只读角色可以包含owner，只有超级管理员可以修改
+		ownerPermRouter.GET("/terminal_policy", ctl.TerminalPolicy)
+		superPermRouter.PUT("/terminal_policy", ctl.TerminalPolicyUpdate)
+		//ssh连接池
+		superPermRouter.GET("/server/connections", ctl.Connections)
+	}
+
+	//终端录像
+	{
+		ctl := &RecordingCtl{service: recording.NewService(global.Log, global.DB, &global.Cfg.Recording)}
+		ownerPermRouter.GET("/terminal_session", ctl.List)
+		ownerPermRouter.GET("/terminal_session/:id/cast", ctl.Cast)
+	}
+
+	//服务器文件浏览
+	{
+		ctl := &FileCtl{service: files.NewService(global.Log, global.DB, global.Ssh, audit.NewService(global.Log, global.DB), &global.Cfg.Files)}
+		//可以浏览的根目录用于限制owner，只有超级管理员可以修改
+		ownerPermRouter.GET("/file_policy", ctl.Policy)
+		superPermRouter.PUT("/file_policy", ctl.PolicyUpdate)
+		ownerPermRouter.GET("/server/:id/files", ctl.List)
+		ownerPermRouter.GET("/server/:id/file", ctl.Stat)
+		ownerPermRouter.GET("/server/:id/file/download", ctl.Download)
+		ownerPermRouter.GET("/server/:id/file/content", ctl.Content)
+		ownerPermRouter.PUT("/server/:id/file/content", ctl.Save)
+		ownerPermRouter.POST("/server/:id/file/upload", ctl.Upload)
+	}
+
+	//批量执行命令
+	{
+		ctl := &AdhocCtl{service: adhoc.NewService(global.Log, global.DB, global.Ssh, serverService)}
+		ownerPermRouter.GET("/adhoc", ctl.List)
+		ownerPermRouter.POST("/adhoc", ctl.Create)
+		ownerPermRouter.GET("/adhoc/:id", ctl.Detail)
+		ownerPermRouter.POST("/adhoc/:id/stop", ctl.Stop)
+		ownerPermRouter.GET("/adhoc/:id/console", ctl.Console)
+	}
+
+	//审计日志
+	{
+		ctl := &AuditCtl{service: audit.NewService(global.Log, global.DB)}
+		ownerPermRouter.GET("/audit_log", ctl.List)
 	}
 
 	//环境管理
@@ -125,11 +213,19 @@ func apiRoutes(r *gin.RouterGroup, s *Server) {
 		masterPermRouter.DELETE("/environment/:id", ctl.Delete)
 		masterPermRouter.PUT("/environment", ctl.Update)
 		masterPermRouter.GET("/environment/options", ctl.Options)
+		//签名策略和受信任的签名公钥
+		ownerPermRouter.PUT("/environment/sign_policy", ctl.SignPolicy)
+		ownerPermRouter.GET("/signing_key", ctl.SigningKeyList)
+		ownerPermRouter.POST("/signing_key", ctl.SigningKeyCreate)
+		ownerPermRouter.DELETE("/signing_key/:id", ctl.SigningKeyDelete)
 	}
 
 	//项目管理
 	{
-		ctl := &ProjectCtl{service: project.NewService(global.DB, global.Ssh, global.Repo)}
+		ctl := &ProjectCtl{
+			service: project.NewService(global.DB, global.Ssh, global.Repo),
+			mirror:  mirror.NewService(global.Log, global.DB, global.Repo, global.Cfg.Repo.FetchInterval),
+		}
 		masterPermRouter.GET("/project", ctl.List)
 		masterPermRouter.POST("/project", ctl.Create)
 		masterPermRouter.DELETE("/project/:id", ctl.Delete)
@@ -141,6 +237,8 @@ func apiRoutes(r *gin.RouterGroup, s *Server) {
 		masterPermRouter.GET("/project/:id/branches", ctl.Branches)
 		masterPermRouter.GET("/project/:id/tags", ctl.Tags)
 		masterPermRouter.GET("/project/:id/commits", ctl.Commits)
+		masterPermRouter.POST("/project/:id/deploy_key", ctl.RotateDeployKey)
+		masterPermRouter.POST("/project/:id/fetch", ctl.Fetch)
 	}
 
 	//部署管理
@@ -149,6 +247,8 @@ func apiRoutes(r *gin.RouterGroup, s *Server) {
 		masterPermRouter.GET("/deploy", ctl.List)
 		masterPermRouter.GET("/deploy/:id", ctl.Detail)
 		masterPermRouter.POST("/deploy", ctl.Create)
+		//上传构建产物
+		masterPermRouter.POST("/deploy/artifact", ctl.UploadArtifact)
 		//审核
 		masterPermRouter.POST("/deploy/:id/audit", ctl.Audit)
 		//发布
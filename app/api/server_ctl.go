@@ -86,7 +86,135 @@ func (ctl *ServerCtl) Terminal(ctx *gin.Context) {
 	defer func() {
 		_ = wsConn.Close()
 	}()
-	if err = ctl.service.Terminal(wsConn, spaceAndId, ctx2.Username(ctx)); err != nil {
+	if err = ctl.service.Terminal(wsConn, spaceAndId, ctx2.UserId(ctx), ctx2.Username(ctx)); err != nil {
 		global.Log.Error("terminal error", zap.Error(err))
 	}
 }
+
+func (ctl *ServerCtl) HostKey(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	data, err := ctl.service.HostKey(spaceAndId)
+	response.Response(ctx, err, data)
+}
+
+func (ctl *ServerCtl) AcceptHostKey(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	params := server.AcceptHostKeyReq{SpaceId: spaceAndId.SpaceId, ID: spaceAndId.ID}
+	if err = ctx.ShouldBindJSON(&params); err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.AcceptHostKey(&params), nil)
+}
+
+func (ctl *ServerCtl) ResetHostKey(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.ResetHostKey(spaceAndId), nil)
+}
+
+func (ctl *ServerCtl) ImportKnownHosts(ctx *gin.Context) {
+	params := server.ImportKnownHostsReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	if err := ctx.ShouldBindJSON(&params); err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	data, err := ctl.service.ImportKnownHosts(&params)
+	response.Response(ctx, err, data)
+}
+
+func (ctl *ServerCtl) CredentialList(ctx *gin.Context) {
+	params := server.CredentialListReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBind(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	total, items, err := ctl.service.CredentialList(&params)
+	response.PageData(ctx, total, items, err)
+}
+
+func (ctl *ServerCtl) CredentialCreate(ctx *gin.Context) {
+	params := server.CredentialCreateReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.CredentialCreate(&params), nil)
+}
+
+func (ctl *ServerCtl) CredentialUpdate(ctx *gin.Context) {
+	params := server.CredentialUpdateReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.CredentialUpdate(&params), nil)
+}
+
+func (ctl *ServerCtl) CredentialDelete(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.CredentialDelete(spaceAndId), nil)
+}
+
+func (ctl *ServerCtl) Connections(ctx *gin.Context) {
+	response.Response(ctx, nil, ctl.service.Connections())
+}
+
+func (ctl *ServerCtl) TerminalPolicy(ctx *gin.Context) {
+	data, err := ctl.service.TerminalPolicy(ctx2.GetSpaceId(ctx))
+	response.Response(ctx, err, data)
+}
+
+func (ctl *ServerCtl) TerminalPolicyUpdate(ctx *gin.Context) {
+	params := server.TerminalPolicyReq{SpaceId: ctx2.GetSpaceId(ctx)}
+	err := ctx.ShouldBindJSON(&params)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	response.Response(ctx, ctl.service.TerminalPolicyUpdate(&params), nil)
+}
+
+// LiveSessions 正在进行的终端会话
+func (ctl *ServerCtl) LiveSessions(ctx *gin.Context) {
+	response.Success(ctx, ctl.service.LiveSessions(ctx2.GetSpaceId(ctx)))
+}
+
+// Attach websocket 加入终端会话，mode=copilot时请求协助输入
+func (ctl *ServerCtl) Attach(ctx *gin.Context) {
+	spaceAndId, err := ctx2.GetSpaceWithId(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	wsConn, err := ctx2.UpGrader(ctx)
+	if err != nil {
+		response.Fail(ctx, errcode.ErrServer.Wrap(err))
+		return
+	}
+	defer func() {
+		_ = wsConn.Close()
+	}()
+	copilot := ctx.Query("mode") == "copilot"
+	if err = ctl.service.Attach(wsConn, spaceAndId, ctx2.UserId(ctx), ctx2.Username(ctx), copilot); err != nil {
+		global.Log.Error("terminal attach error", zap.Error(err))
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/internal/response"
+	"go-walle/app/service/webhook"
+	"strconv"
+)
+
+type WebhookCtl struct {
+	service *webhook.Service
+}
+
+// Receive 接收github，gitlab，gitea的push/tag事件
+func (ctl *WebhookCtl) Receive(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	body, err := ctx.GetRawData()
+	if err != nil {
+		response.Fail(ctx, errcode.ErrInvalidParams.Wrap(err))
+		return
+	}
+	res, err := ctl.service.Receive(ctx.Param("provider"), int64(id), ctx.Request.Header, body)
+	response.Response(ctx, err, res)
+}
@@ -6,6 +6,7 @@ import (
 	"go-walle/app/pkg/jwt"
 	"go-walle/app/pkg/log"
 	"go-walle/app/pkg/repo"
+	"go-walle/app/pkg/secret"
 	"go-walle/app/pkg/ssh"
 )
 
@@ -20,6 +21,14 @@ type Config struct {
 	JWT  jwt.Config
 	Log  log.Config
 	Ssh  ssh.Config
+
+	Secret secret.Config
+
+	Notice NoticeConfig
+
+	Recording RecordingConfig
+
+	Files FilesConfig
 }
 
 func (c *Config) Init() {
@@ -27,6 +36,7 @@ func (c *Config) Init() {
 	errs := errs2.Group{}
 	errs.Add(
 		initLog(&c.Log),
+		initSecret(&c.Secret),
 		initDB(&c.Db),
 		initJwt(&c.JWT),
 		initRepo(&c.Repo),
@@ -35,4 +45,7 @@ func (c *Config) Init() {
 	if errs.Err() != nil {
 		panic(errs.Err())
 	}
+	if err := checkSecret(); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,8 @@
+package global
+
+// FilesConfig 服务器文件浏览
+type FilesConfig struct {
+	MaxDownload int64 `help:"服务器文件浏览下载文件大小限制，单位MB" default:"100"`
+	MaxUpload   int64 `help:"服务器文件浏览上传文件大小限制，单位MB" default:"100"`
+	MaxEdit     int64 `help:"在线编辑文本文件大小限制，单位KB" default:"1024"`
+}
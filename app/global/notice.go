@@ -0,0 +1,26 @@
+package global
+
+import "time"
+
+// NoticeConfig 上线单事件通知，包括项目配置的机器人、邮件和webhook
+type NoticeConfig struct {
+	Url          string        `help:"gowalle访问地址，用于生成通知中的链接，如https://walle.example.com" default:""`
+	Timeout      time.Duration `help:"发送通知超时时间" default:"10s"`
+	AllowPrivate bool          `help:"允许通知和webhook推送到内网地址" default:"false"`
+	Email        EmailConfig
+	Webhook      WebhookConfig
+}
+
+type EmailConfig struct {
+	Host       string `help:"smtp服务器地址，为空则不发送邮件" default:""`
+	Port       int    `help:"smtp服务器端口" default:"465"`
+	Username   string `help:"smtp帐号" default:""`
+	Password   string `help:"smtp密码" default:""`
+	From       string `help:"发件人，如：gowalle <walle@example.com>" default:""`
+	Encryption string `help:"加密方式,可选[none|starttls|tls]" default:"tls"`
+}
+
+type WebhookConfig struct {
+	Retries int           `help:"webhook推送失败后的重试次数" default:"3"`
+	Backoff time.Duration `help:"webhook首次重试的等待时间，之后每次翻倍" default:"5s"`
+}
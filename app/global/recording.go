@@ -0,0 +1,10 @@
+package global
+
+import "time"
+
+// RecordingConfig web终端会话录像
+type RecordingConfig struct {
+	Dir           string        `help:"web终端录像保存目录" devDefault:"$ROOT/recordings" default:"/var/lib/walle/recordings"`
+	Retention     time.Duration `help:"web终端录像保留时间，为0时一直保留" default:"2160h0m0s"`
+	CleanInterval time.Duration `help:"清理过期录像的间隔" default:"1h0m0s"`
+}
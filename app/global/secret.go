@@ -0,0 +1,45 @@
+package global
+
+import (
+	"go-walle/app/model"
+	"go-walle/app/model/field"
+	"go-walle/app/pkg/secret"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+var Secret *secret.Secret
+
+func initSecret(conf *secret.Config) (err error) {
+	Secret, err = secret.NewSecret(conf)
+	secret.SetDefault(Secret)
+	return
+}
+
+// checkSecret 未配置加密key时，数据库中已有加密数据则拒绝启动
+func checkSecret() error {
+	if Secret.Enabled() {
+		return nil
+	}
+	secretType := reflect.TypeOf(field.Secret(""))
+	for _, m := range []any{&model.Project{}, &model.Credential{}, &model.Webhook{}} {
+		stmt := &gorm.Statement{DB: DB}
+		if err := stmt.Parse(m); err != nil {
+			return err
+		}
+		for _, f := range stmt.Schema.Fields {
+			if f.FieldType != secretType {
+				continue
+			}
+			var count int64
+			err := DB.Table(stmt.Schema.Table).Where(f.DBName+" LIKE ?", "enc:%").Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return secret.Error.New("数据库中已有加密数据，请将secret.key配置为升级前加密这些数据时使用的key")
+			}
+		}
+	}
+	return nil
+}
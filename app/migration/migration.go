@@ -52,6 +52,16 @@ func (m *Migration) createTables() error {
 		&model.Member{},
 		&model.Record{},
 		&model.Task{},
+		&model.NoticeSubscription{},
+		&model.Webhook{},
+		&model.WebhookDelivery{},
+		&model.SigningKey{},
+		&model.Credential{},
+		&model.TerminalSession{},
+		&model.TerminalPolicy{},
+		&model.AuditLog{},
+		&model.FilePolicy{},
+		&model.Adhoc{},
 	)
 }
 
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"go-walle/app/model/field"
+	"time"
+)
+
+// 批量执行状态
+const (
+	AdhocStatusRunning = iota + 1
+	AdhocStatusSuccess //所有服务器都执行成功
+	AdhocStatusFailed  //有服务器执行失败
+	AdhocStatusStopped //被手动停止
+)
+
+// Adhoc 在多台服务器上并行执行的临时命令，每台服务器的执行结果保存为Record
+type Adhoc struct {
+	ID          int64               `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	SpaceId     int64               `gorm:"column:space_id;index;not null;comment:所属空间" json:"space_id"`
+	UserId      int64               `gorm:"column:user_id;not null;comment:执行人" json:"user_id"`
+	Command     string              `gorm:"column:command;size:2000;not null;comment:执行的命令" json:"command"`
+	ServerIds   field.Slices[int64] `gorm:"column:server_ids" json:"server_ids"`
+	Concurrency int                 `gorm:"column:concurrency;not null;comment:并发数" json:"concurrency"`
+	Timeout     int                 `gorm:"column:timeout;not null;comment:每台服务器的超时时间,单位秒" json:"timeout"`
+	Status      int                 `gorm:"column:status;not null;comment:状态" json:"status"`
+	FinishedAt  *time.Time          `gorm:"column:finished_at;type:time" json:"finished_at"`
+
+	User    User      `json:"user"`
+	Records []*Record `gorm:"foreignKey:AdhocId" json:"records,omitempty"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:time;not null" json:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// 审计操作类型
+const (
+	AuditTerminalBlocked  = "terminal.blocked"  //终端命令被策略拦截
+	AuditTerminalReadOnly = "terminal.readonly" //只读终端尝试输入
+	AuditTerminalRejected = "terminal.rejected" //终端会话数超过限制
+	AuditTerminalAttach   = "terminal.attach"   //加入其他人的终端会话
+	AuditFileUpload       = "file.upload"       //上传服务器文件
+	AuditFileEdit         = "file.edit"         //编辑服务器文件
+	AuditCommandBlocked   = "command.blocked"   //终端以外执行的命令被策略拦截，如批量执行
+)
+
+// AuditLog 审计日志，记录被拦截的操作等安全相关事件
+type AuditLog struct {
+	ID       int64  `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	SpaceId  int64  `gorm:"column:space_id;index;not null;comment:所属空间" json:"space_id"`
+	UserId   int64  `gorm:"column:user_id;index;not null;comment:操作人" json:"user_id"`
+	Action   string `gorm:"column:action;size:50;index;not null;comment:操作类型" json:"action"`
+	ServerId int64  `gorm:"column:server_id;not null;default:0;comment:服务器" json:"server_id"`
+	Target   string `gorm:"column:target;size:1000;not null;default:'';comment:操作对象,如命令,文件路径" json:"target"`
+	Detail   string `gorm:"column:detail;size:1000;not null;default:'';comment:详细说明" json:"detail"`
+
+	User User `json:"user"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"go-walle/app/model/field"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Credential 服务器登录凭证，可以被空间内多台服务器共用，私钥和密码加密存储
+type Credential struct {
+	ID          int64        `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	SpaceId     int64        `gorm:"column:space_id;index;not null;comment:所属空间" json:"space_id"`
+	Name        string       `gorm:"column:name;size:100;not null;comment:名称" json:"name"`
+	Password    field.Secret `gorm:"column:password;size:500;not null;default:'';comment:登录密码,加密存储" json:"-"`
+	PrivateKey  field.Secret `gorm:"column:private_key;type:text;comment:ssh私钥,加密存储" json:"-"`
+	Passphrase  field.Secret `gorm:"column:passphrase;size:500;not null;default:'';comment:ssh私钥密码,加密存储" json:"-"`
+	PublicKey   string       `gorm:"column:public_key;type:text;comment:私钥对应的公钥" json:"public_key"`
+	Description string       `gorm:"column:description;size:500;not null;default:'';comment:简介说明" json:"description"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:time;not null" json:"updated_at"`
+
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index" json:"-"`
+}
@@ -6,6 +6,11 @@ import (
 	"time"
 )
 
+const (
+	SignPolicyNone    = 0 //不校验签名
+	SignPolicyTrusted = 1 //只发布受信任密钥签名的commit或者tag
+)
+
 type Environment struct {
 	ID          int64        `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
 	SpaceId     int64        `gorm:"column:space_id;index;not null;comment:所属空间" json:"space_id"`
@@ -13,6 +18,7 @@ type Environment struct {
 	Status      field.Status `gorm:"column:status;size:1;not null;default:0;comment:状态" json:"status"`
 	Description string       `gorm:"column:description;size:500;not null;default:'';comment:简介说明" json:"description"`
 	Color       string       `gorm:"column:color;size:10;not null;default:'';comment:主题色" json:"color"`
+	SignPolicy  int8         `gorm:"column:sign_policy;size:1;not null;default:0;comment:签名策略,0不校验1只发布受信任密钥签名的版本" json:"sign_policy"`
 
 	Space    Space      `json:"space"`
 	Projects []*Project `json:"projects"`
@@ -0,0 +1,39 @@
+package field
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"go-walle/app/pkg/secret"
+)
+
+// Secret 加密存储的字符串，写入数据库时加密，读取时解密
+type Secret string
+
+func (s *Secret) Scan(value any) error {
+	var text string
+	switch v := value.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case nil:
+		*s = ""
+		return nil
+	default:
+		return fmt.Errorf("failed to scan secret value: %v", value)
+	}
+	plain, err := secret.Decrypt(text)
+	if err != nil {
+		return err
+	}
+	*s = Secret(plain)
+	return nil
+}
+
+func (s Secret) Value() (driver.Value, error) {
+	return secret.Encrypt(string(s))
+}
+
+func (s Secret) String() string {
+	return string(s)
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// FilePolicy 空间的服务器文件浏览设置，只能访问设置的根目录，没有设置时不能浏览文件
+type FilePolicy struct {
+	ID      int64  `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	SpaceId int64  `gorm:"column:space_id;uniqueIndex;not null;comment:所属空间" json:"space_id"`
+	Roots   string `gorm:"column:roots;type:text;comment:允许访问的根目录,每行一个绝对路径" json:"roots"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:time;not null" json:"updated_at"`
+}
+
+// RootList 允许访问的根目录
+func (m *FilePolicy) RootList() []string {
+	roots := make([]string, 0)
+	for _, root := range strings.Split(m.Roots, "\n") {
+		if root = strings.TrimSpace(root); root != "" {
+			roots = append(roots, path.Clean(root))
+		}
+	}
+	return roots
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"go-walle/app/model/field"
+	"time"
+)
+
+// NoticeSubscription 用户通知订阅设置，没有记录时默认订阅
+type NoticeSubscription struct {
+	ID     int64        `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	UserId int64        `gorm:"column:user_id;not null;uniqueIndex:user_event;comment:用户" json:"user_id"`
+	Event  string       `gorm:"column:event;size:50;not null;uniqueIndex:user_event;comment:通知事件" json:"event"`
+	Email  field.Status `gorm:"column:email;size:1;not null;default:1;comment:是否接收邮件" json:"email"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:time;not null" json:"updated_at"`
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"go-walle/app/model/field"
+	"go-walle/app/pkg/repo"
 	"gorm.io/gorm"
 	"time"
 )
@@ -16,11 +17,27 @@ type Project struct {
 	RepoUrl      string `gorm:"column:repo_url;size:500;not null;comment:仓库地址" json:"repo_url"`
 	RepoMode     string `gorm:"column:repo_mode;size:10;not null;default:tag;comment:分支类型" json:"repo_mode"` // tag/branch
 	RepoUsername string `gorm:"column:repo_username;size:100;not null;default:'';comment:仓库用户" json:"repo_username"`
-	RepoPassword string `gorm:"column:repo_password;size:100;not null;default:'';comment:仓库密码" json:"repo_password"`
-	RepoType     string `gorm:"column:repo_type;size:20;not null;default:git;comment:仓库类型" json:"repo_type"`
+	RepoAuthType string `gorm:"column:repo_auth_type;size:10;not null;default:'';comment:仓库授权方式,为空使用全局配置" json:"repo_auth_type"` // basic/token/ssh
+
+	RepoPassword   field.Secret `gorm:"column:repo_password;size:500;not null;default:'';comment:仓库密码或token,加密存储" json:"-"`
+	RepoPrivateKey field.Secret `gorm:"column:repo_private_key;type:text;comment:仓库ssh私钥,加密存储" json:"-"`
+	RepoPassphrase field.Secret `gorm:"column:repo_passphrase;size:500;not null;default:'';comment:仓库ssh私钥密码,加密存储" json:"-"`
+
+	RepoSubmodules int8   `gorm:"column:repo_submodules;size:1;not null;default:0;comment:检出时是否更新子模块" json:"repo_submodules"`
+	RepoLfs        int8   `gorm:"column:repo_lfs;size:1;not null;default:0;comment:检出时是否拉取LFS文件" json:"repo_lfs"`
+	RepoBackend    string `gorm:"column:repo_backend;size:10;not null;default:'';comment:git实现方式,为空使用全局配置" json:"repo_backend"`
+	RepoSubDir     string `gorm:"column:repo_sub_dir;size:200;not null;default:'';comment:仓库子目录,只构建打包该目录" json:"repo_sub_dir"`
+
+	FetchedAt *time.Time `gorm:"column:fetched_at;comment:仓库最后同步时间" json:"fetched_at"`
+
+	DeployPublicKey  string       `gorm:"column:deploy_public_key;size:200;not null;default:'';comment:部署公钥" json:"deploy_public_key"`
+	DeployPrivateKey field.Secret `gorm:"column:deploy_private_key;type:text;comment:部署私钥,加密存储" json:"-"`
+	RepoType         string       `gorm:"column:repo_type;size:20;not null;default:git;comment:仓库类型" json:"repo_type"`
 
 	Excludes  string `gorm:"column:excludes;size:1000;not null;default:'';comment:包含或者去除的文件列表" json:"excludes"` //包含或者去除的文件
 	IsInclude int8   `gorm:"column:is_include;size:1;not null;default:0;comment:1去除0包含" json:"is_include"`      //是包含还是去除
+	//构建产物，每行一个，支持用=>映射到发布目录中的其他位置，为空时发布整个检出目录
+	ArtifactPaths string `gorm:"column:artifact_paths;size:1000;not null;default:'';comment:构建产物路径" json:"artifact_paths"`
 
 	TaskVars    string `gorm:"column:task_vars;size:1000;not null;default:'';comment:全局环境变量" json:"task_vars"` //全局变量
 	PrevDeploy  string `gorm:"column:prev_deploy;size:1000;not null;default:'';comment:编译前操作命令" json:"prev_deploy"`
@@ -39,6 +56,12 @@ type Project struct {
 	NoticeType string `gorm:"column:notice_type" json:"notice_type"`
 	NoticeHook string `gorm:"column:notice_hook" json:"notice_hook"`
 
+	NoticeSecret field.Secret `gorm:"column:notice_secret;size:500;not null;default:'';comment:通知机器人签名密钥,加密存储" json:"-"`
+
+	HookSecret  field.Secret `gorm:"column:hook_secret;size:500;not null;default:'';comment:webhook签名密钥,加密存储" json:"-"`
+	HookRefs    string       `gorm:"column:hook_refs;size:1000;not null;default:'';comment:webhook触发分支或tag规则" json:"hook_refs"` //每行一个规则，如refs/heads/main，refs/tags/v*
+	HookRelease int8         `gorm:"column:hook_release;size:1;not null;default:0;comment:webhook创建的上线单是否自动发布" json:"hook_release"`
+
 	Space       Space       `json:"space"`
 	Environment Environment `json:"environment"`
 	Servers     []Server    `gorm:"many2many:project_server" json:"servers"`
@@ -50,3 +73,19 @@ type Project struct {
 
 	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index" json:"-"`
 }
+
+// RepoOptions 项目单独设置的仓库参数，覆盖全局配置
+func (p *Project) RepoOptions() *repo.Options {
+	return &repo.Options{
+		AuthType:   repo.AuthType(p.RepoAuthType),
+		Username:   p.RepoUsername,
+		Password:   p.RepoPassword.String(),
+		PrivateKey: p.RepoPrivateKey.String(),
+		Passphrase: p.RepoPassphrase.String(),
+		DeployKey:  p.DeployPrivateKey.String(),
+		Submodules: p.RepoSubmodules == 1,
+		Lfs:        p.RepoLfs == 1,
+		Backend:    p.RepoBackend,
+		SubDir:     repo.CleanSubDir(p.RepoSubDir),
+	}
+}
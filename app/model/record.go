@@ -13,6 +13,7 @@ const (
 	RecordTypePrevRelease
 	RecordTypeRelease
 	RecordTypePostRelease
+	RecordTypeAdhoc //批量执行命令
 )
 
 type Record struct {
@@ -27,6 +28,8 @@ type Record struct {
 	Command  string               `gorm:"column:command" json:"command"`
 	Output   string               `gorm:"column:output" json:"output"`
 
+	AdhocId int64 `gorm:"column:adhoc_id;index;not null;default:0;comment:批量执行id" json:"adhoc_id"`
+
 	Server Server `json:"server"`
 
 	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
@@ -16,6 +16,13 @@ type Server struct {
 	Port        int          `gorm:"column:port;size:4;not null;default:22;comment:端口" json:"port"`
 	Status      field.Status `gorm:"column:status;size:1;not null;default:0;comment:状态" json:"status"`
 	Description string       `gorm:"column:description;size:500;not null;default:'';comment:简介说明" json:"description"`
+	HostKey     string       `gorm:"column:host_key;size:1000;not null;default:'';comment:信任的主机公钥,为空时信任首次连接的公钥" json:"host_key"`
+
+	CredentialId int64       `gorm:"column:credential_id;not null;default:0;comment:登录凭证,为0时使用默认私钥" json:"credential_id"`
+	Credential   *Credential `json:"credential,omitempty"`
+	JumpServerId int64       `gorm:"column:jump_server_id;not null;default:0;comment:跳板机,为0时直接连接" json:"jump_server_id"`
+	JumpServer   *Server     `json:"jump_server,omitempty"`
+	Bandwidth    int64       `gorm:"column:bandwidth;not null;default:0;comment:sftp上传带宽限制,单位KB/s,0不限制" json:"bandwidth"`
 
 	Projects []*Project `gorm:"many2many:project_server" json:"projects"`
 	Tasks    []*Task    `gorm:"many2many:task_server" json:"tasks"`
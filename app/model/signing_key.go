@@ -0,0 +1,24 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// SigningKey 空间内受信任的commit/tag签名公钥，环境开启签名策略时发布前校验
+type SigningKey struct {
+	ID          int64  `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	SpaceId     int64  `gorm:"column:space_id;index;not null;comment:所属空间" json:"space_id"`
+	UserId      int64  `gorm:"column:user_id;not null;comment:添加人" json:"user_id"`
+	Name        string `gorm:"column:name;size:100;not null;comment:名称" json:"name"`
+	Type        string `gorm:"column:type;size:10;not null;comment:类型gpg/ssh" json:"type"`
+	PublicKey   string `gorm:"column:public_key;type:text;comment:公钥" json:"public_key"`
+	Fingerprint string `gorm:"column:fingerprint;size:100;not null;comment:指纹" json:"fingerprint"`
+
+	User User `json:"user"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:time;not null" json:"updated_at"`
+
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index" json:"-"`
+}
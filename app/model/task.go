@@ -32,6 +32,12 @@ type Task struct {
 	IsRollback  int                 `gorm:"column:is_rollback" json:"is_rollback"`
 	LastError   string              `gorm:"column:last_error" json:"last_error"`
 	AuditUserId int64               `gorm:"column:audit_user_id" json:"audit_user_id"`
+	StartedAt   *time.Time          `gorm:"column:started_at;comment:开始发布时间" json:"started_at"`
+	FinishedAt  *time.Time          `gorm:"column:finished_at;comment:发布完成时间" json:"finished_at"`
+
+	//构建产物地址和sha256，项目类型为构建产物时使用
+	ArtifactUrl      string `gorm:"column:artifact_url;size:500;not null;default:'';comment:构建产物地址" json:"artifact_url"`
+	ArtifactChecksum string `gorm:"column:artifact_checksum;size:100;not null;default:'';comment:构建产物sha256" json:"artifact_checksum"`
 
 	Project     Project     `json:"project"`
 	User        User        `json:"user"`
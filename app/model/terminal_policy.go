@@ -0,0 +1,30 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// TerminalPolicy 空间的web终端命令策略，每个空间一条，没有设置时不做限制
+type TerminalPolicy struct {
+	ID            int64  `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	SpaceId       int64  `gorm:"column:space_id;uniqueIndex;not null;comment:所属空间" json:"space_id"`
+	DenyCommands  string `gorm:"column:deny_commands;type:text;comment:禁止执行的命令,每行一个正则" json:"deny_commands"`
+	AllowCommands string `gorm:"column:allow_commands;type:text;comment:允许执行的命令,每行一个正则,为空时不限制" json:"allow_commands"`
+	ReadOnlyRoles string `gorm:"column:read_only_roles;size:100;not null;default:'';comment:只读角色,逗号分隔" json:"read_only_roles"`
+	MaxDuration   int    `gorm:"column:max_duration;not null;default:0;comment:单次会话最长时间,单位分钟,为0时不限制" json:"max_duration"`
+	MaxSessions   int    `gorm:"column:max_sessions;not null;default:0;comment:每个用户同时打开的会话数,为0时不限制" json:"max_sessions"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:time;not null" json:"updated_at"`
+}
+
+// IsReadOnly 该角色只能查看终端输出，不能输入
+func (m *TerminalPolicy) IsReadOnly(role string) bool {
+	for _, r := range strings.Split(m.ReadOnlyRoles, ",") {
+		if strings.TrimSpace(r) == role {
+			return true
+		}
+	}
+	return false
+}
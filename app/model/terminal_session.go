@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// TerminalSession web终端会话，会话内容按asciinema v2格式录像保存在文件中
+type TerminalSession struct {
+	ID        int64      `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	SpaceId   int64      `gorm:"column:space_id;index;not null;comment:所属空间" json:"space_id"`
+	UserId    int64      `gorm:"column:user_id;index;not null;comment:操作人" json:"user_id"`
+	ServerId  int64      `gorm:"column:server_id;index;not null;comment:服务器" json:"server_id"`
+	Cols      int        `gorm:"column:cols;not null;default:0;comment:终端宽度" json:"cols"`
+	Rows      int        `gorm:"column:rows;not null;default:0;comment:终端高度" json:"rows"`
+	File      string     `gorm:"column:file;size:200;not null;default:'';comment:录像文件,相对于录像目录" json:"-"`
+	Size      int64      `gorm:"column:size;not null;default:0;comment:录像文件大小" json:"size"`
+	StartedAt time.Time  `gorm:"column:started_at;index;not null;comment:开始时间" json:"started_at"`
+	EndedAt   *time.Time `gorm:"column:ended_at;comment:结束时间" json:"ended_at"`
+
+	User   User   `json:"user"`
+	Server Server `json:"server"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"go-walle/app/model/field"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Webhook 上线单事件推送地址，ProjectId为0时推送空间下所有项目的事件
+type Webhook struct {
+	ID        int64                `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	SpaceId   int64                `gorm:"column:space_id;index;not null;comment:所属空间" json:"space_id"`
+	ProjectId int64                `gorm:"column:project_id;not null;default:0;comment:所属项目,0为空间下所有项目" json:"project_id"`
+	Name      string               `gorm:"column:name;size:100;not null;comment:名称" json:"name"`
+	Url       string               `gorm:"column:url;size:500;not null;comment:推送地址" json:"url"`
+	Secret    field.Secret         `gorm:"column:secret;size:500;not null;default:'';comment:签名密钥,加密存储" json:"-"`
+	Events    field.Slices[string] `gorm:"column:events;size:500;comment:推送事件,为空推送所有事件" json:"events"`
+	Status    field.Status         `gorm:"column:status;size:1;not null;default:0;comment:状态" json:"status"`
+
+	Project Project `json:"project"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:time;not null" json:"updated_at"`
+
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index" json:"-"`
+}
+
+// WebhookDelivery webhook推送记录
+type WebhookDelivery struct {
+	ID         int64        `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	WebhookId  int64        `gorm:"column:webhook_id;index;not null;comment:所属webhook" json:"webhook_id"`
+	TaskId     int64        `gorm:"column:task_id;not null;default:0;comment:上线单" json:"task_id"`
+	Event      string       `gorm:"column:event;size:50;not null;comment:事件" json:"event"`
+	Payload    string       `gorm:"column:payload;type:text;comment:推送内容" json:"payload"`
+	Status     field.Status `gorm:"column:status;size:1;not null;default:0;comment:1成功2失败" json:"status"`
+	StatusCode int          `gorm:"column:status_code;not null;default:0;comment:http状态码" json:"status_code"`
+	Response   string       `gorm:"column:response;type:text;comment:响应内容" json:"response"`
+	Error      string       `gorm:"column:error;size:1000;not null;default:'';comment:错误信息" json:"error"`
+	Attempts   int          `gorm:"column:attempts;not null;default:0;comment:推送次数" json:"attempts"`
+	Duration   int64        `gorm:"column:duration;not null;default:0;comment:最后一次推送耗时ms" json:"duration"`
+
+	CreatedAt time.Time `gorm:"column:created_at;type:time;not null" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:time;not null" json:"updated_at"`
+}
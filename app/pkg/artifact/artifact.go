@@ -0,0 +1,182 @@
+package artifact
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Path 构建产物路径，Src为相对于检出目录的路径，可以使用通配符，Dest为在发布目录中的路径
+type Path struct {
+	Src  string
+	Dest string
+	//使用=>重新映射了路径
+	mapped bool
+}
+
+// ParsePaths 每行一个产物，如dist/，bin/app，configs/*.yaml，也可以用=>重新映射路径，如dist/ => public/，
+// 使用通配符时匹配到的文件放到映射的目录中，以#开头的行为注释
+func ParsePaths(s string) ([]Path, error) {
+	res := make([]Path, 0)
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		src, dest, ok := strings.Cut(line, "=>")
+		if !ok {
+			dest = src
+		}
+		item := Path{Src: cleanPath(src), Dest: cleanPath(dest), mapped: ok}
+		if item.Src == "" || item.Dest == "" {
+			return nil, fmt.Errorf("产物路径错误：%s", line)
+		}
+		if _, err := filepath.Match(item.Src, ""); err != nil {
+			return nil, fmt.Errorf("产物路径错误：%s：%w", line, err)
+		}
+		if ok && hasMeta(item.Dest) {
+			return nil, fmt.Errorf("映射的路径不能使用通配符：%s", line)
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
+// cleanPath 转换为相对路径，不能跳出所在目录，根目录时返回空
+func cleanPath(p string) string {
+	p = strings.TrimSpace(strings.ReplaceAll(p, "\\", "/"))
+	if p == "" {
+		return ""
+	}
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	return filepath.FromSlash(p)
+}
+
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[`)
+}
+
+// Collect 将产物复制到destDir中对应的位置，保留文件权限和软链接，产物路径中不能有指向srcDir之外的软链接
+func Collect(srcDir, destDir string, paths []Path) error {
+	root, err := filepath.EvalSymlinks(srcDir)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, item := range paths {
+		matches, err := match(root, item)
+		if err != nil {
+			return err
+		}
+		for _, src := range matches {
+			if err = checkInside(root, src); err != nil {
+				return fmt.Errorf("产物%s%w", item.Src, err)
+			}
+			dest := filepath.Join(destDir, item.Dest)
+			if hasMeta(item.Src) {
+				//未映射时保持原来的位置，映射时放到映射的目录中
+				dest = filepath.Join(destDir, strings.TrimPrefix(src, root+string(filepath.Separator)))
+				if item.mapped {
+					dest = filepath.Join(destDir, item.Dest, filepath.Base(src))
+				}
+			}
+			if err = copyTree(src, dest); err != nil {
+				return fmt.Errorf("复制产物%s出错：%w", item.Src, err)
+			}
+		}
+	}
+	return nil
+}
+
+// match 产物对应的所有文件
+func match(root string, item Path) ([]string, error) {
+	src := filepath.Join(root, item.Src)
+	if !hasMeta(item.Src) {
+		if _, err := os.Lstat(src); err != nil {
+			return nil, fmt.Errorf("产物%s不存在：%w", item.Src, err)
+		}
+		return []string{src}, nil
+	}
+	matches, err := filepath.Glob(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("产物%s没有匹配的文件", item.Src)
+	}
+	return matches, nil
+}
+
+// checkInside 检查产物所在的目录在root中，产物本身是软链接时原样复制，不需要检查
+func checkInside(root, file string) error {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.New("不在检出目录中")
+	}
+	return nil
+}
+
+func copyTree(src, dest string) error {
+	return filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		return copyFile(file, filepath.Join(dest, rel), d)
+	})
+}
+
+func copyFile(src, dest string, d fs.DirEntry) error {
+	if d.IsDir() {
+		return os.MkdirAll(dest, os.ModePerm)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	//多个产物映射到同一位置时后面的覆盖前面的
+	_ = os.Remove(dest)
+	if d.Type()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dest)
+	}
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	w, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
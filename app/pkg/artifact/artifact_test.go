@@ -0,0 +1,184 @@
+package artifact
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParsePaths(t *testing.T) {
+	p := filepath.FromSlash
+	cases := []struct {
+		name  string
+		input string
+		want  []Path
+		err   bool
+	}{
+		{"空", " \n\n", []Path{}, false},
+		{"注释和空行", "# 前端\n\ndist/\r\nbin/app\n", []Path{{Src: "dist", Dest: "dist"}, {Src: p("bin/app"), Dest: p("bin/app")}}, false},
+		{"映射", "dist/ => public/", []Path{{Src: "dist", Dest: "public", mapped: true}}, false},
+		{"windows分隔符", `build\app.exe=>bin\app.exe`, []Path{{Src: p("build/app.exe"), Dest: p("bin/app.exe"), mapped: true}}, false},
+		{"不能跳出检出目录", "../../etc/passwd => /etc/passwd", []Path{{Src: p("etc/passwd"), Dest: p("etc/passwd"), mapped: true}}, false},
+		{"中间的..", "dist/../../bin/./app", []Path{{Src: p("bin/app"), Dest: p("bin/app")}}, false},
+		{"通配符", "configs/*.yaml\n*.sh => scripts", []Path{
+			{Src: p("configs/*.yaml"), Dest: p("configs/*.yaml")},
+			{Src: "*.sh", Dest: "scripts", mapped: true},
+		}, false},
+		{"根目录", "./", nil, true},
+		{"跳出后的根目录", "dist => ..", nil, true},
+		{"源路径为空", "=> public", nil, true},
+		{"目标路径为空", "dist =>", nil, true},
+		{"通配符错误", "dist/[a-", nil, true},
+		{"映射的路径不能使用通配符", "dist/*.js => public/*.js", nil, true},
+	}
+	for _, c := range cases {
+		got, err := ParsePaths(c.input)
+		if (err != nil) != c.err {
+			t.Errorf("%s：%v", c.name, err)
+			continue
+		}
+		if !c.err && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s：%+v", c.name, got)
+		}
+	}
+}
+
+// files destDir中所有的文件，软链接返回链接的目标
+func files(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	res := make(map[string]string)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		rel = filepath.ToSlash(rel)
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(file)
+			res[rel] = "-> " + link
+			return err
+		}
+		data, err := os.ReadFile(file)
+		res[rel] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestCollect(t *testing.T) {
+	root := t.TempDir()
+	srcDir := filepath.Join(root, "src")
+	outside := filepath.Join(root, "outside")
+	write := func(name, content string) {
+		name = filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("dist/index.html", "index")
+	write("dist/js/app.js", "app")
+	write("bin/app", "binary")
+	write("configs/app.yaml", "app config")
+	write("configs/db.yaml", "db config")
+	write("configs/readme.md", "readme")
+	write("build.sh", "build")
+	write("deploy.sh", "deploy")
+	if err := os.Chmod(filepath.Join(srcDir, "bin/app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(outside, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"dist/current": "index.html",
+		"escape":       outside,
+		"configs/out":  "../../outside",
+	} {
+		if err := os.Symlink(target, filepath.Join(srcDir, filepath.FromSlash(link))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		paths string
+		want  map[string]string
+		err   string
+	}{
+		{"目录和文件", "dist/\nbin/app", map[string]string{
+			"dist/index.html": "index", "dist/js/app.js": "app", "dist/current": "-> index.html", "bin/app": "binary",
+		}, ""},
+		{"映射", "dist => public\nbin/app => app", map[string]string{
+			"public/index.html": "index", "public/js/app.js": "app", "public/current": "-> index.html", "app": "binary",
+		}, ""},
+		{"通配符保持原来的位置", "configs/*.yaml", map[string]string{
+			"configs/app.yaml": "app config", "configs/db.yaml": "db config",
+		}, ""},
+		{"通配符映射到目录", "*.sh => scripts\nconfigs/[ad]*.yaml => etc", map[string]string{
+			"scripts/build.sh": "build", "scripts/deploy.sh": "deploy", "etc/app.yaml": "app config", "etc/db.yaml": "db config",
+		}, ""},
+		{"后面的覆盖前面的", "configs/app.yaml => app.yaml\nconfigs/db.yaml => app.yaml", map[string]string{
+			"app.yaml": "db config",
+		}, ""},
+		{"指向外部的软链接原样复制", "escape\nconfigs/out", map[string]string{
+			"escape": "-> " + outside, "configs/out": "-> ../../outside",
+		}, ""},
+		{"产物不存在", "dist/missing.js", nil, "不存在"},
+		{"通配符没有匹配", "configs/*.json", nil, "没有匹配"},
+		{"..不能跳出检出目录", "../outside/secret", nil, "不存在"},
+		{"通过软链接跳出检出目录", "escape/secret", nil, "不在检出目录中"},
+		{"通过相对软链接跳出检出目录", "configs/out/secret", nil, "不在检出目录中"},
+		{"通配符通过软链接跳出检出目录", "escape/* => leaked", nil, "不在检出目录中"},
+	}
+	for i, c := range cases {
+		paths, err := ParsePaths(c.paths)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		destDir := filepath.Join(root, "dest", strings.Repeat("x", i+1))
+		err = Collect(srcDir, destDir, paths)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s：%v", c.name, err)
+			}
+			if got := files(t, destDir); got["secret"] != "" || got["leaked/secret"] != "" {
+				t.Errorf("%s：复制了检出目录之外的文件", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s：%v", c.name, err)
+			continue
+		}
+		if got := files(t, destDir); !reflect.DeepEqual(got, c.want) {
+			keys := make([]string, 0, len(got))
+			for k := range got {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			t.Errorf("%s：%v", c.name, keys)
+		}
+	}
+
+	//保留文件权限
+	destDir := filepath.Join(root, "mode")
+	paths, _ := ParsePaths("bin/app")
+	if err := Collect(srcDir, destDir, paths); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(destDir, "bin/app")); err != nil || info.Mode().Perm() != 0755 {
+		t.Error("没有保留文件权限", err)
+	}
+}
@@ -0,0 +1,125 @@
+package asciicast
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zeebo/errs"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var Error = errs.Class("asciicast")
+
+// 事件类型
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+)
+
+// Header asciinema v2格式的文件头，见https://docs.asciinema.org/manual/asciicast/v2/
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Writer 按asciinema v2格式写入终端会话，每行一个事件：[相对开始的秒数, 类型, 数据]，可并发写入
+type Writer struct {
+	mux    sync.Mutex
+	w      io.Writer
+	start  time.Time
+	closed bool
+	//输出和输入末尾不完整的utf8字符，和下次写入的数据一起记录
+	output []byte
+	input  []byte
+}
+
+// NewWriter 写入文件头，header.Version和Timestamp为空时自动填充
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	start := time.Now()
+	if header.Version == 0 {
+		header.Version = 2
+	}
+	if header.Timestamp == 0 {
+		header.Timestamp = start.Unix()
+	}
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if _, err = w.Write(append(b, '\n')); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return &Writer{w: w, start: start}, nil
+}
+
+// WriteOutput 终端输出，一个字符被拆分到多次写入时合并后记录
+func (w *Writer) WriteOutput(data []byte) error {
+	return w.writeData(EventOutput, &w.output, data)
+}
+
+// WriteInput 终端输入，一个字符被拆分到多次写入时合并后记录
+func (w *Writer) WriteInput(data []byte) error {
+	return w.writeData(EventInput, &w.input, data)
+}
+
+func (w *Writer) writeData(typ string, pending *[]byte, data []byte) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	data = append(*pending, data...)
+	n := CompleteRunes(data)
+	*pending = append([]byte{}, data[n:]...)
+	if n == 0 {
+		return nil
+	}
+	return w.writeEvent(typ, string(data[:n]))
+}
+
+// WriteResize 终端窗口大小变化，数据格式为{cols}x{rows}
+func (w *Writer) WriteResize(cols, rows int) error {
+	return w.WriteEvent(EventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (w *Writer) WriteEvent(typ, data string) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.writeEvent(typ, data)
+}
+
+func (w *Writer) writeEvent(typ, data string) error {
+	if w.closed {
+		return nil
+	}
+	b, err := json.Marshal([]any{time.Since(w.start).Seconds(), typ, data})
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	_, err = w.w.Write(append(b, '\n'))
+	return Error.Wrap(err)
+}
+
+// Close 之后写入的事件会被忽略，不会关闭底层的io.Writer
+func (w *Writer) Close() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.closed = true
+}
+
+// CompleteRunes 去掉末尾不完整的utf8字符后的长度，不完整的部分需要和之后的数据一起处理
+func CompleteRunes(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,117 @@
+package asciicast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, Header{Width: 200, Height: 40, Env: map[string]string{"TERM": "xterm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteOutput([]byte("$ ")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteInput([]byte("ls\r")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteResize(120, 30); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err = w.WriteOutput([]byte("ignored")); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(buf)
+	if !scanner.Scan() {
+		t.Fatal("missing header")
+	}
+	header := Header{}
+	if err = json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Width != 200 || header.Height != 40 || header.Timestamp == 0 {
+		t.Fatalf("unexpected header: %+v", header)
+	}
+	expected := [][2]string{{EventOutput, "$ "}, {EventInput, "ls\r"}, {EventResize, "120x30"}}
+	lastTime := float64(0)
+	for i, want := range expected {
+		if !scanner.Scan() {
+			t.Fatalf("missing event %d", i)
+		}
+		event := make([]any, 0, 3)
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if len(event) != 3 || event[1] != want[0] || event[2] != want[1] {
+			t.Fatalf("unexpected event %d: %v", i, event)
+		}
+		if ts := event[0].(float64); ts < lastTime {
+			t.Fatalf("event time goes backwards: %v", event)
+		} else {
+			lastTime = ts
+		}
+	}
+	if scanner.Scan() {
+		t.Fatalf("unexpected event after close: %s", scanner.Text())
+	}
+}
+
+func TestWriterSplitRune(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, Header{Width: 80, Height: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//一个中文字符被拆分到多次写入，输入和输出分别合并
+	out, in := []byte("中文"), []byte("你")
+	writes := []func() error{
+		func() error { return w.WriteOutput(out[:1]) },
+		func() error { return w.WriteInput(in[:2]) },
+		func() error { return w.WriteOutput(out[1:4]) },
+		func() error { return w.WriteInput(in[2:]) },
+		func() error { return w.WriteOutput(out[4:]) },
+	}
+	for _, write := range writes {
+		if err = write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scanner := bufio.NewScanner(buf)
+	scanner.Scan()
+	expected := [][2]string{{EventOutput, "中"}, {EventInput, "你"}, {EventOutput, "文"}}
+	for i, want := range expected {
+		if !scanner.Scan() {
+			t.Fatalf("missing event %d", i)
+		}
+		event := make([]any, 0, 3)
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event[1] != want[0] || event[2] != want[1] {
+			t.Fatalf("unexpected event %d: %v", i, event)
+		}
+	}
+	if scanner.Scan() {
+		t.Fatalf("unexpected event: %s", scanner.Text())
+	}
+}
+
+func TestCompleteRunes(t *testing.T) {
+	data := []byte("a中")
+	for i, want := range []int{0, 1, 1, 1, 4} {
+		if got := CompleteRunes(data[:i]); got != want {
+			t.Errorf("CompleteRunes(%q) = %d, want %d", data[:i], got, want)
+		}
+	}
+	//无效的字节不会被保留
+	if got := CompleteRunes([]byte{'a', 0xff}); got != 2 {
+		t.Errorf("invalid byte kept: %d", got)
+	}
+}
@@ -0,0 +1,556 @@
+package repo
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go-walle/app/pkg/safehttp"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ArtifactConfig struct {
+	Timeout         time.Duration `help:"下载构建产物的超时时间" default:"10m0s"`
+	MaxSize         int64         `help:"构建产物最大字节数" default:"2147483648"`
+	MaxExtractSize  int64         `help:"构建产物解压后的最大字节数，0为不限制" default:"10737418240"`
+	MaxExtractFiles int           `help:"构建产物解压后的最大文件数，0为不限制" default:"500000"`
+}
+
+// ArtifactUploadScheme 通过接口上传的构建产物地址前缀
+const ArtifactUploadScheme = "upload://"
+
+// Artifact 直接发布CI等外部系统构建好的产物，支持tar，tar.gz和zip格式，
+// 产物通过http(s)地址下载，或者先通过接口上传，上传的文件保存在path/uploads目录
+type Artifact struct {
+	config *ArtifactConfig
+	path   string
+	url    string
+	opts   *Options
+}
+
+func NewArtifact(cfg *ArtifactConfig, url string, path string, opts *Options) (*Artifact, error) {
+	return &Artifact{config: cfg, url: url, path: path, opts: opts}, nil
+}
+
+// Url 项目默认的产物地址，上线单未指定地址时使用
+func (srv *Artifact) Url() string {
+	return srv.url
+}
+
+// Save 保存上传的产物，返回产物地址和sha256
+func (srv *Artifact) Save(name string, r io.Reader) (source, checksum string, err error) {
+	dir := filepath.Join(srv.path, "uploads")
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", "", ErrRepoArtifact.Wrap(err)
+	}
+	b := make([]byte, 8)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", ErrRepoArtifact.Wrap(err)
+	}
+	//文件名只保留最后一段，防止跳出上传目录
+	fileName := time.Now().Format("20060102150405") + "-" + hex.EncodeToString(b) + "-" + path.Base(strings.ReplaceAll(name, "\\", "/"))
+	file := filepath.Join(dir, fileName)
+	checksum, err = srv.writeFile(file, r)
+	if err != nil {
+		_ = os.Remove(file)
+		return "", "", err
+	}
+	return ArtifactUploadScheme + fileName, checksum, nil
+}
+
+// Extract 下载或者读取上传的产物，校验sha256后解压到dest目录，checksum为空时不校验
+func (srv *Artifact) Extract(source, checksum, dest string) error {
+	if source == "" {
+		source = srv.url
+	}
+	file, remove, err := srv.open(source)
+	if err != nil {
+		return err
+	}
+	if remove {
+		defer func() {
+			_ = os.Remove(file)
+		}()
+	}
+	if checksum != "" {
+		if err = verifyChecksum(file, checksum); err != nil {
+			return err
+		}
+	}
+	if err = os.MkdirAll(dest, os.ModePerm); err != nil {
+		return ErrRepoArtifact.Wrap(err)
+	}
+	limit := &archiveLimit{maxSize: srv.config.MaxExtractSize, maxFiles: srv.config.MaxExtractFiles}
+	return ErrRepoArtifact.Wrap(extractArchive(file, dest, limit))
+}
+
+// open 返回产物的本地文件，remove表示用完后是否删除
+func (srv *Artifact) open(source string) (file string, remove bool, err error) {
+	if strings.HasPrefix(source, ArtifactUploadScheme) {
+		name := strings.TrimPrefix(source, ArtifactUploadScheme)
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return "", false, ErrRepoArtifact.New("产物地址错误：%s", source)
+		}
+		//上传的产物只使用一次，用完后删除
+		return filepath.Join(srv.path, "uploads", name), true, nil
+	}
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return "", false, ErrRepoArtifact.New("产物地址只支持http(s)或者上传：%s", source)
+	}
+	file, err = srv.download(source)
+	return file, true, err
+}
+
+func (srv *Artifact) download(source string) (string, error) {
+	ctx := context.Background()
+	if srv.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, srv.config.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return "", ErrRepoArtifact.Wrap(err)
+	}
+	//只有项目配置的产物地址才发送认证信息，上线单指定的其他地址不能访问内网
+	trusted := srv.trusted(req.URL)
+	if trusted {
+		switch srv.opts.AuthType {
+		case AuthBasic:
+			req.SetBasicAuth(srv.opts.Username, srv.opts.Password)
+		case AuthToken:
+			req.Header.Set("Authorization", "Bearer "+srv.opts.Password)
+		}
+	}
+	resp, err := artifactClient(trusted).Do(req)
+	if err != nil {
+		return "", ErrRepoArtifact.Wrap(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", ErrRepoArtifact.New("下载产物失败：%s", resp.Status)
+	}
+	dir := filepath.Join(srv.path, "downloads")
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", ErrRepoArtifact.Wrap(err)
+	}
+	f, err := os.CreateTemp(dir, "artifact-*")
+	if err != nil {
+		return "", ErrRepoArtifact.Wrap(err)
+	}
+	_ = f.Close()
+	if _, err = srv.writeFile(f.Name(), resp.Body); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// trusted 地址的协议和主机与项目配置的产物地址相同
+func (srv *Artifact) trusted(u *neturl.URL) bool {
+	conf, err := neturl.Parse(srv.url)
+	if err != nil || srv.url == "" {
+		return false
+	}
+	return strings.EqualFold(conf.Scheme, u.Scheme) && strings.EqualFold(conf.Host, u.Host)
+}
+
+// artifactClient 下载产物的http客户端，不可信的地址解析到内网地址时拒绝连接，跳转后同样检查
+func artifactClient(trusted bool) *http.Client {
+	if trusted {
+		return http.DefaultClient
+	}
+	return safehttp.NewClient(0)
+}
+
+// writeFile 写入文件并计算sha256，超过最大限制时返回错误
+func (srv *Artifact) writeFile(file string, r io.Reader) (string, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return "", ErrRepoArtifact.Wrap(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if srv.config.MaxSize > 0 {
+		r = io.LimitReader(r, srv.config.MaxSize+1)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return "", ErrRepoArtifact.Wrap(err)
+	}
+	if srv.config.MaxSize > 0 && n > srv.config.MaxSize {
+		return "", ErrRepoArtifact.New("产物超过最大限制%d字节", srv.config.MaxSize)
+	}
+	return hex.EncodeToString(h.Sum(nil)), ErrRepoArtifact.Wrap(f.Close())
+}
+
+// verifyChecksum 校验sha256，checksum可以带sha256:前缀
+func verifyChecksum(file, checksum string) error {
+	expected := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(checksum), "sha256:"))
+	f, err := os.Open(file)
+	if err != nil {
+		return ErrRepoArtifact.Wrap(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return ErrRepoArtifact.Wrap(err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return ErrRepoArtifact.New("产物校验失败，期望sha256:%s，实际sha256:%s", expected, actual)
+	}
+	return nil
+}
+
+// archiveLimit 解压的总字节数和文件数限制，防止很小的压缩包解压后占满磁盘，为0时不限制
+type archiveLimit struct {
+	maxSize  int64
+	maxFiles int
+	size     int64
+	files    int
+}
+
+// addFile 压缩包内的每一项(包括目录和软链接)都计入文件数
+func (l *archiveLimit) addFile() error {
+	l.files++
+	if l.maxFiles > 0 && l.files > l.maxFiles {
+		return fmt.Errorf("压缩包内的文件数超过最大限制%d", l.maxFiles)
+	}
+	return nil
+}
+
+// reader 读取的内容计入解压后的总字节数，超过限制时返回错误
+func (l *archiveLimit) reader(r io.Reader) io.Reader {
+	return &limitReader{r: r, limit: l}
+}
+
+type limitReader struct {
+	r     io.Reader
+	limit *archiveLimit
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.limit.size += int64(n)
+	if r.limit.maxSize > 0 && r.limit.size > r.limit.maxSize {
+		return n, fmt.Errorf("压缩包解压后超过最大限制%d字节", r.limit.maxSize)
+	}
+	return n, err
+}
+
+// extractArchive 按文件头判断格式并解压
+func extractArchive(file, dest string, limit *archiveLimit) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return extractZip(f, info.Size(), dest, limit)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = gr.Close()
+		}()
+		return extractTar(gr, dest, limit)
+	}
+	return extractTar(br, dest, limit)
+}
+
+func extractTar(r io.Reader, dest string, limit *archiveLimit) error {
+	realDest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		//pax全局头保存的是git archive写入的commit等信息，不是文件
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if err = limit.addFile(); err != nil {
+			return err
+		}
+		target, err := archivePath(realDest, header.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+		if err = checkArchiveParents(realDest, target); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, os.ModePerm)
+		case tar.TypeReg:
+			err = writeArchiveFile(target, limit.reader(tr), os.FileMode(header.Mode).Perm())
+		case tar.TypeSymlink:
+			err = writeArchiveLink(realDest, target, header.Linkname)
+		default:
+			err = fmt.Errorf("压缩包内不支持的文件类型%q：%s", header.Typeflag, header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(r io.ReaderAt, size int64, dest string, limit *archiveLimit) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	realDest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if err = limit.addFile(); err != nil {
+			return err
+		}
+		target, err := archivePath(realDest, f.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+		if err = checkArchiveParents(realDest, target); err != nil {
+			return err
+		}
+		mode := f.Mode()
+		if mode.Type()&^(os.ModeDir|os.ModeSymlink) != 0 {
+			return fmt.Errorf("压缩包内不支持的文件类型%s：%s", mode.Type(), f.Name)
+		}
+		if mode.IsDir() {
+			if err = os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if mode&os.ModeSymlink != 0 {
+			var link []byte
+			if link, err = io.ReadAll(limit.reader(rc)); err == nil {
+				err = writeArchiveLink(realDest, target, string(link))
+			}
+		} else {
+			err = writeArchiveFile(target, limit.reader(rc), mode.Perm())
+		}
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath 压缩包内的文件在dest中的路径，不能跳出dest目录，根目录返回空
+func archivePath(dest, name string) (string, error) {
+	clean := CleanSubDir(name)
+	if clean == "" {
+		return "", nil
+	}
+	if p := path.Clean(strings.ReplaceAll(name, "\\", "/")); p != clean && p != "/"+clean {
+		return "", fmt.Errorf("压缩包内的路径不合法：%s", name)
+	}
+	return filepath.Join(dest, filepath.FromSlash(clean)), nil
+}
+
+// checkArchiveParents target在dest中已经存在的上级目录不能是软链接，
+// 否则可以先解压指向dest以外的软链接，再通过软链接把文件写到dest以外
+func checkArchiveParents(dest, target string) error {
+	rel, err := filepath.Rel(dest, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := dest
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("压缩包内的路径经过软链接：%s", filepath.ToSlash(rel))
+		}
+	}
+	return nil
+}
+
+func writeArchiveFile(target string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if perm == 0 {
+		perm = 0644
+	}
+	//已经存在的软链接先删除，不能通过软链接写文件
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err = os.Remove(target); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeArchiveLink 软链接只能指向dest目录内的相对路径，
+// 按已经解压的文件逐级解析，经过其他软链接后也不能跳出dest
+func writeArchiveLink(dest, target, link string) error {
+	if path.IsAbs(link) || filepath.IsAbs(link) {
+		return fmt.Errorf("压缩包内的软链接不合法：%s", link)
+	}
+	inside, err := linkInside(dest, filepath.Dir(target), link)
+	if err != nil {
+		return err
+	}
+	if !inside {
+		return fmt.Errorf("压缩包内的软链接不合法：%s", link)
+	}
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	_ = os.Remove(target)
+	return os.Symlink(link, target)
+}
+
+// maxArchiveLinks 解析软链接时最多跟随的层数
+const maxArchiveLinks = 40
+
+// linkInside 从dir解析相对路径link，不存在的部分按字面解析，判断结果是否在dest内。
+// dest需为真实路径，dir为dest内不经过软链接的目录
+func linkInside(dest, dir, link string) (bool, error) {
+	rest := strings.Split(filepath.ToSlash(link), "/")
+	links := 0
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if dir == dest {
+				return false, nil
+			}
+			dir = filepath.Dir(dir)
+			continue
+		}
+		next := filepath.Join(dir, name)
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			dir = next
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			dir = next
+			continue
+		}
+		if links++; links > maxArchiveLinks {
+			return false, nil
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return false, err
+		}
+		if filepath.IsAbs(target) {
+			return false, nil
+		}
+		rest = append(strings.Split(filepath.ToSlash(target), "/"), rest...)
+	}
+	return true, nil
+}
+
+func (srv *Artifact) Tags() ([]Tag, error) {
+	return []Tag{}, nil
+}
+
+func (srv *Artifact) Commits(branch string) ([]Commit, error) {
+	return []Commit{}, nil
+}
+
+func (srv *Artifact) Branches() ([]Branch, error) {
+	return []Branch{}, nil
+}
+
+// CheckoutToBranch 构建产物没有分支，导出项目默认地址的产物
+func (srv *Artifact) CheckoutToBranch(branch, dest string) (string, error) {
+	return "", srv.Extract("", "", dest)
+}
+
+func (srv *Artifact) CheckoutToCommit(branch, commit, dest string) (string, error) {
+	return "", srv.Extract("", "", dest)
+}
+
+func (srv *Artifact) CheckoutToTag(tag, dest string) (string, error) {
+	return "", srv.Extract("", "", dest)
+}
+
+// Fetch 构建产物在发布时下载，不需要同步
+func (srv *Artifact) Fetch() error {
+	return nil
+}
+
+func (srv *Artifact) Path() string {
+	return srv.path
+}
+
+func (srv *Artifact) Type() TypeRepo {
+	return ArtifactRepo
+}
@@ -0,0 +1,249 @@
+package repo
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testTarGz(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArtifactUpload(t *testing.T) {
+	srv, err := NewRepos(&Config{RepoDir: t.TempDir()}).New(ArtifactRepo, "", "project", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact := srv.(*Artifact)
+	data := testTarGz(t, map[string]string{"./bin/app": "app", "config.yaml": "config"})
+	source, checksum, err := artifact.Save("../../build.tar.gz", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if checksum != hex.EncodeToString(sum[:]) {
+		t.Error("sha256错误", checksum)
+	}
+	if _, err = os.Stat(filepath.Join(artifact.Path(), "uploads", source[len(ArtifactUploadScheme):])); err != nil {
+		t.Error("上传文件应保存在uploads目录", source, err)
+	}
+	dest := t.TempDir()
+	if err = artifact.Extract(source, "sha256:"+checksum, dest); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dest, "bin", "app"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Error("解压文件错误", info, err)
+	}
+	if _, err = os.Stat(filepath.Join(artifact.Path(), "uploads", source[len(ArtifactUploadScheme):])); !os.IsNotExist(err) {
+		t.Error("解压后应删除上传文件", err)
+	}
+	source, _, err = artifact.Save("build.tar.gz", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = artifact.Extract(source, "0000", t.TempDir()); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Error("校验和错误时应返回错误", err)
+	}
+}
+
+func TestArtifactDownload(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("dist/index.html")
+	_, _ = w.Write([]byte("index"))
+	_ = zw.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	srv, err := NewArtifact(&ArtifactConfig{}, ts.URL+"/build.zip", t.TempDir(), &Options{AuthType: AuthToken, Password: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if _, err = srv.CheckoutToBranch("", dest); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dest, "dist", "index.html")); err != nil || string(b) != "index" {
+		t.Error("解压文件错误", string(b), err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(srv.Path(), "downloads")); len(entries) != 0 {
+		t.Error("下载的临时文件应删除")
+	}
+}
+
+func TestArtifactDownloadUntrusted(t *testing.T) {
+	var auth []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	srv, err := NewArtifact(&ArtifactConfig{}, "https://ci.example.com/build.zip", t.TempDir(), &Options{AuthType: AuthToken, Password: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		url     string
+		trusted bool
+	}{
+		{"https://ci.example.com/other.zip", true},
+		{"https://CI.example.com/other.zip", true},
+		{"http://ci.example.com/other.zip", false},
+		{"https://ci.example.com:8443/other.zip", false},
+		{"https://evil.example.com/build.zip", false},
+	}
+	for _, c := range cases {
+		u, _ := neturl.Parse(c.url)
+		if srv.trusted(u) != c.trusted {
+			t.Error("可信地址判断错误", c.url)
+		}
+	}
+	//上线单指定的其他地址不能访问内网，也不发送认证信息
+	for _, source := range []string{ts.URL + "/build.zip", "http://localhost:1/build.zip", "http://[::1]:1/build.zip"} {
+		if err = srv.Extract(source, "", t.TempDir()); err == nil || !strings.Contains(err.Error(), "内网") {
+			t.Error("内网地址应拒绝", source, err)
+		}
+	}
+	if len(auth) != 0 {
+		t.Error("不应请求内网地址", auth)
+	}
+}
+
+func TestArtifactUnsafePath(t *testing.T) {
+	srv, _ := NewArtifact(&ArtifactConfig{}, "", t.TempDir(), &Options{})
+	data := testTarGz(t, map[string]string{"../evil": "evil"})
+	source, _, err := srv.Save("evil.tar.gz", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = srv.Extract(source, "", filepath.Join(t.TempDir(), "dest")); err == nil {
+		t.Error("跳出目录的路径应返回错误")
+	}
+}
+
+// TestArtifactSymlinkChain 通过多级软链接跳出解压目录
+func TestArtifactSymlinkChain(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, h := range []*tar.Header{
+		{Name: "d", Linkname: ".", Typeflag: tar.TypeSymlink},
+		{Name: "d/e", Linkname: "../z", Typeflag: tar.TypeSymlink},
+		{Name: "e/pwn", Mode: 0644, Size: 3, Typeflag: tar.TypeReg},
+	} {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			_, _ = tw.Write([]byte("pwn"))
+		}
+	}
+	_ = tw.Close()
+	root := t.TempDir()
+	dest := filepath.Join(root, "dest")
+	_ = os.MkdirAll(dest, os.ModePerm)
+	//解压目录以外已经存在的目录
+	_ = os.MkdirAll(filepath.Join(root, "z"), os.ModePerm)
+	if err := extractTar(buf, dest, &archiveLimit{}); err == nil {
+		t.Error("经过软链接的路径应返回错误")
+	}
+	if _, err := os.Lstat(filepath.Join(root, "z", "pwn")); err == nil {
+		t.Error("文件写到了解压目录以外")
+	}
+}
+
+func TestArtifactSymlink(t *testing.T) {
+	dest := t.TempDir()
+	for _, c := range []struct {
+		name, link string
+		ok         bool
+	}{
+		{"node_modules/.bin/app", "../app/bin/app", true},
+		{"current", ".", true},
+		{"up", "current/..", false},
+		{"x/y", "../../etc", false},
+		{"abs", "/etc/passwd", false},
+	} {
+		err := writeArchiveLink(dest, filepath.Join(dest, filepath.FromSlash(c.name)), c.link)
+		if (err == nil) != c.ok {
+			t.Errorf("%s -> %s: %v", c.name, c.link, err)
+		}
+	}
+}
+
+func TestArtifactExtractLimit(t *testing.T) {
+	newTar := func(headers ...*tar.Header) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		for _, h := range headers {
+			if err := tw.WriteHeader(h); err != nil {
+				t.Fatal(err)
+			}
+			_, _ = tw.Write(bytes.Repeat([]byte("a"), int(h.Size)))
+		}
+		_ = tw.Close()
+		return buf
+	}
+	file := func(name string, size int64) *tar.Header {
+		return &tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}
+	}
+	if err := extractTar(newTar(file("a", 10), file("b", 10)), t.TempDir(), &archiveLimit{maxSize: 20, maxFiles: 2}); err != nil {
+		t.Error("未超过限制时应解压成功", err)
+	}
+	if err := extractTar(newTar(file("a", 10), file("b", 11)), t.TempDir(), &archiveLimit{maxSize: 20}); err == nil {
+		t.Error("超过最大字节数应返回错误")
+	}
+	if err := extractTar(newTar(file("a", 1), file("b", 1), file("c", 1)), t.TempDir(), &archiveLimit{maxFiles: 2}); err == nil {
+		t.Error("超过最大文件数应返回错误")
+	}
+	//不支持的类型返回错误，不能静默跳过
+	for _, h := range []*tar.Header{
+		{Name: "hard", Linkname: "a", Typeflag: tar.TypeLink},
+		{Name: "fifo", Mode: 0644, Typeflag: tar.TypeFifo},
+		{Name: "dev", Mode: 0644, Typeflag: tar.TypeChar},
+	} {
+		if err := extractTar(newTar(file("a", 1), h), t.TempDir(), &archiveLimit{}); err == nil {
+			t.Error("不支持的文件类型应返回错误", h.Name)
+		}
+	}
+	//git archive生成的pax全局头
+	global := &tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "abc"}}
+	if err := extractTar(newTar(global, file("a", 1)), t.TempDir(), &archiveLimit{maxFiles: 1}); err != nil {
+		t.Error("pax全局头不是文件", err)
+	}
+}
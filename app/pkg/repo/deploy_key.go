@@ -0,0 +1,146 @@
+package repo
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// GenerateDeployKey 生成ed25519部署密钥，返回authorized_keys格式的公钥和OpenSSH格式的私钥
+func GenerateDeployKey(comment string) (publicKey, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", ErrRepo.Wrap(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", ErrRepo.Wrap(err)
+	}
+	block, err := marshalOpenSSHEd25519(sshPub, priv, comment)
+	if err != nil {
+		return "", "", ErrRepo.Wrap(err)
+	}
+	publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+	if comment != "" {
+		publicKey += " " + comment
+	}
+	privateKey = string(pem.EncodeToMemory(block))
+	return publicKey, privateKey, nil
+}
+
+// marshalOpenSSHEd25519 按openssh-key-v1格式编码未加密的ed25519私钥，ssh命令和ssh-keygen都只认这种格式
+func marshalOpenSSHEd25519(pub ssh.PublicKey, priv ed25519.PrivateKey, comment string) (*pem.Block, error) {
+	var check [4]byte
+	if _, err := rand.Read(check[:]); err != nil {
+		return nil, err
+	}
+	key := struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+	}{
+		Check1:  binary.BigEndian.Uint32(check[:]),
+		Check2:  binary.BigEndian.Uint32(check[:]),
+		Keytype: ssh.KeyAlgoED25519,
+		Pub:     priv.Public().(ed25519.PublicKey),
+		Priv:    priv,
+		Comment: comment,
+	}
+	privBlock := ssh.Marshal(key)
+	//私钥部分按8字节补齐，填充内容为1,2,3...
+	for i := 1; len(privBlock)%8 != 0; i++ {
+		privBlock = append(privBlock, byte(i))
+	}
+	w := struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{
+		CipherName:   "none",
+		KdfName:      "none",
+		NumKeys:      1,
+		PubKey:       pub.Marshal(),
+		PrivKeyBlock: privBlock,
+	}
+	return &pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), ssh.Marshal(w)...),
+	}, nil
+}
+
+// openSSHPrivateKey 把之前生成的PKCS#8格式ed25519部署密钥转换为ssh命令可用的OpenSSH格式，其他私钥原样返回
+func openSSHPrivateKey(key []byte) []byte {
+	block, _ := pem.Decode(key)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return key
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return key
+	}
+	priv, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return key
+	}
+	converted, err := encodeEd25519(priv)
+	if err != nil {
+		return key
+	}
+	return converted
+}
+
+func encodeEd25519(priv ed25519.PrivateKey) ([]byte, error) {
+	sshPub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	block, err := marshalOpenSSHEd25519(sshPub, priv, "")
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// unencryptedPrivateKey ssh命令行无法输入私钥密码，有密码的私钥解密后转换为无密码的私钥，只用于写入临时文件
+func unencryptedPrivateKey(key []byte, passphrase string) ([]byte, error) {
+	_, err := ssh.ParseRawPrivateKey(key)
+	if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+		return openSSHPrivateKey(key), nil
+	}
+	if passphrase == "" {
+		return nil, errors.New("私钥有密码，请设置私钥密码")
+	}
+	raw, err := ssh.ParseRawPrivateKeyWithPassphrase(key, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("私钥解密失败：%w", err)
+	}
+	switch priv := raw.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case *ed25519.PrivateKey:
+		return encodeEd25519(*priv)
+	case ed25519.PrivateKey:
+		return encodeEd25519(priv)
+	}
+	return nil, fmt.Errorf("不支持的私钥类型：%T", raw)
+}
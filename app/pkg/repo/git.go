@@ -12,6 +12,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"os"
 	"strings"
+	"sync"
 )
 
 type GitConfig struct {
@@ -21,22 +22,35 @@ type GitConfig struct {
 	PrivateKeyUsername string `help:"免密模式下，私钥证书用户" default:"git"`
 	PrivateKeyPassword string `help:"免密模式下，私钥证书密码" default:""`
 	FetchDepth         int    `help:"记录数量" default:"50"`
+	Backend            string `help:"git实现方式：go-git，cli(调用系统git命令，适合历史很大的仓库)" default:"go-git"`
 }
 
+// Git 本地保存远程仓库的bare镜像，每次发布从镜像中导出指定版本的代码到独立目录，
+// 多个上线单同时发布或者查询分支时互不影响
 type Git struct {
 	config  *GitConfig
 	path    string
 	repoUrl string
 	repo    *git.Repository
+	opts    *Options
+	lock    *sync.RWMutex
 
 	auth transport.AuthMethod //auth
+
+	submodules []Submodule //最近一次导出的子模块版本
 }
 
-func NewGit(cfg *GitConfig, url string, path string) (*Git, error) {
+// mirrorLocks 每个镜像目录一把锁，fetch时独占，读取时共享
+var mirrorLocks sync.Map
+
+func NewGit(cfg *GitConfig, url string, path string, opts *Options) (*Git, error) {
+	lock, _ := mirrorLocks.LoadOrStore(path, &sync.RWMutex{})
 	repo := &Git{
 		config:  cfg,
 		path:    path,
 		repoUrl: url,
+		opts:    opts,
+		lock:    lock.(*sync.RWMutex),
 	}
 	r, err := repo.init()
 	if err != nil {
@@ -51,7 +65,9 @@ func (srv *Git) init() (_ *Git, err error) {
 	if err != nil {
 		return
 	}
-	//读取存在的目录，项目不存在，则重新clone
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	//读取存在的镜像，不存在或者不是bare仓库(旧版本的工作目录)，则重新创建
 	srv.repo, err = git.PlainOpen(srv.path)
 	if err != nil {
 		if errors.Is(err, git.ErrRepositoryNotExists) {
@@ -62,212 +78,237 @@ func (srv *Git) init() (_ *Git, err error) {
 		}
 		return
 	}
+	cfg, err := srv.repo.Config()
+	if err != nil {
+		return
+	}
+	if !cfg.Core.IsBare {
+		if err = srv.clone(); err != nil {
+			return
+		}
+		return srv, nil
+	}
 	//检查存在项目是否跟远程地址一致
 	remote, _ := srv.repo.Remote(git.DefaultRemoteName)
-	if strings.Contains(remote.String(), srv.repoUrl) {
+	if remote != nil && strings.Contains(remote.String(), srv.repoUrl) {
 		return srv, nil
 	}
-	return nil, fmt.Errorf("dir[%s] remote:%s, not:%s", srv.path, remote.String(), srv.repoUrl)
+	return nil, fmt.Errorf("dir[%s] remote:%v, not:%s", srv.path, remote, srv.repoUrl)
 }
 
-// clone 克隆项目到目录， 如果存在目录，则删除
+// clone 创建远程仓库的bare镜像，如果存在目录，则删除
 func (srv *Git) clone() (err error) {
 	_ = os.RemoveAll(srv.path)
-	srv.repo, err = git.PlainClone(srv.path, false, &git.CloneOptions{
-		Auth:     srv.auth,
-		URL:      srv.repoUrl,
-		Progress: os.Stdout,
-	})
-	return err
-}
-
-func (srv *Git) pull(branch string) error {
-	w, err := srv.repo.Worktree()
+	srv.repo, err = git.PlainInit(srv.path, true)
 	if err != nil {
-		return err
+		return
 	}
-	err = w.Pull(&git.PullOptions{
-		Auth:          srv.auth,
-		ReferenceName: plumbing.NewBranchReferenceName(branch),
-		SingleBranch:  true,
-		Progress:      os.Stdout,
+	_, err = srv.repo.CreateRemote(&gitConfig.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{srv.repoUrl},
+		Fetch: mirrorRefSpecs,
 	})
-	if errors.Is(err, git.NoErrAlreadyUpToDate) {
-		return nil
+	if err != nil {
+		return
 	}
-	if errors.Is(err, plumbing.ErrReferenceNotFound) {
-		_ = srv.delBranch(branch)
-		_ = srv.repo.Storer.RemoveReference(plumbing.NewBranchReferenceName(branch))
+	if err = srv.fetchLocked(); err != nil {
+		_ = os.RemoveAll(srv.path)
 	}
-	return err
+	return
 }
 
+// mirrorRefSpecs 镜像同步远程所有分支和标签
+var mirrorRefSpecs = []gitConfig.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// fetch 同步远程仓库到镜像
 func (srv *Git) fetch() error {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.fetchLocked()
+}
+
+func (srv *Git) fetchLocked() error {
 	err := srv.repo.Fetch(&git.FetchOptions{
-		RefSpecs: []gitConfig.RefSpec{"refs/*:refs/*", "HEAD:ref/heads/HEAD"},
-		Auth:     srv.auth,
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   mirrorRefSpecs,
+		Auth:       srv.auth,
+		Force:      true,
 	})
-	//r, _ := srv.repo.References()
-	//for {
-	//	s, err := r.Next()
-	//	if err != nil {
-	//		if err == io.EOF {
-	//			break
-	//		}
-	//		return err
-	//	}
-	//	fmt.Println(s.Name(), s.Type(), s.Hash())
-	//}
 	if errors.Is(err, git.NoErrAlreadyUpToDate) {
 		return nil
 	}
 	return err
 }
 
-func (srv *Git) CheckoutToBranch(branch string) (err error) {
+// CheckoutToBranch 导出分支最新版本的代码到dest目录
+func (srv *Git) CheckoutToBranch(branch, dest string) (commit string, err error) {
 	defer func() {
 		if err != nil {
 			err = ErrRepoGit.Wrap(err)
 		}
 	}()
-	err = srv.fetch()
-	if err != nil {
+	if err = srv.fetch(); err != nil {
 		return
 	}
-	var w *git.Worktree
-	w, err = srv.repo.Worktree()
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	ref, err := srv.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
 	if err != nil {
 		return
 	}
-	return w.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName(branch),
-		Force:  true,
-	})
-	//fmt.Println(444, err)
-	//if err != nil {
-	//	return
-	//}
-	//return
-	//err = srv.Pull(branch)
-	//fmt.Println(555, err)
-	//return
+	return ref.Hash().String(), srv.export(ref.Hash(), dest)
 }
 
-func (srv *Git) CheckoutToCommit(branch, commit string) (err error) {
+// CheckoutToCommit 导出分支某个commit的代码到dest目录
+func (srv *Git) CheckoutToCommit(branch, commit, dest string) (_ string, err error) {
 	defer func() {
 		if err != nil {
 			err = ErrRepoGit.Wrap(err)
 		}
 	}()
-	err = srv.CheckoutToBranch(branch)
-	if err != nil {
-		return
-	}
-	var w *git.Worktree
-	w, err = srv.repo.Worktree()
-	if err != nil {
+	if err = srv.fetch(); err != nil {
 		return
 	}
-	err = w.Checkout(&git.CheckoutOptions{
-		Hash: plumbing.NewHash(commit),
-	})
-	return
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	hash := plumbing.NewHash(commit)
+	return hash.String(), srv.export(hash, dest)
 }
 
-func (srv *Git) CheckoutToTag(tag string) (err error) {
+// CheckoutToTag 导出标签对应版本的代码到dest目录
+func (srv *Git) CheckoutToTag(tag, dest string) (commit string, err error) {
 	defer func() {
 		if err != nil {
 			err = ErrRepoGit.Wrap(err)
 		}
 	}()
-	err = srv.fetch()
-	if err != nil {
+	if err = srv.fetch(); err != nil {
 		return
 	}
-	var w *git.Worktree
-	w, err = srv.repo.Worktree()
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	ref, err := srv.repo.Reference(plumbing.NewTagReferenceName(tag), true)
 	if err != nil {
 		return
 	}
-	err = w.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewTagReferenceName(tag),
-	})
-	return
+	hash := ref.Hash()
+	//附注标签指向tag对象，需要取得对应的commit
+	if t, tagErr := srv.repo.TagObject(hash); tagErr == nil {
+		c, err := t.Commit()
+		if err != nil {
+			return "", err
+		}
+		hash = c.Hash
+	}
+	return hash.String(), srv.export(hash, dest)
+}
+
+// VerifyCommit 验证commit签名，调用前需要已经同步了该commit
+func (srv *Git) VerifyCommit(commit string, keys []TrustedKey) (*Signature, error) {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	c, err := srv.repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return nil, ErrRepoGit.Wrap(err)
+	}
+	return verifyCommitObject(c, keys)
 }
 
-func (srv *Git) getBranch(branch string) (b *gitConfig.Branch, err error) {
-	b, err = srv.repo.Branch(branch)
+// VerifyTag 附注tag有签名时验证tag，否则验证tag指向的commit
+func (srv *Git) VerifyTag(tag string, keys []TrustedKey) (*Signature, error) {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	ref, err := srv.repo.Reference(plumbing.NewTagReferenceName(tag), true)
+	if err != nil {
+		return nil, ErrRepoGit.Wrap(err)
+	}
+	t, err := srv.repo.TagObject(ref.Hash())
 	if err == nil {
-		return
+		if t, ok := signedTag(t); ok {
+			return verifyTagObject(t, keys)
+		}
+		c, err := t.Commit()
+		if err != nil {
+			return nil, ErrRepoGit.Wrap(err)
+		}
+		return verifyCommitObject(c, keys)
 	}
-	b = &gitConfig.Branch{
-		Name:   branch,
-		Merge:  plumbing.NewBranchReferenceName(branch),
-		Rebase: "true",
+	if !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, ErrRepoGit.Wrap(err)
 	}
-	err = srv.repo.CreateBranch(b)
-	return
+	//轻量标签直接指向commit
+	c, err := srv.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, ErrRepoGit.Wrap(err)
+	}
+	return verifyCommitObject(c, keys)
 }
 
-func (srv *Git) delBranch(branch string) (err error) {
-	_ = srv.repo.DeleteBranch(branch)
-	return
+// Submodules 获取最近一次导出的所有子模块(包括嵌套的子模块)的路径和commit
+func (srv *Git) Submodules() ([]Submodule, error) {
+	return srv.submodules, nil
 }
 
-// Branches 获取所有远程分支
+// Branches 从本地镜像获取所有分支，镜像由后台定时同步
 func (srv *Git) Branches() ([]Branch, error) {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
 	_branches := make([]Branch, 0)
-	remote, err := srv.repo.Remote(git.DefaultRemoteName)
+	refs, err := srv.repo.Branches()
 	if err != nil {
 		return nil, ErrRepoGit.Wrap(err)
 	}
-	br, err := remote.List(&git.ListOptions{Auth: srv.auth})
-	if err != nil {
-		return nil, ErrRepoGit.Wrap(err)
-	}
-	for _, v := range br {
-		if v.Name().IsBranch() {
-			_Branch := Branch{
-				Name: v.Name().Short(),
-				Hash: v.Hash().String(),
-			}
-			_branches = append(_branches, _Branch)
-		}
-	}
+	_ = refs.ForEach(func(v *plumbing.Reference) error {
+		_branches = append(_branches, Branch{
+			Name: v.Name().Short(),
+			Hash: v.Hash().String(),
+		})
+		return nil
+	})
 	return _branches, nil
 }
 
-// Tags 获取所有标签
+// Tags 从本地镜像获取所有标签
 func (srv *Git) Tags() ([]Tag, error) {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
 	_tags := make([]Tag, 0)
-	remote, err := srv.repo.Remote(git.DefaultRemoteName)
-	if err != nil {
-		return nil, ErrRepoGit.Wrap(err)
-	}
-	br, err := remote.List(&git.ListOptions{Auth: srv.auth})
+	refs, err := srv.repo.Tags()
 	if err != nil {
 		return nil, ErrRepoGit.Wrap(err)
 	}
-	for _, v := range br {
-		if v.Name().IsTag() {
-			_tag := Tag{
-				Name: v.Name().Short(),
-				Hash: v.Hash().String(),
-			}
-			_tags = append(_tags, _tag)
-		}
-	}
+	_ = refs.ForEach(func(v *plumbing.Reference) error {
+		_tags = append(_tags, Tag{
+			Name: v.Name().Short(),
+			Hash: v.Hash().String(),
+		})
+		return nil
+	})
 	return _tags, nil
 }
 
-// Commits 获取对应的分支的commits
+// Fetch 同步远程仓库到本地镜像
+func (srv *Git) Fetch() error {
+	return ErrRepoGit.Wrap(srv.fetch())
+}
+
+// Commits 从本地镜像获取对应的分支的commits
 func (srv *Git) Commits(branch string) ([]Commit, error) {
-	r, err := srv.repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch), false)
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	r, err := srv.repo.Reference(plumbing.NewBranchReferenceName(branch), false)
 	if err != nil {
 		return nil, ErrRepoGit.Wrap(err)
 	}
 	_commits := make([]Commit, 0)
-	br, err := srv.repo.Log(&git.LogOptions{From: r.Hash(), Order: git.LogOrderCommitterTime})
+	logOpts := &git.LogOptions{From: r.Hash(), Order: git.LogOrderCommitterTime}
+	//只显示修改了子目录的提交
+	if subDir := srv.opts.SubDir; subDir != "" {
+		logOpts.PathFilter = func(file string) bool {
+			return InSubDir(subDir, file)
+		}
+	}
+	br, err := srv.repo.Log(logOpts)
 	if err != nil {
 		return nil, ErrRepoGit.Wrap(err)
 	}
@@ -287,21 +328,22 @@ func (srv *Git) Commits(branch string) ([]Commit, error) {
 }
 
 func (srv *Git) getAuth() (auth transport.AuthMethod, _ error) {
-	if srv.repoUrl[0:3] == "git" {
-		_, err := os.Stat(srv.config.PrivateKeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("git config PrivateKeyFile: %s not exisit\n", srv.config.PrivateKeyFile)
-		}
-		publicKeys, err := ssh.NewPublicKeysFromFile(srv.config.PrivateKeyUsername, srv.config.PrivateKeyFile, srv.config.PrivateKeyPassword)
-		if err != nil {
-			return nil, err
-		}
-		return publicKeys, nil
+	c, err := srv.credential()
+	if err != nil {
+		return nil, err
+	}
+	if !c.ssh {
+		return &http.BasicAuth{Username: c.username, Password: c.password}, nil
+	}
+	if c.keyFile != "" {
+		return ssh.NewPublicKeysFromFile(c.username, c.keyFile, c.passphrase)
 	}
-	return &http.BasicAuth{
-		Username: srv.config.Username,
-		Password: srv.config.Password,
-	}, nil
+	return ssh.NewPublicKeys(c.username, c.privateKey, c.passphrase)
+}
+
+// credential 仓库的授权信息
+func (srv *Git) credential() (*credential, error) {
+	return resolveCredential(srv.config, srv.opts, srv.repoUrl)
 }
 
 func (srv *Git) Path() string {
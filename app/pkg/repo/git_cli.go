@@ -0,0 +1,454 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitCli 调用系统git命令操作仓库，适用于历史很大的仓库。
+// 本地保存部分克隆(--filter=blob:none)的bare镜像，只同步最近FetchDepth个提交，
+// 导出时按需下载文件内容
+type GitCli struct {
+	config  *GitConfig
+	path    string
+	repoUrl string
+	opts    *Options
+	lock    *sync.RWMutex
+	cred    *credential
+
+	submodules []Submodule //最近一次导出的子模块版本
+}
+
+func NewGitCli(cfg *GitConfig, url string, path string, opts *Options) (*GitCli, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, ErrRepoGit.New("git命令不存在，请先安装git")
+	}
+	lock, _ := mirrorLocks.LoadOrStore(path, &sync.RWMutex{})
+	repo := &GitCli{
+		config:  cfg,
+		path:    path,
+		repoUrl: url,
+		opts:    opts,
+		lock:    lock.(*sync.RWMutex),
+	}
+	if err := repo.init(); err != nil {
+		return nil, ErrRepoGit.Wrap(err)
+	}
+	return repo, nil
+}
+
+func (srv *GitCli) init() (err error) {
+	srv.cred, err = resolveCredential(srv.config, srv.opts, srv.repoUrl)
+	if err != nil {
+		return
+	}
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	out, err := srv.run(context.Background(), "config", "--get", "remote.origin.url")
+	if err == nil && strings.TrimSpace(string(out)) == srv.repoUrl {
+		return nil
+	}
+	//镜像不存在、不是bare仓库或者地址不一致，重新创建
+	_ = os.RemoveAll(srv.path)
+	if err = os.MkdirAll(srv.path, os.ModePerm); err != nil {
+		return
+	}
+	ctx := context.Background()
+	for _, args := range [][]string{
+		{"init", "--bare", "--quiet"},
+		{"remote", "add", "origin", srv.repoUrl},
+		{"config", "remote.origin.promisor", "true"},
+		{"config", "remote.origin.partialclonefilter", "blob:none"},
+	} {
+		if _, err = srv.run(ctx, args...); err != nil {
+			_ = os.RemoveAll(srv.path)
+			return
+		}
+	}
+	if err = srv.fetchLocked(ctx); err != nil {
+		_ = os.RemoveAll(srv.path)
+	}
+	return
+}
+
+// run 在镜像目录执行git命令
+func (srv *GitCli) run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd, err := srv.command()
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Close()
+	return cmd.Run(ctx, args...)
+}
+
+// command 创建在镜像目录执行的git命令，指定GIT_DIR避免使用到上级目录的仓库
+func (srv *GitCli) command() (*gitCommand, error) {
+	if _, err := os.Stat(srv.path); err != nil {
+		return nil, err
+	}
+	gitDir, err := filepath.Abs(srv.path)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := newGitCommand(srv.cred, srv.path)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.With("GIT_DIR=" + gitDir), nil
+}
+
+func (srv *GitCli) Fetch() error {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return ErrRepoGit.Wrap(srv.fetchLocked(context.Background()))
+}
+
+// fetchLocked 同步指定的引用，不指定时同步所有分支和标签最近FetchDepth个提交，调用方需要持有写锁
+func (srv *GitCli) fetchLocked(ctx context.Context, refspecs ...string) error {
+	args := []string{"fetch", "--quiet", "--force", "--filter=blob:none"}
+	depth := srv.config.FetchDepth
+	if len(refspecs) == 0 {
+		//同步所有引用时清理远程已经删除的分支和标签
+		args = append(args, "--prune")
+		refspecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+	} else {
+		depth = 1
+	}
+	if depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	_, err := srv.run(ctx, append(append(args, "--end-of-options", "origin"), refspecs...)...)
+	return err
+}
+
+// ensureCommit 镜像中不存在该commit时(超出了同步深度)，单独浅同步该commit，commit需要调用方校验
+func (srv *GitCli) ensureCommit(ctx context.Context, commit string) error {
+	if _, err := srv.run(ctx, "cat-file", "-e", "--end-of-options", commit+"^{commit}"); err == nil {
+		return nil
+	}
+	srv.lock.RUnlock()
+	srv.lock.Lock()
+	err := srv.fetchLocked(ctx, commit)
+	srv.lock.Unlock()
+	srv.lock.RLock()
+	return err
+}
+
+func (srv *GitCli) Branches() ([]Branch, error) {
+	refs, err := srv.refs("refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	_branches := make([]Branch, 0, len(refs))
+	for _, v := range refs {
+		_branches = append(_branches, Branch{Name: v[0], Hash: v[1]})
+	}
+	return _branches, nil
+}
+
+func (srv *GitCli) Tags() ([]Tag, error) {
+	refs, err := srv.refs("refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	_tags := make([]Tag, 0, len(refs))
+	for _, v := range refs {
+		_tags = append(_tags, Tag{Name: v[0], Hash: v[1]})
+	}
+	return _tags, nil
+}
+
+// refs 获取镜像中某个前缀下的引用名称和hash
+func (srv *GitCli) refs(prefix string) ([][2]string, error) {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	out, err := srv.run(context.Background(), "for-each-ref", "--format=%(refname:strip=2) %(objectname)", prefix)
+	if err != nil {
+		return nil, err
+	}
+	res := make([][2]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if name, hash, ok := strings.Cut(line, " "); ok {
+			res = append(res, [2]string{name, hash})
+		}
+	}
+	return res, nil
+}
+
+func (srv *GitCli) Commits(branch string) ([]Commit, error) {
+	if err := CheckRefName(branch); err != nil {
+		return nil, err
+	}
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	args := []string{"log", "--format=%H%x1f%ct%x1f%B%x1e"}
+	if srv.config.FetchDepth > 0 {
+		args = append(args, "-n", strconv.Itoa(srv.config.FetchDepth))
+	}
+	args = append(args, "--end-of-options", "refs/heads/"+branch, "--")
+	//只显示修改了子目录的提交
+	if srv.opts.SubDir != "" {
+		args = append(args, srv.opts.SubDir)
+	}
+	out, err := srv.run(context.Background(), args...)
+	if err != nil {
+		return nil, err
+	}
+	_commits := make([]Commit, 0)
+	for _, item := range strings.Split(string(out), "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(item, "\n"), "\x1f", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		ts, _ := strconv.ParseInt(fields[1], 10, 64)
+		_commits = append(_commits, Commit{
+			Name:      fields[0][:8] + "#" + fields[2],
+			Message:   fields[2],
+			Timestamp: time.Unix(ts, 0),
+			Hash:      fields[0],
+		})
+	}
+	return _commits, nil
+}
+
+func (srv *GitCli) CheckoutToBranch(branch, dest string) (string, error) {
+	if err := CheckRefName(branch); err != nil {
+		return "", err
+	}
+	return srv.checkout("refs/heads/"+branch, dest)
+}
+
+func (srv *GitCli) CheckoutToCommit(branch, commit, dest string) (string, error) {
+	if err := CheckCommit(commit); err != nil {
+		return "", err
+	}
+	return srv.checkout(commit, dest)
+}
+
+func (srv *GitCli) CheckoutToTag(tag, dest string) (string, error) {
+	if err := CheckRefName(tag); err != nil {
+		return "", err
+	}
+	return srv.checkout("refs/tags/"+tag, dest)
+}
+
+// checkout 同步后导出对应版本，返回导出的commit
+func (srv *GitCli) checkout(rev, dest string) (string, error) {
+	if err := srv.Fetch(); err != nil {
+		return "", err
+	}
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	ctx := context.Background()
+	if err := srv.ensureCommit(ctx, rev); err != nil {
+		return "", err
+	}
+	out, err := srv.run(ctx, "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	commit := strings.TrimSpace(string(out))
+	srv.submodules = make([]Submodule, 0)
+	return commit, ErrRepoGit.Wrap(srv.export(ctx, commit, dest, "", srv.opts.SubDir))
+}
+
+// export 使用临时的index文件导出代码，多个上线单同时导出互不影响，调用方需要持有读锁。
+// 设置了子目录时只读取子目录的tree，部分克隆的镜像只会下载子目录下的文件
+func (srv *GitCli) export(ctx context.Context, commit, dest, prefix, subDir string) error {
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+	cmd, err := srv.command()
+	if err != nil {
+		return err
+	}
+	defer cmd.Close()
+	if srv.opts.Lfs {
+		if _, err = cmd.Run(ctx, "lfs", "install", "--local"); err != nil {
+			return err
+		}
+	}
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	indexCmd := cmd.With("GIT_INDEX_FILE="+filepath.Join(cmd.tmpDir, "index"), "GIT_WORK_TREE="+absDest)
+	treeish := commit
+	if subDir != "" {
+		treeish = commit + ":" + subDir
+	}
+	if _, err = indexCmd.Run(ctx, "read-tree", treeish); err != nil {
+		return err
+	}
+	if _, err = indexCmd.Run(ctx, "checkout-index", "--all", "--force"); err != nil {
+		return err
+	}
+	if !srv.opts.Submodules {
+		return nil
+	}
+	return srv.exportSubmodules(ctx, commit, dest, prefix, subDir)
+}
+
+func (srv *GitCli) exportSubmodules(ctx context.Context, commit, dest, prefix, subDir string) error {
+	args := []string{"ls-tree", "-r", commit}
+	if subDir != "" {
+		args = append(args, "--", subDir)
+	}
+	out, err := srv.run(ctx, args...)
+	if err != nil {
+		return err
+	}
+	gitlinks := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		//格式：<mode> SP <type> SP <object> TAB <file>
+		info, file, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if ok && len(fields) == 3 && fields[1] == "commit" {
+			gitlinks[file] = fields[2]
+		}
+	}
+	if len(gitlinks) == 0 {
+		return nil
+	}
+	content, err := srv.run(ctx, "show", commit+":.gitmodules")
+	if err != nil {
+		return fmt.Errorf("读取.gitmodules失败：%w", err)
+	}
+	modules := gitConfig.NewModules()
+	if err = modules.Unmarshal(content); err != nil {
+		return err
+	}
+	for _, m := range modules.Submodules {
+		if !InSubDir(subDir, m.Path) {
+			continue
+		}
+		hash, ok := gitlinks[m.Path]
+		if !ok {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(m.Path, subDir), "/")
+		subUrl, err := resolveSubmoduleUrl(srv.repoUrl, m.URL)
+		if err != nil {
+			return err
+		}
+		sub, err := NewGitCli(srv.config, subUrl, filepath.Join(srv.path, "modules", m.Name), submoduleOptions(srv.repoUrl, subUrl, srv.opts))
+		if err != nil {
+			return fmt.Errorf("子模块%s：%w", m.Path, err)
+		}
+		fullPath := path.Join(prefix, m.Path)
+		srv.submodules = append(srv.submodules, Submodule{Path: fullPath, Hash: hash})
+		if err = sub.exportSubmodule(ctx, hash, filepath.Join(dest, rel), fullPath); err != nil {
+			return fmt.Errorf("子模块%s：%w", m.Path, err)
+		}
+		srv.submodules = append(srv.submodules, sub.submodules...)
+	}
+	return nil
+}
+
+func (srv *GitCli) exportSubmodule(ctx context.Context, commit, dest, prefix string) error {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	if err := srv.ensureCommit(ctx, commit); err != nil {
+		return err
+	}
+	srv.submodules = make([]Submodule, 0)
+	//子模块总是完整导出
+	return srv.export(ctx, commit, dest, prefix, "")
+}
+
+// VerifyCommit 读取commit原始内容后使用go-git解析并验证签名
+func (srv *GitCli) VerifyCommit(commit string, keys []TrustedKey) (*Signature, error) {
+	if err := CheckCommit(commit); err != nil {
+		return nil, err
+	}
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	ctx := context.Background()
+	if err := srv.ensureCommit(ctx, commit); err != nil {
+		return nil, err
+	}
+	c, err := srv.commitObject(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+	return verifyCommitObject(c, keys)
+}
+
+// VerifyTag 附注tag有签名时验证tag，否则验证tag指向的commit
+func (srv *GitCli) VerifyTag(tag string, keys []TrustedKey) (*Signature, error) {
+	if err := CheckRefName(tag); err != nil {
+		return nil, err
+	}
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	ctx := context.Background()
+	rev := "refs/tags/" + tag
+	typ, err := srv.run(ctx, "cat-file", "-t", "--end-of-options", rev)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(typ)) == plumbing.TagObject.String() {
+		o, err := srv.object(ctx, plumbing.TagObject, rev)
+		if err != nil {
+			return nil, err
+		}
+		t := &object.Tag{}
+		if err = t.Decode(o); err != nil {
+			return nil, ErrRepoGit.Wrap(err)
+		}
+		if t, ok := signedTag(t); ok {
+			return verifyTagObject(t, keys)
+		}
+	}
+	c, err := srv.commitObject(ctx, rev+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	return verifyCommitObject(c, keys)
+}
+
+func (srv *GitCli) commitObject(ctx context.Context, rev string) (*object.Commit, error) {
+	o, err := srv.object(ctx, plumbing.CommitObject, rev)
+	if err != nil {
+		return nil, err
+	}
+	c := &object.Commit{}
+	return c, ErrRepoGit.Wrap(c.Decode(o))
+}
+
+// object 读取对象的原始内容
+func (srv *GitCli) object(ctx context.Context, typ plumbing.ObjectType, rev string) (plumbing.EncodedObject, error) {
+	out, err := srv.run(ctx, "cat-file", typ.String(), "--end-of-options", rev)
+	if err != nil {
+		return nil, err
+	}
+	o := &plumbing.MemoryObject{}
+	o.SetType(typ)
+	if _, err = o.Write(out); err != nil {
+		return nil, ErrRepoGit.Wrap(err)
+	}
+	return o, nil
+}
+
+func (srv *GitCli) Submodules() ([]Submodule, error) {
+	return srv.submodules, nil
+}
+
+func (srv *GitCli) Path() string {
+	return srv.path
+}
+
+func (srv *GitCli) Type() TypeRepo {
+	return GitRepo
+}
@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitCli(t *testing.T) {
+	url, subHash := newTestGitRepo(t)
+	gitRun(t, url, "config", "uploadpack.allowFilter", "true")
+	gitRun(t, url, "config", "uploadpack.allowAnySHA1InWant", "true")
+	first := gitRun(t, url, "rev-parse", "HEAD")
+	gitRun(t, url, "tag", "v1.0.0")
+	if err := os.WriteFile(filepath.Join(url, "main.txt"), []byte("main2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, url, "commit", "-am", "second")
+
+	srv, err := NewGitCli(&GitConfig{FetchDepth: 1}, "file://"+url, filepath.Join(t.TempDir(), "project"), &Options{Submodules: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	branches, err := srv.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 1 || branches[0].Name != "main" {
+		t.Error("分支错误", branches)
+	}
+	tags, err := srv.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "v1.0.0" {
+		t.Error("标签错误", tags)
+	}
+	//只同步了最近一个提交
+	commits, err := srv.Commits("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0].Message != "second\n" {
+		t.Error("提交记录错误", commits)
+	}
+
+	dir := t.TempDir()
+	check := func(dest, want string) {
+		if b, err := os.ReadFile(filepath.Join(dest, "main.txt")); err != nil || string(b) != want {
+			t.Errorf("%s内容为%s，期望%s", dest, b, want)
+		}
+		if b, err := os.ReadFile(filepath.Join(dest, "lib", "lib.txt")); err != nil || string(b) != "lib" {
+			t.Error("子模块未导出", err)
+		}
+	}
+	commit, err := srv.CheckoutToBranch("main", filepath.Join(dir, "branch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(dir, "branch"), "main2")
+	if len(commit) != 40 || commit == first {
+		t.Error("应返回分支最新的commit", commit)
+	}
+	//超出同步深度的commit
+	if commit, err = srv.CheckoutToCommit("main", first, filepath.Join(dir, "commit")); err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(dir, "commit"), "main")
+	if commit != first {
+		t.Error("应返回检出的commit", commit)
+	}
+	if commit, err = srv.CheckoutToTag("v1.0.0", filepath.Join(dir, "tag")); err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(dir, "tag"), "main")
+	if commit != first {
+		t.Error("应返回tag指向的commit", commit)
+	}
+	subs, _ := srv.Submodules()
+	if len(subs) != 1 || subs[0].Path != "lib" || subs[0].Hash != subHash {
+		t.Error("子模块记录错误", subs)
+	}
+
+	//用户提交的版本不能被当作git参数
+	marker := filepath.Join(t.TempDir(), "pwned")
+	inject := "--upload-pack=touch " + marker + ";git-upload-pack"
+	if _, err = srv.CheckoutToCommit("main", inject, filepath.Join(dir, "inject")); err == nil {
+		t.Error("commit为git参数时应报错")
+	}
+	if _, err = srv.CheckoutToBranch("--upload-pack=touch "+marker, filepath.Join(dir, "inject")); err == nil {
+		t.Error("分支为git参数时应报错")
+	}
+	if _, err = srv.VerifyCommit(inject, nil); err == nil {
+		t.Error("验证签名时commit为git参数应报错")
+	}
+	if _, err = srv.VerifyTag("-t", nil); err == nil {
+		t.Error("验证签名时标签为git参数应报错")
+	}
+	if _, err = os.Stat(marker); err == nil {
+		t.Error("执行了注入的命令")
+	}
+}
+
+func TestGitSshCommand(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+	keyFile := filepath.Join(t.TempDir(), "id key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "secret", "-f", keyFile).CombinedOutput(); err != nil {
+		t.Fatal(string(out), err)
+	}
+	t.Setenv("SSH_KNOWN_HOSTS", "/tmp/known_hosts"+string(filepath.ListSeparator)+"/tmp/other hosts")
+	sshCommand := func(c *credential) string {
+		cli, err := newGitCommand(c, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(cli.Close)
+		for _, env := range cli.env {
+			if strings.HasPrefix(env, "GIT_SSH_COMMAND=") {
+				return strings.TrimPrefix(env, "GIT_SSH_COMMAND=")
+			}
+		}
+		t.Fatal("GIT_SSH_COMMAND not set")
+		return ""
+	}
+
+	//有密码的私钥解密后写入临时文件
+	cmd := sshCommand(&credential{ssh: true, keyFile: keyFile, passphrase: "secret"})
+	for _, opt := range []string{"-o BatchMode=yes", "-o StrictHostKeyChecking=yes", `-o 'UserKnownHostsFile=/tmp/known_hosts /tmp/other hosts'`} {
+		if !strings.Contains(cmd, opt) {
+			t.Errorf("缺少%s：%s", opt, cmd)
+		}
+	}
+	if strings.Contains(cmd, shellQuote(keyFile)) {
+		t.Error("有密码的私钥不能直接使用", cmd)
+	}
+	//没有密码的私钥文件直接使用，路径需要加引号
+	plain := filepath.Join(t.TempDir(), "it's key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", plain).CombinedOutput(); err != nil {
+		t.Fatal(string(out), err)
+	}
+	if cmd = sshCommand(&credential{ssh: true, keyFile: plain}); !strings.Contains(cmd, "-i "+shellQuote(plain)+" ") {
+		t.Error("私钥路径错误", cmd)
+	}
+	out, err := exec.Command("sh", "-c", "printf '%s' "+shellQuote(plain)).Output()
+	if err != nil || string(out) != plain {
+		t.Errorf("路径转义错误：%q %v", out, err)
+	}
+
+	encrypted, _ := os.ReadFile(keyFile)
+	if _, err = newGitCommand(&credential{ssh: true, privateKey: encrypted}, t.TempDir()); err == nil {
+		t.Error("私钥有密码但没有设置时应报错")
+	}
+}
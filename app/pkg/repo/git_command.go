@@ -0,0 +1,167 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// askPassScript GIT_ASKPASS脚本，从环境变量中读取帐号密码，避免密码出现在命令参数和仓库配置中
+const askPassScript = `#!/bin/sh
+case "$1" in
+Username*) echo "$WALLE_GIT_USERNAME" ;;
+*) echo "$WALLE_GIT_PASSWORD" ;;
+esac
+`
+
+// credential 仓库授权信息，ssh为true时使用私钥，否则使用http帐号密码
+type credential struct {
+	ssh        bool
+	username   string
+	password   string
+	privateKey []byte
+	keyFile    string
+	passphrase string
+}
+
+// gitCommand 执行git命令行，授权信息通过临时文件和环境变量传递
+type gitCommand struct {
+	dir    string
+	env    []string
+	tmpDir string
+}
+
+// newGitCommand 创建在dir目录下执行的git命令行，使用完需要调用Close清理临时文件
+func newGitCommand(c *credential, dir string) (*gitCommand, error) {
+	tmpDir, err := os.MkdirTemp("", "walle-git-")
+	if err != nil {
+		return nil, err
+	}
+	cli := &gitCommand{dir: dir, tmpDir: tmpDir, env: append(os.Environ(), "GIT_TERMINAL_PROMPT=0")}
+	if c.ssh {
+		keyFile, err := c.writeKeyFile(tmpDir)
+		if err != nil {
+			cli.Close()
+			return nil, err
+		}
+		//不询问任何输入，主机公钥不在known_hosts中时拒绝连接。
+		//和go-git一样优先使用SSH_KNOWN_HOSTS中的文件，否则使用默认的known_hosts
+		sshCommand := "ssh -i " + shellQuote(keyFile) + " -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=yes"
+		if files := filepath.SplitList(os.Getenv("SSH_KNOWN_HOSTS")); len(files) > 0 {
+			sshCommand += " -o " + shellQuote("UserKnownHostsFile="+strings.Join(files, " "))
+		}
+		cli.env = append(cli.env, "GIT_SSH_COMMAND="+sshCommand)
+		return cli, nil
+	}
+	askPass := filepath.Join(tmpDir, "askpass.sh")
+	if err = os.WriteFile(askPass, []byte(askPassScript), 0700); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	cli.env = append(cli.env, "GIT_ASKPASS="+askPass, "WALLE_GIT_USERNAME="+c.username, "WALLE_GIT_PASSWORD="+c.password)
+	return cli, nil
+}
+
+// writeKeyFile 私钥密码无法通过命令行传递，有密码或者不是文件的私钥解密后写入临时文件
+func (c *credential) writeKeyFile(tmpDir string) (string, error) {
+	key := c.privateKey
+	if c.keyFile != "" {
+		if c.passphrase == "" {
+			return c.keyFile, nil
+		}
+		var err error
+		if key, err = os.ReadFile(c.keyFile); err != nil {
+			return "", err
+		}
+	}
+	key, err := unencryptedPrivateKey(key, c.passphrase)
+	if err != nil {
+		return "", ErrRepoGit.Wrap(err)
+	}
+	keyFile := filepath.Join(tmpDir, "id_key")
+	return keyFile, os.WriteFile(keyFile, key, 0600)
+}
+
+// shellQuote GIT_SSH_COMMAND由shell执行，路径需要加引号
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// With 返回增加了环境变量的命令，共用授权临时文件
+func (cli *gitCommand) With(env ...string) *gitCommand {
+	return &gitCommand{dir: cli.dir, tmpDir: cli.tmpDir, env: append(append([]string{}, cli.env...), env...)}
+}
+
+func (cli *gitCommand) Run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = cli.dir
+	cmd.Env = cli.env
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, ErrRepoGit.New("git %s: %s %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Pipe 执行git命令，从stdin读取输入，输出写入stdout
+func (cli *gitCommand) Pipe(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = cli.dir
+	cmd.Env = cli.env
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return ErrRepoGit.New("git %s: %s %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (cli *gitCommand) Close() {
+	_ = os.RemoveAll(cli.tmpDir)
+}
+
+// credential 仓库的授权信息，优先级：项目单独设置的授权 > 项目部署密钥(ssh地址) > 全局配置
+func resolveCredential(cfg *GitConfig, opts *Options, repoUrl string) (*credential, error) {
+	switch opts.AuthType {
+	case AuthBasic:
+		return &credential{username: opts.Username, password: opts.Password}, nil
+	case AuthToken:
+		//token方式用户名不能为空，各平台均不校验用户名
+		username := opts.Username
+		if username == "" {
+			username = "git"
+		}
+		return &credential{username: username, password: opts.Password}, nil
+	case AuthSsh:
+		username := opts.Username
+		if username == "" {
+			username = cfg.PrivateKeyUsername
+		}
+		return &credential{ssh: true, username: username, privateKey: []byte(opts.PrivateKey), passphrase: opts.Passphrase}, nil
+	}
+	if isSshUrl(repoUrl) && opts.DeployKey != "" {
+		return &credential{ssh: true, username: cfg.PrivateKeyUsername, privateKey: []byte(opts.DeployKey)}, nil
+	}
+	if isSshUrl(repoUrl) {
+		_, err := os.Stat(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("git config PrivateKeyFile: %s not exisit\n", cfg.PrivateKeyFile)
+		}
+		return &credential{ssh: true, username: cfg.PrivateKeyUsername, keyFile: cfg.PrivateKeyFile, passphrase: cfg.PrivateKeyPassword}, nil
+	}
+	return &credential{username: cfg.Username, password: cfg.Password}, nil
+}
+
+// isSshUrl 是否为ssh协议的仓库地址
+func isSshUrl(repoUrl string) bool {
+	return strings.HasPrefix(repoUrl, "git@") || strings.HasPrefix(repoUrl, "ssh://")
+}
@@ -0,0 +1,263 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"io"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// lfsPointerPrefix LFS指针文件的开头
+var lfsPointerPrefix = []byte("version https://git-lfs.github.com/spec/v1")
+
+// lfsPointerMaxSize LFS指针文件不会超过该大小
+const lfsPointerMaxSize = 1024
+
+// export 将commit对应的代码导出到dest目录，设置了子目录时只导出子目录下的文件，调用方需要持有读锁
+func (srv *Git) export(hash plumbing.Hash, dest string) error {
+	srv.submodules = make([]Submodule, 0)
+	return srv.exportTo(hash, dest, "", srv.opts.SubDir)
+}
+
+func (srv *Git) exportTo(hash plumbing.Hash, dest, prefix, subDir string) error {
+	commit, err := srv.repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	walkTree := tree
+	if subDir != "" {
+		if walkTree, err = tree.Tree(subDir); err != nil {
+			return fmt.Errorf("子目录%s不存在：%w", subDir, err)
+		}
+	}
+	if err = os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+	var lfs *gitCommand
+	if srv.opts.Lfs {
+		c, err := srv.credential()
+		if err != nil {
+			return err
+		}
+		if lfs, err = newGitCommand(c, srv.path); err != nil {
+			return err
+		}
+		defer lfs.Close()
+	}
+	gitlinks := make(map[string]plumbing.Hash)
+	walker := object.NewTreeWalker(walkTree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, name)
+		switch entry.Mode {
+		case filemode.Dir:
+			err = os.MkdirAll(target, os.ModePerm)
+		case filemode.Submodule:
+			gitlinks[name] = entry.Hash
+		default:
+			err = srv.exportFile(entry, path.Join(subDir, name), target, lfs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(gitlinks) == 0 || !srv.opts.Submodules {
+		return nil
+	}
+	return srv.exportSubmodules(tree, gitlinks, dest, prefix, subDir)
+}
+
+func (srv *Git) exportFile(entry object.TreeEntry, name, target string, lfs *gitCommand) error {
+	blob, err := srv.repo.BlobObject(entry.Hash)
+	if err != nil {
+		return err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if entry.Mode == filemode.Symlink {
+		link, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(link), target)
+	}
+	perm := os.FileMode(0644)
+	if entry.Mode == filemode.Executable {
+		perm = 0755
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if lfs != nil && blob.Size <= lfsPointerMaxSize {
+		pointer, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(pointer, lfsPointerPrefix) {
+			return lfs.Pipe(context.Background(), bytes.NewReader(pointer), f, "lfs", "smudge", "--", name)
+		}
+		_, err = f.Write(pointer)
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// exportSubmodules 子模块使用各自的镜像(保存在父镜像的modules目录下)，使用相同的授权导出到对应目录，
+// gitlinks的路径相对于subDir
+func (srv *Git) exportSubmodules(tree *object.Tree, gitlinks map[string]plumbing.Hash, dest, prefix, subDir string) error {
+	f, err := tree.File(".gitmodules")
+	if err != nil {
+		return fmt.Errorf("读取.gitmodules失败：%w", err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return err
+	}
+	modules := gitConfig.NewModules()
+	if err = modules.Unmarshal([]byte(content)); err != nil {
+		return err
+	}
+	for _, m := range modules.Submodules {
+		if !InSubDir(subDir, m.Path) {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(m.Path, subDir), "/")
+		hash, ok := gitlinks[rel]
+		if !ok {
+			continue
+		}
+		subUrl, err := resolveSubmoduleUrl(srv.repoUrl, m.URL)
+		if err != nil {
+			return err
+		}
+		sub, err := NewGit(srv.config, subUrl, filepath.Join(srv.path, "modules", m.Name), submoduleOptions(srv.repoUrl, subUrl, srv.opts))
+		if err != nil {
+			return fmt.Errorf("子模块%s：%w", m.Path, err)
+		}
+		fullPath := path.Join(prefix, m.Path)
+		srv.submodules = append(srv.submodules, Submodule{Path: fullPath, Hash: hash.String()})
+		if err = sub.exportSubmodule(hash, filepath.Join(dest, rel), fullPath); err != nil {
+			return fmt.Errorf("子模块%s：%w", m.Path, err)
+		}
+		srv.submodules = append(srv.submodules, sub.submodules...)
+	}
+	return nil
+}
+
+func (srv *Git) exportSubmodule(hash plumbing.Hash, dest, prefix string) error {
+	srv.lock.RLock()
+	_, err := srv.repo.CommitObject(hash)
+	srv.lock.RUnlock()
+	//镜像中没有该commit，需要先同步
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		err = srv.fetch()
+	}
+	if err != nil {
+		return err
+	}
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	srv.submodules = make([]Submodule, 0)
+	//子模块总是完整导出
+	return srv.exportTo(hash, dest, prefix, "")
+}
+
+// allowLocalSubmodule 是否允许本地路径的子模块地址，只在测试中使用本地仓库
+var allowLocalSubmodule = false
+
+// resolveSubmoduleUrl 子模块地址为相对路径时，相对于父仓库地址；
+// 子模块地址由仓库内容决定，不能使用本地路径读取服务器上的其他仓库
+func resolveSubmoduleUrl(parent, sub string) (string, error) {
+	subUrl := sub
+	if strings.HasPrefix(sub, "./") || strings.HasPrefix(sub, "../") {
+		if u, err := neturl.Parse(parent); err == nil && strings.Contains(parent, "://") {
+			u.Path = path.Join(u.Path, sub)
+			subUrl = u.String()
+		} else if isScpUrl(parent) {
+			//scp格式，如git@github.com:group/repo.git
+			i := strings.Index(parent, ":")
+			subUrl = parent[:i+1] + strings.TrimPrefix(path.Join(parent[i+1:], sub), "/")
+		} else {
+			subUrl = path.Join(parent, sub)
+		}
+	}
+	if urlHost(subUrl) == "" && !allowLocalSubmodule {
+		return "", fmt.Errorf("子模块地址不支持本地路径：%s", sub)
+	}
+	return subUrl, nil
+}
+
+// submoduleOptions 子模块和父仓库不在同一主机时不使用项目的授权，避免把密钥发送给其他主机
+func submoduleOptions(parent, sub string, opts *Options) *Options {
+	if host := urlHost(sub); host != "" && host == urlHost(parent) {
+		return opts
+	}
+	subOpts := *opts
+	subOpts.AuthType, subOpts.Username, subOpts.Password = "", "", ""
+	subOpts.PrivateKey, subOpts.Passphrase, subOpts.DeployKey = "", "", ""
+	return &subOpts
+}
+
+// urlHost 远程仓库地址的主机名，本地路径和不支持的协议返回空
+func urlHost(repoUrl string) string {
+	if strings.Contains(repoUrl, "://") {
+		u, err := neturl.Parse(repoUrl)
+		if err != nil {
+			return ""
+		}
+		switch u.Scheme {
+		case "http", "https", "ssh", "git":
+			return strings.ToLower(u.Hostname())
+		}
+		return ""
+	}
+	if !isScpUrl(repoUrl) {
+		return ""
+	}
+	host, _, _ := strings.Cut(repoUrl, ":")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return strings.ToLower(host)
+}
+
+// isScpUrl 是否为scp格式的地址，和git的规则一致：第一个/之前有:，<transport>::<address>是远程辅助程序
+func isScpUrl(repoUrl string) bool {
+	i := strings.Index(repoUrl, ":")
+	return i > 0 && !strings.Contains(repoUrl[:i], "/") && !strings.HasPrefix(repoUrl, "-") &&
+		!strings.HasPrefix(repoUrl[i:], "::")
+}
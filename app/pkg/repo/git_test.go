@@ -0,0 +1,274 @@
+package repo
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// gitRun 在dir目录执行git命令，返回去除空白的输出
+func gitRun(t *testing.T, dir string, args ...string) string {
+	args = append([]string{"-c", "protocol.file.allow=always", "-c", "user.name=walle", "-c", "user.email=walle@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newTestGitRepo 创建本地仓库，包含一个子模块lib，返回仓库地址和子模块的commit
+func newTestGitRepo(t *testing.T) (string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git不存在，跳过测试")
+	}
+	//子模块使用本地仓库
+	allowLocalSubmodule = true
+	t.Cleanup(func() {
+		allowLocalSubmodule = false
+	})
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	main := filepath.Join(root, "main")
+	for _, dir := range []string{sub, main} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		gitRun(t, dir, "init", "-b", "main")
+	}
+	if err := os.WriteFile(filepath.Join(sub, "lib.txt"), []byte("lib"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, sub, "add", ".")
+	gitRun(t, sub, "commit", "-m", "lib")
+	subHash := gitRun(t, sub, "rev-parse", "HEAD")
+
+	if err := os.WriteFile(filepath.Join(main, "main.txt"), []byte("main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, main, "submodule", "add", sub, "lib")
+	gitRun(t, main, "add", ".")
+	gitRun(t, main, "commit", "-m", "main")
+	return main, subHash
+}
+
+func TestGitSubmodules(t *testing.T) {
+	url, subHash := newTestGitRepo(t)
+	srv, err := NewGit(&GitConfig{}, url, filepath.Join(t.TempDir(), "project"), &Options{Submodules: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "release")
+	if _, err = srv.CheckoutToBranch("main", dest); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dest, "main.txt")); err != nil || string(b) != "main" {
+		t.Error("代码未导出", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dest, "lib", "lib.txt")); err != nil || string(b) != "lib" {
+		t.Error("子模块未导出", err)
+	}
+	if _, err = os.Stat(filepath.Join(dest, ".git")); !os.IsNotExist(err) {
+		t.Error("导出目录不应包含.git")
+	}
+	subs, err := srv.Submodules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || subs[0].Path != "lib" || subs[0].Hash != subHash {
+		t.Error("子模块记录错误", subs)
+	}
+	allowLocalSubmodule = false
+	if _, err = srv.CheckoutToBranch("main", filepath.Join(t.TempDir(), "release")); err == nil {
+		t.Error("子模块为本地路径时应报错")
+	}
+}
+
+func TestResolveSubmoduleUrl(t *testing.T) {
+	for _, c := range []struct {
+		parent, sub, want string
+	}{
+		{"https://github.com/group/repo.git", "../lib.git", "https://github.com/group/lib.git"},
+		{"git@github.com:group/repo.git", "../lib.git", "git@github.com:group/lib.git"},
+		{"https://github.com/group/repo.git", "git@gitee.com:group/lib.git", "git@gitee.com:group/lib.git"},
+		{"https://github.com/group/repo.git", "ssh://git@github.com/group/lib.git", "ssh://git@github.com/group/lib.git"},
+		//本地路径
+		{"https://github.com/group/repo.git", "/data/repo/secret", ""},
+		{"https://github.com/group/repo.git", "file:///data/repo/secret", ""},
+		{"https://github.com/group/repo.git", "ext::sh -c touch% /tmp/pwned", ""},
+		{"/data/repo/main", "../secret", ""},
+		{"file:///data/repo/main", "../secret", ""},
+	} {
+		got, err := resolveSubmoduleUrl(c.parent, c.sub)
+		if c.want == "" && err == nil || c.want != "" && (err != nil || got != c.want) {
+			t.Errorf("resolveSubmoduleUrl(%s, %s) = %s, %v", c.parent, c.sub, got, err)
+		}
+	}
+}
+
+func TestSubmoduleOptions(t *testing.T) {
+	opts := &Options{AuthType: AuthToken, Password: "token", DeployKey: "key", Submodules: true}
+	if got := submoduleOptions("https://github.com/group/repo.git", "git@GitHub.com:group/lib.git", opts); got.Password != "token" || got.DeployKey != "key" {
+		t.Error("同一主机的子模块应使用项目授权", got)
+	}
+	got := submoduleOptions("https://github.com/group/repo.git", "https://evil.com/group/lib.git", opts)
+	if got.AuthType != "" || got.Password != "" || got.DeployKey != "" || !got.Submodules {
+		t.Error("其他主机的子模块不能使用项目授权", got)
+	}
+	if opts.Password != "token" {
+		t.Error("不能修改父仓库的授权")
+	}
+}
+
+func TestGitConcurrentExport(t *testing.T) {
+	url, _ := newTestGitRepo(t)
+	mirror := filepath.Join(t.TempDir(), "project")
+	first := gitRun(t, url, "rev-parse", "HEAD")
+	if err := os.WriteFile(filepath.Join(url, "main.txt"), []byte("main2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, url, "commit", "-am", "second")
+
+	dir := t.TempDir()
+	errs := make(chan error, 2)
+	for i, commit := range []string{first, ""} {
+		go func(i int, commit string) {
+			srv, err := NewGit(&GitConfig{}, url, mirror, &Options{})
+			if err == nil {
+				dest := filepath.Join(dir, strconv.Itoa(i))
+				if commit == "" {
+					_, err = srv.CheckoutToBranch("main", dest)
+				} else {
+					_, err = srv.CheckoutToCommit("main", commit, dest)
+				}
+			}
+			errs <- err
+		}(i, commit)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range []string{"main", "main2"} {
+		if b, _ := os.ReadFile(filepath.Join(dir, strconv.Itoa(i), "main.txt")); string(b) != want {
+			t.Errorf("导出目录%d内容为%s，期望%s", i, b, want)
+		}
+	}
+}
+
+func TestGitFetch(t *testing.T) {
+	url, _ := newTestGitRepo(t)
+	srv, err := NewGit(&GitConfig{}, url, filepath.Join(t.TempDir(), "project"), &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, url, "tag", "v1.0.0")
+	gitRun(t, url, "branch", "dev")
+	if tags, _ := srv.Tags(); len(tags) != 0 {
+		t.Error("未同步时不应读取到新标签", tags)
+	}
+	if err = srv.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	branches, err := srv.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 2 {
+		t.Error("分支错误", branches)
+	}
+	tags, err := srv.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "v1.0.0" {
+		t.Error("标签错误", tags)
+	}
+	commits, err := srv.Commits("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 {
+		t.Error("提交记录错误", commits)
+	}
+}
+
+func TestGitSubDir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git不存在，跳过测试")
+	}
+	url := t.TempDir()
+	gitRun(t, url, "init", "-b", "main")
+	write := func(name, content string) {
+		file := filepath.Join(url, name)
+		if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("services/api/main.go", "api")
+	write("services/web/index.html", "web")
+	gitRun(t, url, "add", ".")
+	gitRun(t, url, "commit", "-m", "init")
+	write("services/web/index.html", "web2")
+	gitRun(t, url, "commit", "-am", "web")
+	write("services/api/main.go", "api2")
+	gitRun(t, url, "commit", "-am", "api")
+
+	opts := &Options{SubDir: CleanSubDir("/services/api/")}
+	for _, backend := range []string{GitBackendGoGit, GitBackendCli} {
+		t.Run(backend, func(t *testing.T) {
+			opts.Backend = backend
+			srv, err := NewRepos(&Config{RepoDir: t.TempDir()}).New(GitRepo, "file://"+url, "project", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = srv.Fetch(); err != nil {
+				t.Fatal(err)
+			}
+			commits, err := srv.Commits("main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(commits) != 2 || commits[0].Message != "api\n" || commits[1].Message != "init\n" {
+				t.Error("提交记录应只包含修改了子目录的提交", commits)
+			}
+			dest := t.TempDir()
+			if _, err = srv.CheckoutToBranch("main", dest); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := os.ReadFile(filepath.Join(dest, "main.go")); err != nil || string(b) != "api2" {
+				t.Error("子目录导出错误", string(b), err)
+			}
+			if _, err = os.Stat(filepath.Join(dest, "services")); !os.IsNotExist(err) {
+				t.Error("不应导出子目录之外的文件")
+			}
+		})
+	}
+}
+
+func TestInSubDir(t *testing.T) {
+	cases := []struct {
+		dir, file string
+		want      bool
+	}{
+		{"", "a/b.go", true},
+		{"a", "a/b.go", true},
+		{"a", "ab/c.go", false},
+		{"a/b", "a/b", true},
+		{CleanSubDir("../a/"), "a/b.go", true},
+		{CleanSubDir("./"), "b.go", true},
+	}
+	for _, c := range cases {
+		if got := InSubDir(c.dir, c.file); got != c.want {
+			t.Errorf("InSubDir(%q, %q) = %v", c.dir, c.file, got)
+		}
+	}
+}
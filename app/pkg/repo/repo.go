@@ -2,6 +2,9 @@ package repo
 
 import (
 	"github.com/zeebo/errs"
+	"path"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -10,12 +13,16 @@ type TypeRepo string
 const (
 	GitRepo TypeRepo = "git"
 	SvnRepo TypeRepo = "svn"
+	//ArtifactRepo 外部构建好的产物，不需要检出代码
+	ArtifactRepo TypeRepo = "artifact"
 )
 
 var (
 	ErrRepo    = errs.Class("repo")
 	ErrRepoGit = errs.Class("repo.git")
 	ErrRepoSvn = errs.Class("repo.svn")
+
+	ErrRepoArtifact = errs.Class("repo.artifact")
 )
 
 type Repos struct {
@@ -23,9 +30,11 @@ type Repos struct {
 }
 
 type Config struct {
-	RepoDir string `help:"代码本地存放目录" devDefault:"$ROOT/warehouse" default:"/var/lib/walle/warehouse"`
-	Git     GitConfig
-	Svn     SvnConfig
+	RepoDir       string        `help:"代码本地存放目录" devDefault:"$ROOT/warehouse" default:"/var/lib/walle/warehouse"`
+	FetchInterval time.Duration `help:"后台同步仓库镜像的间隔，为0时不同步" default:"5m0s"`
+	Git           GitConfig
+	Svn           SvnConfig
+	Artifact      ArtifactConfig
 }
 
 func NewRepos(cfg *Config) *Repos {
@@ -51,23 +60,125 @@ type Branch struct {
 	Hash string `json:"hash"`
 }
 
+const (
+	GitBackendGoGit = "go-git" //go-git实现
+	GitBackendCli   = "cli"    //调用系统git命令
+)
+
+type AuthType string
+
+const (
+	AuthBasic AuthType = "basic" //帐号密码
+	AuthToken AuthType = "token" //http访问令牌
+	AuthSsh   AuthType = "ssh"   //ssh私钥
+)
+
+// Options 单个项目的仓库参数，AuthType为空时使用全局配置
+type Options struct {
+	AuthType   AuthType
+	Username   string
+	Password   string //密码或者token
+	PrivateKey string
+	Passphrase string
+	DeployKey  string //项目部署私钥，未单独设置授权时ssh地址使用该私钥
+	Submodules bool   //检出时递归更新子模块
+	Lfs        bool   //检出时拉取LFS文件
+	Backend    string //git实现方式，为空时使用全局配置
+	SubDir     string //只检出仓库中的子目录，为空时检出整个仓库
+}
+
+type Submodule struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+// SubmoduleRepo 支持子模块的仓库
+type SubmoduleRepo interface {
+	Submodules() ([]Submodule, error)
+}
+
 type Repo interface {
 	Tags() ([]Tag, error)
 	Commits(branch string) ([]Commit, error)
 	Branches() ([]Branch, error)
-	CheckoutToBranch(branch string) error
-	CheckoutToCommit(branch, commit string) error
-	CheckoutToTag(tag string) error
+	//检出代码到dest目录，每个上线单使用独立的目录，返回实际检出的版本(git为完整的commit hash)
+	CheckoutToBranch(branch, dest string) (string, error)
+	CheckoutToCommit(branch, commit, dest string) (string, error)
+	CheckoutToTag(tag, dest string) (string, error)
+	//同步远程仓库到本地
+	Fetch() error
 	Path() string
 	Type() TypeRepo
 }
 
-func (r *Repos) New(repoType TypeRepo, repoUrl, projectName string) (Repo, error) {
+func (r *Repos) New(repoType TypeRepo, repoUrl, projectName string, opts *Options) (Repo, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
 	switch repoType {
 	case GitRepo:
-		return NewGit(&r.config.Git, repoUrl, r.config.RepoDir+"/"+projectName)
+		backend := opts.Backend
+		if backend == "" {
+			backend = r.config.Git.Backend
+		}
+		//两种实现的镜像格式不同，分开存放
+		if backend == GitBackendCli {
+			return NewGitCli(&r.config.Git, repoUrl, r.config.RepoDir+"/"+projectName+"-cli", opts)
+		}
+		return NewGit(&r.config.Git, repoUrl, r.config.RepoDir+"/"+projectName, opts)
 	case SvnRepo:
-		return NewSvn(&r.config.Svn, repoUrl, r.config.RepoDir+"/"+projectName)
+		return NewSvn(&r.config.Svn, repoUrl, r.config.RepoDir+"/"+projectName, opts)
+	case ArtifactRepo:
+		return NewArtifact(&r.config.Artifact, repoUrl, r.config.RepoDir+"/"+projectName, opts)
 	}
 	return nil, ErrRepo.New("仓库类型不支持")
 }
+
+// CleanSubDir 规范子目录写法，去掉首尾的/，不能跳出仓库根目录，根目录返回空
+func CleanSubDir(dir string) string {
+	dir = strings.TrimSpace(strings.ReplaceAll(dir, "\\", "/"))
+	if dir == "" {
+		return ""
+	}
+	return strings.TrimPrefix(path.Clean("/"+dir), "/")
+}
+
+var commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// CheckCommit commit只能是4到40位的hash，避免被git当作命令参数
+func CheckCommit(commit string) error {
+	if !commitPattern.MatchString(commit) {
+		return ErrRepo.New("commit错误：%q", commit)
+	}
+	return nil
+}
+
+// CheckRefName 分支或标签名需符合git check-ref-format的规则，并且不能以-开头
+func CheckRefName(name string) error {
+	err := ErrRepo.New("分支或标签名错误：%q", name)
+	if name == "" || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || name == "@" || strings.Contains(name, "..") || strings.Contains(name, "@{") ||
+		strings.Contains(name, "//") || strings.ContainsAny(name, " ~^:?*[\\\x7f") {
+		return err
+	}
+	for _, r := range name {
+		if r < 0x20 {
+			return err
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return err
+		}
+	}
+	return nil
+}
+
+// InSubDir 仓库中的文件是否在子目录下，子目录为空时总是返回true
+func InSubDir(subDir, file string) bool {
+	if subDir == "" {
+		return true
+	}
+	file = strings.TrimPrefix(file, "/")
+	return file == subDir || strings.HasPrefix(file, subDir+"/")
+}
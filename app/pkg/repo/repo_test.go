@@ -24,8 +24,7 @@ var url = "git@code.nextstorage.cn:wuxin/tools.git"
 var name = "itools"
 
 func TestRepo(t *testing.T) {
-	_ = Init(&testConfig)
-	repo, err := New(RepoGit, url, name)
+	repo, err := NewRepos(&testConfig).New(GitRepo, url, name, nil)
 	fmt.Println(repo, err)
 }
 
@@ -107,3 +106,59 @@ func TestRepo(t *testing.T) {
 //	}
 //	fmt.Println("CheckoutToCommit success")
 //}
+
+func TestCheckCommit(t *testing.T) {
+	for commit, ok := range map[string]bool{
+		"abcd": true,
+		"0123456789abcdefABCDEF0123456789abcdef01": true,
+		"abc": false,
+		"0123456789abcdef0123456789abcdef012345678": false,
+		"":                      false,
+		"--upload-pack=touch x": false,
+		"abcd^{commit}":         false,
+		"HEAD":                  false,
+		"refs/heads/main":       false,
+	} {
+		if err := CheckCommit(commit); (err == nil) != ok {
+			t.Errorf("CheckCommit(%q) = %v", commit, err)
+		}
+	}
+}
+
+func TestCheckRefName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"main":            true,
+		"feature/login":   true,
+		"v1.0.0":          true,
+		"release-2023_01": true,
+		"中文分支":            true,
+		"":                false,
+		"-f":              false,
+		"--upload-pack=x": false,
+		"/main":           false,
+		"main/":           false,
+		"a//b":            false,
+		"a..b":            false,
+		"a.":              false,
+		".hidden":         false,
+		"a/.hidden":       false,
+		"a.lock":          false,
+		"a.lock/b":        false,
+		"@":               false,
+		"a@{1}":           false,
+		"a b":             false,
+		"a~1":             false,
+		"a^":              false,
+		"a:b":             false,
+		"a?":              false,
+		"a*":              false,
+		"a[":              false,
+		"a\\b":            false,
+		"a\tb":            false,
+		"a\x7f":           false,
+	} {
+		if err := CheckRefName(name); (err == nil) != ok {
+			t.Errorf("CheckRefName(%q) = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+type SignKeyType string
+
+const (
+	SignKeyGpg SignKeyType = "gpg"
+	SignKeySsh SignKeyType = "ssh"
+)
+
+var (
+	ErrUnsigned  = errors.New("未签名")
+	ErrUntrusted = errors.New("签名密钥不受信任")
+)
+
+// sshSigNamespace git使用ssh签名时的命名空间
+const sshSigNamespace = "git"
+
+// TrustedKey 受信任的签名公钥
+type TrustedKey struct {
+	Name      string
+	Type      SignKeyType
+	PublicKey string
+}
+
+// Signature 验证通过的签名信息
+type Signature struct {
+	Object      string      `json:"object"` //commit或者tag
+	Hash        string      `json:"hash"`
+	Commit      string      `json:"commit"` //签名对应的commit，tag为tag指向的commit
+	Type        SignKeyType `json:"type"`
+	KeyName     string      `json:"key_name"`
+	Fingerprint string      `json:"fingerprint"`
+}
+
+// SignatureRepo 支持签名验证的仓库
+type SignatureRepo interface {
+	VerifyCommit(commit string, keys []TrustedKey) (*Signature, error)
+	// VerifyTag 附注tag有签名时验证tag，否则验证tag指向的commit，返回的Commit为tag指向的commit
+	VerifyTag(tag string, keys []TrustedKey) (*Signature, error)
+}
+
+// KeyFingerprint 校验公钥格式并返回指纹
+func KeyFingerprint(keyType SignKeyType, publicKey string) (string, error) {
+	switch keyType {
+	case SignKeyGpg:
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(publicKey))
+		if err != nil {
+			return "", fmt.Errorf("gpg公钥格式错误：%w", err)
+		}
+		if len(entities) != 1 {
+			return "", errors.New("每次只能添加一个gpg公钥")
+		}
+		return strings.ToUpper(hex.EncodeToString(entities[0].PrimaryKey.Fingerprint)), nil
+	case SignKeySsh:
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+		if err != nil {
+			return "", fmt.Errorf("ssh公钥格式错误：%w", err)
+		}
+		return ssh.FingerprintSHA256(pub), nil
+	}
+	return "", fmt.Errorf("不支持的签名类型：%s", keyType)
+}
+
+// verifyCommitObject 验证commit签名
+func verifyCommitObject(commit *object.Commit, keys []TrustedKey) (*Signature, error) {
+	if commit.PGPSignature == "" {
+		return nil, fmt.Errorf("commit %s %w", commit.Hash, ErrUnsigned)
+	}
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return nil, err
+	}
+	sign, err := verifyObject(encoded, commit.PGPSignature, keys)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s %w", commit.Hash, err)
+	}
+	sign.Object, sign.Hash, sign.Commit = "commit", commit.Hash.String(), commit.Hash.String()
+	return sign, nil
+}
+
+// sshSignatureBegin ssh签名的开头
+const sshSignatureBegin = "-----BEGIN SSH SIGNATURE-----"
+
+// signedTag go-git只识别tag的gpg签名，ssh签名仍在说明中，拆分出来后返回新的tag对象
+func signedTag(tag *object.Tag) (*object.Tag, bool) {
+	if tag.PGPSignature != "" {
+		return tag, true
+	}
+	i := strings.Index(tag.Message, sshSignatureBegin)
+	if i < 0 {
+		return tag, false
+	}
+	t := *tag
+	t.Message, t.PGPSignature = tag.Message[:i], tag.Message[i:]
+	return &t, true
+}
+
+// verifyTagObject 验证附注tag的签名
+func verifyTagObject(tag *object.Tag, keys []TrustedKey) (*Signature, error) {
+	tag, _ = signedTag(tag)
+	if tag.PGPSignature == "" {
+		return nil, fmt.Errorf("tag %s %w", tag.Name, ErrUnsigned)
+	}
+	encoded := &plumbing.MemoryObject{}
+	if err := tag.EncodeWithoutSignature(encoded); err != nil {
+		return nil, err
+	}
+	sign, err := verifyObject(encoded, tag.PGPSignature, keys)
+	if err != nil {
+		return nil, fmt.Errorf("tag %s %w", tag.Name, err)
+	}
+	sign.Object, sign.Hash = "tag", tag.Hash.String()
+	if tag.TargetType == plumbing.CommitObject {
+		sign.Commit = tag.Target.String()
+	}
+	return sign, nil
+}
+
+func verifyObject(encoded *plumbing.MemoryObject, signature string, keys []TrustedKey) (*Signature, error) {
+	r, err := encoded.Reader()
+	if err != nil {
+		return nil, err
+	}
+	payload := &bytes.Buffer{}
+	if _, err = payload.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(strings.TrimSpace(signature), sshSignatureBegin) {
+		return verifySsh(payload.Bytes(), signature, keys)
+	}
+	return verifyGpg(payload.Bytes(), signature, keys)
+}
+
+func verifyGpg(payload []byte, signature string, keys []TrustedKey) (*Signature, error) {
+	for _, key := range keys {
+		if key.Type != SignKeyGpg {
+			continue
+		}
+		keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key.PublicKey))
+		if err != nil {
+			continue
+		}
+		signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(payload), strings.NewReader(signature), nil)
+		if err != nil {
+			continue
+		}
+		return &Signature{
+			Type:        SignKeyGpg,
+			KeyName:     key.Name,
+			Fingerprint: strings.ToUpper(hex.EncodeToString(signer.PrimaryKey.Fingerprint)),
+		}, nil
+	}
+	return nil, ErrUntrusted
+}
+
+// verifySsh 按照openssh的PROTOCOL.sshsig验证签名，签名中携带了公钥，公钥需在受信任列表中
+func verifySsh(payload []byte, signature string, keys []TrustedKey) (*Signature, error) {
+	pub, err := checkSshSignature(payload, signature, sshSigNamespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.Type != SignKeySsh {
+			continue
+		}
+		trusted, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(trusted.Marshal(), pub.Marshal()) {
+			return &Signature{Type: SignKeySsh, KeyName: key.Name, Fingerprint: ssh.FingerprintSHA256(pub)}, nil
+		}
+	}
+	return nil, ErrUntrusted
+}
+
+const sshSigMagic = "SSHSIG"
+
+type sshSigBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSigSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// checkSshSignature 校验签名本身是否有效，返回签名使用的公钥
+func checkSshSignature(payload []byte, signature, namespace string) (ssh.PublicKey, error) {
+	block, _ := pem.Decode([]byte(signature))
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return nil, errors.New("ssh签名格式错误")
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(sshSigMagic)) {
+		return nil, errors.New("ssh签名格式错误")
+	}
+	blob := sshSigBlob{}
+	if err := ssh.Unmarshal(block.Bytes[len(sshSigMagic):], &blob); err != nil {
+		return nil, fmt.Errorf("ssh签名格式错误：%w", err)
+	}
+	if blob.Version != 1 {
+		return nil, fmt.Errorf("不支持的ssh签名版本：%d", blob.Version)
+	}
+	if blob.Namespace != namespace {
+		return nil, fmt.Errorf("ssh签名命名空间错误：%s", blob.Namespace)
+	}
+	pub, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sig := &ssh.Signature{}
+	if err = ssh.Unmarshal(blob.Signature, sig); err != nil {
+		return nil, fmt.Errorf("ssh签名格式错误：%w", err)
+	}
+	var hash []byte
+	switch blob.HashAlgorithm {
+	case "sha256":
+		h := sha256.Sum256(payload)
+		hash = h[:]
+	case "sha512":
+		h := sha512.Sum512(payload)
+		hash = h[:]
+	default:
+		return nil, fmt.Errorf("不支持的ssh签名哈希算法：%s", blob.HashAlgorithm)
+	}
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSigSignedData{
+		Namespace:     blob.Namespace,
+		Reserved:      blob.Reserved,
+		HashAlgorithm: blob.HashAlgorithm,
+		Hash:          hash,
+	})...)
+	if err = pub.Verify(signed, sig); err != nil {
+		return nil, fmt.Errorf("ssh签名无效：%w", err)
+	}
+	return pub, nil
+}
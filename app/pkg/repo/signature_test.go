@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifySshSignature(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen不存在，跳过测试")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git不存在，跳过测试")
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", keyFile).CombinedOutput(); err != nil {
+		t.Fatal(err, string(out))
+	}
+	pub, err := os.ReadFile(keyFile + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := t.TempDir()
+	gitRun(t, url, "init", "-b", "main")
+	gitRun(t, url, "config", "gpg.format", "ssh")
+	gitRun(t, url, "config", "user.signingkey", keyFile)
+	if err = os.WriteFile(filepath.Join(url, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, url, "add", ".")
+	gitRun(t, url, "commit", "-m", "unsigned")
+	unsigned := gitRun(t, url, "rev-parse", "HEAD")
+	gitRun(t, url, "tag", "v0.1.0")
+	gitRun(t, url, "commit", "-S", "--allow-empty", "-m", "signed")
+	signed := gitRun(t, url, "rev-parse", "HEAD")
+	gitRun(t, url, "tag", "-s", "-m", "v1.0.0", "v1.0.0")
+
+	keys := []TrustedKey{{Name: "ci", Type: SignKeySsh, PublicKey: string(pub)}}
+	fingerprint, err := KeyFingerprint(SignKeySsh, string(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, backend := range []string{GitBackendGoGit, GitBackendCli} {
+		t.Run(backend, func(t *testing.T) {
+			srv, err := NewRepos(&Config{RepoDir: t.TempDir()}).New(GitRepo, "file://"+url, "project", &Options{Backend: backend})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = srv.Fetch(); err != nil {
+				t.Fatal(err)
+			}
+			r := srv.(SignatureRepo)
+			sign, err := r.VerifyCommit(signed, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sign.KeyName != "ci" || sign.Fingerprint != fingerprint || sign.Object != "commit" || sign.Commit != signed {
+				t.Error("签名信息错误", sign)
+			}
+			if _, err = r.VerifyCommit(unsigned, keys); !errors.Is(err, ErrUnsigned) {
+				t.Error("未签名的commit应返回ErrUnsigned", err)
+			}
+			if _, err = r.VerifyCommit(signed, nil); !errors.Is(err, ErrUntrusted) {
+				t.Error("不受信任的密钥应返回ErrUntrusted", err)
+			}
+			if sign, err = r.VerifyTag("v1.0.0", keys); err != nil || sign.Object != "tag" || sign.Commit != signed {
+				t.Error("tag签名验证失败", sign, err)
+			}
+			if _, err = r.VerifyTag("v0.1.0", keys); !errors.Is(err, ErrUnsigned) {
+				t.Error("轻量标签应验证指向的commit", err)
+			}
+		})
+	}
+}
+
+func TestVerifyGpgSignature(t *testing.T) {
+	entity, err := openpgp.NewEntity("walle", "", "walle@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	r, err := git.PlainInit(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := wt.Commit("signed", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "walle", Email: "walle@example.com", When: time.Now()},
+		SignKey:           entity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := KeyFingerprint(SignKeyGpg, buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := verifyCommitObject(commit, []TrustedKey{{Name: "release", Type: SignKeyGpg, PublicKey: buf.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sign.KeyName != "release" || sign.Fingerprint != fingerprint {
+		t.Error("签名信息错误", sign)
+	}
+	if _, err = verifyCommitObject(commit, nil); !errors.Is(err, ErrUntrusted) {
+		t.Error("不受信任的密钥应返回ErrUntrusted", err)
+	}
+}
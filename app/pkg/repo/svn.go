@@ -1,41 +1,231 @@
 package repo
 
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 标准目录结构下的主干分支名
+const svnTrunk = "trunk"
+
+var svnExportedRevision = regexp.MustCompile(`Exported revision (\d+)\.`)
+
 type SvnConfig struct {
-	Username string `help:"svn帐号" default:""`
-	Password string `help:"svn密码" default:""`
+	Username   string `help:"svn帐号" default:""`
+	Password   string `help:"svn密码" default:""`
+	FetchDepth int    `help:"记录数量" default:"50"`
 }
 
+// Svn 通过svn命令行操作仓库，仓库地址为标准目录结构(trunk,branches,tags)的根目录，
+// 发布时使用svn export导出代码，本地不保存工作副本
 type Svn struct {
 	config *SvnConfig
 	path   string
 	url    string
+	opts   *Options
+}
+
+func NewSvn(cfg *SvnConfig, url string, path string, opts *Options) (*Svn, error) {
+	if _, err := exec.LookPath("svn"); err != nil {
+		return nil, ErrRepoSvn.New("svn命令不存在，请先安装subversion")
+	}
+	return &Svn{config: cfg, url: strings.TrimRight(url, "/"), path: path, opts: opts}, nil
+}
+
+type svnList struct {
+	Entries []struct {
+		Kind   string `xml:"kind,attr"`
+		Name   string `xml:"name"`
+		Commit struct {
+			Revision string `xml:"revision,attr"`
+		} `xml:"commit"`
+	} `xml:"list>entry"`
 }
 
-func NewSvn(cfg *SvnConfig, url string, path string) (*Svn, error) {
-	return &Svn{config: cfg, url: url, path: path}, nil
+type svnLog struct {
+	Entries []struct {
+		Revision string    `xml:"revision,attr"`
+		Author   string    `xml:"author"`
+		Date     time.Time `xml:"date"`
+		Msg      string    `xml:"msg"`
+	} `xml:"logentry"`
 }
 
+type svnInfo struct {
+	Entry struct {
+		Revision string `xml:"revision,attr"`
+		Url      string `xml:"url"`
+		Commit   struct {
+			Revision string `xml:"revision,attr"`
+		} `xml:"commit"`
+	} `xml:"entry"`
+}
+
+// Tags 获取tags目录下的所有标签
 func (srv *Svn) Tags() ([]Tag, error) {
-	return nil, ErrRepoSvn.New("todo")
+	list, err := srv.list(srv.url + "/tags")
+	if err != nil {
+		return nil, err
+	}
+	_tags := make([]Tag, 0, len(list.Entries))
+	for _, v := range list.Entries {
+		if v.Kind == "dir" {
+			_tags = append(_tags, Tag{Name: v.Name, Hash: v.Commit.Revision})
+		}
+	}
+	return _tags, nil
 }
+
+// Commits 获取对应分支的提交记录，Hash为版本号
 func (srv *Svn) Commits(branch string) ([]Commit, error) {
-	return nil, ErrRepoSvn.New("todo")
+	args := []string{"log", "--xml"}
+	if srv.config.FetchDepth > 0 {
+		args = append(args, "--limit", strconv.Itoa(srv.config.FetchDepth))
+	}
+	out, err := srv.run(append(args, srv.subDirUrl(srv.branchUrl(branch)))...)
+	if err != nil {
+		return nil, err
+	}
+	log := svnLog{}
+	if err = xml.Unmarshal(out, &log); err != nil {
+		return nil, ErrRepoSvn.Wrap(err)
+	}
+	_commits := make([]Commit, 0, len(log.Entries))
+	for _, v := range log.Entries {
+		_commits = append(_commits, Commit{
+			Name:      "r" + v.Revision + "#" + v.Msg,
+			Message:   v.Msg,
+			Timestamp: v.Date,
+			Hash:      v.Revision,
+		})
+	}
+	return _commits, nil
 }
+
+// Branches 获取trunk和branches目录下的所有分支
 func (srv *Svn) Branches() ([]Branch, error) {
-	return nil, ErrRepoSvn.New("todo")
+	out, err := srv.run("info", "--xml", srv.url+"/"+svnTrunk)
+	if err != nil {
+		return nil, err
+	}
+	info := svnInfo{}
+	if err = xml.Unmarshal(out, &info); err != nil {
+		return nil, ErrRepoSvn.Wrap(err)
+	}
+	_branches := []Branch{{Name: svnTrunk, Hash: info.Entry.Commit.Revision}}
+	list, err := srv.list(srv.url + "/branches")
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range list.Entries {
+		if v.Kind == "dir" {
+			_branches = append(_branches, Branch{Name: v.Name, Hash: v.Commit.Revision})
+		}
+	}
+	return _branches, nil
 }
-func (srv *Svn) CheckoutToBranch(branch string) error {
-	return ErrRepoSvn.New("todo")
+
+func (srv *Svn) CheckoutToBranch(branch, dest string) (string, error) {
+	return srv.export(srv.branchUrl(branch), "HEAD", dest)
 }
-func (srv *Svn) CheckoutToCommit(branch, commit string) error {
-	return ErrRepoSvn.New("todo")
+
+// CheckoutToCommit 导出分支的某个版本，commit为版本号
+func (srv *Svn) CheckoutToCommit(branch, commit, dest string) (string, error) {
+	return srv.export(srv.branchUrl(branch), strings.TrimPrefix(commit, "r"), dest)
+}
+
+func (srv *Svn) CheckoutToTag(tag, dest string) (string, error) {
+	return srv.export(srv.url+"/tags/"+tag, "HEAD", dest)
 }
-func (srv *Svn) CheckoutToTag(tag string) error {
-	return ErrRepoSvn.New("todo")
+
+// Fetch svn直接从服务器读取，不需要同步
+func (srv *Svn) Fetch() error {
+	return nil
 }
+
 func (srv *Svn) Path() string {
 	return srv.path
 }
+
 func (srv *Svn) Type() TypeRepo {
 	return SvnRepo
 }
+
+// export 直接从服务器导出代码到dest目录，不需要本地工作副本，返回导出的版本号
+func (srv *Svn) export(url, revision, dest string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return "", ErrRepoSvn.Wrap(err)
+	}
+	out, err := srv.run("export", "--force", "--revision", revision, srv.subDirUrl(url), dest)
+	if err != nil {
+		return "", err
+	}
+	//输出的最后一行为 Exported revision N.
+	if m := svnExportedRevision.FindSubmatch(out); m != nil {
+		return string(m[1]), nil
+	}
+	return revision, nil
+}
+
+// subDirUrl 设置了子目录时只导出子目录，日志也只包含修改了子目录的版本
+func (srv *Svn) subDirUrl(url string) string {
+	if srv.opts.SubDir == "" {
+		return url
+	}
+	return url + "/" + srv.opts.SubDir
+}
+
+func (srv *Svn) branchUrl(branch string) string {
+	if branch == "" || branch == svnTrunk {
+		return srv.url + "/" + svnTrunk
+	}
+	return srv.url + "/branches/" + branch
+}
+
+func (srv *Svn) list(url string) (*svnList, error) {
+	out, err := srv.run("list", "--xml", url)
+	if err != nil {
+		return nil, err
+	}
+	list := &svnList{}
+	if err = xml.Unmarshal(out, list); err != nil {
+		return nil, ErrRepoSvn.Wrap(err)
+	}
+	return list, nil
+}
+
+// command 生成svn命令，项目设置了帐号密码或token时优先使用项目设置，
+// 密码通过stdin传递，不出现在进程的命令行参数中
+func (srv *Svn) command(args ...string) *exec.Cmd {
+	args = append([]string{"--non-interactive", "--no-auth-cache"}, args...)
+	username, password := srv.config.Username, srv.config.Password
+	if srv.opts.AuthType == AuthBasic || srv.opts.AuthType == AuthToken {
+		username, password = srv.opts.Username, srv.opts.Password
+	}
+	cmd := exec.Command("svn")
+	if username != "" {
+		args = append(args, "--username", username, "--password-from-stdin")
+		cmd.Stdin = strings.NewReader(password + "\n")
+	}
+	cmd.Args = append(cmd.Args, args...)
+	return cmd
+}
+
+// run 执行svn命令
+func (srv *Svn) run(args ...string) ([]byte, error) {
+	cmd := srv.command(args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, ErrRepoSvn.New("svn %s: %s %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestSvn 创建本地file://仓库，包含trunk两次提交，分支dev和标签v1.0.0
+func newTestSvn(t *testing.T) *Svn {
+	for _, bin := range []string{"svn", "svnadmin"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s不存在，跳过svn测试", bin)
+		}
+	}
+	root := t.TempDir()
+	repoDir := filepath.Join(root, "repo")
+	url := "file://" + repoDir
+	wc := filepath.Join(root, "wc")
+	run := func(name string, args ...string) {
+		out, err := exec.Command(name, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s %v: %s %s", name, args, err, out)
+		}
+	}
+	run("svnadmin", "create", repoDir)
+	run("svn", "mkdir", "-m", "layout", url+"/trunk", url+"/branches", url+"/tags")
+	run("svn", "checkout", url+"/trunk", wc)
+	if err := os.WriteFile(filepath.Join(wc, "a.txt"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("svn", "add", filepath.Join(wc, "a.txt"))
+	run("svn", "commit", "-m", "first", wc)
+	if err := os.WriteFile(filepath.Join(wc, "a.txt"), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("svn", "commit", "-m", "second", wc)
+	run("svn", "copy", "-m", "branch", url+"/trunk", url+"/branches/dev")
+	run("svn", "copy", "-m", "tag", "-r", "2", url+"/trunk", url+"/tags/v1.0.0")
+
+	srv, err := NewSvn(&SvnConfig{FetchDepth: 50}, url, filepath.Join(root, "project"), &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestSvnBranchesAndTags(t *testing.T) {
+	srv := newTestSvn(t)
+	branches, err := srv.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 2 || branches[0].Name != "trunk" || branches[1].Name != "dev" {
+		t.Error("分支错误", branches)
+	}
+	tags, err := srv.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "v1.0.0" {
+		t.Error("标签错误", tags)
+	}
+	commits, err := srv.Commits("trunk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 3 || commits[0].Hash != "3" || commits[0].Message != "second" {
+		t.Error("提交记录错误", commits)
+	}
+}
+
+func TestSvnCheckout(t *testing.T) {
+	srv := newTestSvn(t)
+	check := func(dest, want string) {
+		b, err := os.ReadFile(filepath.Join(dest, "a.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("文件内容为%s，期望%s", b, want)
+		}
+	}
+	dir := t.TempDir()
+	revision, err := srv.CheckoutToBranch("trunk", filepath.Join(dir, "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(dir, "1"), "v2")
+	if revision == "" || revision == "HEAD" {
+		t.Error("应返回导出的版本号", revision)
+	}
+	if revision, err = srv.CheckoutToCommit("trunk", "2", filepath.Join(dir, "2")); err != nil || revision != "2" {
+		t.Fatal(revision, err)
+	}
+	check(filepath.Join(dir, "2"), "v1")
+	if _, err := srv.CheckoutToTag("v1.0.0", filepath.Join(dir, "3")); err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(dir, "3"), "v1")
+	if _, err := srv.CheckoutToBranch("dev", filepath.Join(dir, "4")); err != nil {
+		t.Fatal(err)
+	}
+	check(filepath.Join(dir, "4"), "v2")
+}
+
+func TestSvnCommandPassword(t *testing.T) {
+	srv := &Svn{config: &SvnConfig{Username: "global", Password: "global-secret"}, opts: &Options{AuthType: AuthToken, Username: "ci", Password: "s3cret"}}
+	cmd := srv.command("list", "--xml", "svn://example.com/repo")
+	if strings.Contains(strings.Join(cmd.Args, " "), "s3cret") {
+		t.Error("密码不能出现在命令行参数中", cmd.Args)
+	}
+	if !strings.Contains(strings.Join(cmd.Args, " "), "--username ci --password-from-stdin") {
+		t.Error("应使用项目设置的帐号", cmd.Args)
+	}
+	stdin, _ := io.ReadAll(cmd.Stdin)
+	if string(stdin) != "s3cret\n" {
+		t.Error("密码应通过stdin传递", string(stdin))
+	}
+	srv = &Svn{config: &SvnConfig{}, opts: &Options{}}
+	if cmd = srv.command("list"); cmd.Stdin != nil || strings.Contains(strings.Join(cmd.Args, " "), "--username") {
+		t.Error("没有帐号时不传递认证信息", cmd.Args)
+	}
+}
@@ -0,0 +1,43 @@
+package safehttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// NewClient 访问用户填写的地址使用的http客户端，解析到内网地址时拒绝连接，跳转后同样检查，timeout为0时不限制
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if PrivateIP(ip.IP) {
+				return nil, fmt.Errorf("不能连接内网地址：%s", host)
+			}
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("无法解析地址：%s", host)
+		}
+		//连接解析时检查过的地址，防止再次解析得到其他地址
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// PrivateIP 回环、内网、链路本地和组播地址
+func PrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
@@ -0,0 +1,45 @@
+package safehttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrivateIP(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"127.0.0.1":       true,
+		"10.0.0.1":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"8.8.8.8":         false,
+		"2001:4860::8888": false,
+	} {
+		if PrivateIP(net.ParseIP(ip)) != want {
+			t.Errorf("PrivateIP(%s) != %v", ip, want)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	requested := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer ts.Close()
+	for _, u := range []string{ts.URL, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)} {
+		if _, err := NewClient(0).Get(u); err == nil || !strings.Contains(err.Error(), "内网") {
+			t.Error("内网地址应拒绝", u, err)
+		}
+	}
+	if requested {
+		t.Error("不应请求内网地址")
+	}
+}
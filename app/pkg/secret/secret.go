@@ -0,0 +1,117 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/zeebo/errs"
+	"io"
+	"strings"
+)
+
+var Error = errs.Class("secret")
+
+// 加密后内容的前缀，用于区分未加密的历史数据
+const prefix = "enc:"
+
+type Config struct {
+	Key string `help:"敏感数据(仓库密码、私钥等)加密key，设置后不能修改，否则已加密数据无法解密，未设置时不能保存敏感数据" default:""`
+}
+
+// Secret 使用AES-256-GCM加解密敏感数据，密钥由配置的key经sha256生成，未配置key时不能加解密
+type Secret struct {
+	aead cipher.AEAD
+}
+
+func NewSecret(cfg *Config) (*Secret, error) {
+	if cfg.Key == "" {
+		return &Secret{}, nil
+	}
+	key := sha256.Sum256([]byte(cfg.Key))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return &Secret{aead: aead}, nil
+}
+
+// Enabled 是否配置了加密key
+func (s *Secret) Enabled() bool {
+	return s.aead != nil
+}
+
+// IsEncrypted 是否为加密后的内容
+func IsEncrypted(text string) bool {
+	return strings.HasPrefix(text, prefix)
+}
+
+// Encrypt 加密，返回带前缀的base64内容
+func (s *Secret) Encrypt(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	if !s.Enabled() {
+		return "", Error.New("未配置secret.key，不能保存敏感数据")
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", Error.Wrap(err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密，没有加密前缀的内容视为未加密的历史数据原样返回
+func (s *Secret) Decrypt(text string) (string, error) {
+	if !IsEncrypted(text) {
+		return text, nil
+	}
+	if !s.Enabled() {
+		return "", Error.New("未配置secret.key，无法解密敏感数据")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, prefix))
+	if err != nil {
+		return "", Error.Wrap(err)
+	}
+	size := s.aead.NonceSize()
+	if len(sealed) < size {
+		return "", Error.New("invalid ciphertext")
+	}
+	plain, err := s.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return "", Error.Wrap(err)
+	}
+	return string(plain), nil
+}
+
+var defaultSecret *Secret
+
+// SetDefault 设置默认的加解密实例，供数据库字段加解密使用
+func SetDefault(s *Secret) {
+	defaultSecret = s
+}
+
+// Enabled 默认实例是否配置了加密key
+func Enabled() bool {
+	return defaultSecret != nil && defaultSecret.Enabled()
+}
+
+func Encrypt(plain string) (string, error) {
+	if defaultSecret == nil {
+		return "", Error.New("secret not init")
+	}
+	return defaultSecret.Encrypt(plain)
+}
+
+func Decrypt(text string) (string, error) {
+	if defaultSecret == nil {
+		return "", Error.New("secret not init")
+	}
+	return defaultSecret.Decrypt(text)
+}
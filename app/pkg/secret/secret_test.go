@@ -0,0 +1,70 @@
+package secret
+
+import "testing"
+
+func TestSecret(t *testing.T) {
+	s, err := NewSecret(&Config{Key: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := s.Encrypt("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc == "password" {
+		t.Error("未加密")
+	}
+	plain, err := s.Decrypt(enc)
+	if err != nil || plain != "password" {
+		t.Error("解密失败", plain, err)
+	}
+	//未加密的历史数据原样返回
+	if plain, _ = s.Decrypt("password"); plain != "password" {
+		t.Error("历史数据解密错误", plain)
+	}
+	other, _ := NewSecret(&Config{Key: "other"})
+	if _, err = other.Decrypt(enc); err == nil {
+		t.Error("错误的key解密成功")
+	}
+}
+
+func TestSecretWithoutKey(t *testing.T) {
+	s, err := NewSecret(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Enabled() {
+		t.Error("未配置key时不应启用加密")
+	}
+	if _, err = s.Encrypt("password"); err == nil {
+		t.Error("未配置key时不能加密")
+	}
+	if enc, err := s.Encrypt(""); err != nil || enc != "" {
+		t.Error("空内容不需要加密", enc, err)
+	}
+	key, _ := NewSecret(&Config{Key: "test"})
+	enc, _ := key.Encrypt("password")
+	if _, err = s.Decrypt(enc); err == nil {
+		t.Error("未配置key时不能解密")
+	}
+	if plain, err := s.Decrypt("password"); err != nil || plain != "password" {
+		t.Error("未加密的数据原样返回", plain, err)
+	}
+}
+
+func TestDefaultEnabled(t *testing.T) {
+	defer SetDefault(nil)
+	if Enabled() {
+		t.Error("未初始化时不应启用加密")
+	}
+	s, _ := NewSecret(&Config{})
+	SetDefault(s)
+	if Enabled() {
+		t.Error("未配置key时不应启用加密")
+	}
+	s, _ = NewSecret(&Config{Key: "test"})
+	SetDefault(s)
+	if !Enabled() {
+		t.Error("配置key后应启用加密")
+	}
+}
@@ -1,81 +1,316 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"net"
 	"sync"
+	"time"
 )
 
-// client ssh方便复用tcp连接管理
+// client 连接池中的ssh连接，同一服务器的会话复用一个tcp连接
 type client struct {
 	mux          sync.Mutex
 	sh           *Ssh
+	key          string
 	serverConfig *ServerConfig
 	client       *ssh.Client
-	ref          int
+	//跳板机的连接，为空时直接连接，本连接是跳板机上的一个转发
+	jump *client
+	//每个会话占用一个位置，达到最大会话数时等待
+	slots    chan struct{}
+	sessions int
+	//通过本连接转发的目标服务器连接数，不占用会话位置，有转发时不会空闲关闭
+	tunnels   int
+	createdAt time.Time
+	lastUsed  time.Time
+	closed    bool
+	closeChan chan struct{}
 }
 
-func (s *client) Key() string {
-	return s.serverConfig.String()
+func newSshClient(ctx context.Context, sh *Ssh, key string, conf *ServerConfig, jump *client) (_ *client, err error) {
+	config := &ssh.ClientConfig{
+		User:            conf.User,
+		Auth:            []ssh.AuthMethod{ssh.Password(conf.Password), ssh.PublicKeysCallback(publicKeys(sh, conf))},
+		Timeout:         sh.config.Timeout,
+		HostKeyCallback: hostKeyCallback(conf),
+	}
+	if conf.HostKey != "" {
+		config.HostKeyAlgorithms = hostKeyAlgorithms(conf.HostKey)
+	}
+	config.SetDefaults()
+	sshClient, err := dial(ctx, jump, conf.Host, conf.Port, config)
+	if nil != err {
+		return nil, err
+	}
+	maxSessions := sh.config.MaxSessions
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxSessions
+	}
+	now := time.Now()
+	c := &client{
+		sh:           sh,
+		key:          key,
+		serverConfig: conf,
+		client:       sshClient,
+		jump:         jump,
+		slots:        make(chan struct{}, maxSessions),
+		createdAt:    now,
+		lastUsed:     now,
+		closeChan:    make(chan struct{}),
+	}
+	go c.wait()
+	go c.monitor()
+	return c, nil
 }
 
-func (s *client) add() {
+// acquire 占用一个会话位置，达到最大会话数时最多等待连接超时时间
+func (s *client) acquire(ctx context.Context) error {
+	var timeout <-chan time.Time
+	if s.sh.config.Timeout > 0 {
+		timer := time.NewTimer(s.sh.config.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.closeChan:
+		return errClientClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return ErrSSH.New("%s的会话数已达到上限%d", s.serverConfig, cap(s.slots))
+	}
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.ref++
+	if s.closed {
+		<-s.slots
+		return errClientClosed
+	}
+	s.sessions++
+	s.lastUsed = time.Now()
+	return nil
 }
 
-func (s *client) done() {
+// release 释放会话位置，连接保留在连接池中直到空闲超时
+func (s *client) release() {
+	s.mux.Lock()
+	s.sessions--
+	s.lastUsed = time.Now()
+	s.mux.Unlock()
+	<-s.slots
+}
+
+// hold 作为跳板机转发一个目标服务器的连接，转发的tcp连接不是会话，不受最大会话数限制
+func (s *client) hold() error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.ref--
-	if s.ref == 0 {
-		_ = s.client.Close()
-		s.sh.removeClient(s.serverConfig)
+	if s.closed {
+		return errClientClosed
 	}
+	s.tunnels++
+	s.lastUsed = time.Now()
+	return nil
 }
 
-func newSshClient(sh *Ssh, conf *ServerConfig) (_ *client, err error) {
-	config := &ssh.ClientConfig{
-		User:            conf.User,
-		Auth:            []ssh.AuthMethod{ssh.Password(conf.Password), ssh.PublicKeysCallback(sh.IdentitySigners)},
-		Timeout:         sh.config.Timeout,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// unhold 目标服务器的连接关闭
+func (s *client) unhold() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.tunnels--
+	s.lastUsed = time.Now()
+}
+
+// close 关闭连接并移出连接池，同时释放占用的跳板机转发
+func (s *client) close() {
+	s.mux.Lock()
+	closed := s.markClosed()
+	s.mux.Unlock()
+	if closed {
+		s.shutdown()
 	}
-	config.SetDefaults()
-	tcpAddress := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
-	sshClient, err := ssh.Dial("tcp", tcpAddress, config)
-	if nil != err {
+}
+
+// closeIfIdle 没有会话并且空闲超时后关闭，检查和关闭需在同一个锁内，避免关闭刚获取的连接
+func (s *client) closeIfIdle() bool {
+	s.mux.Lock()
+	idle := s.sessions == 0 && s.tunnels == 0 && s.sh.config.IdleTimeout > 0 && time.Since(s.lastUsed) > s.sh.config.IdleTimeout
+	closed := idle && s.markClosed()
+	s.mux.Unlock()
+	if closed {
+		s.shutdown()
+	}
+	return idle
+}
+
+// markClosed 需要持有锁，返回是否为本次关闭
+func (s *client) markClosed() bool {
+	if s.closed {
+		return false
+	}
+	s.closed = true
+	close(s.closeChan)
+	return true
+}
+
+func (s *client) shutdown() {
+	_ = s.client.Close()
+	s.sh.removeClient(s)
+	if s.jump != nil {
+		s.jump.unhold()
+	}
+}
+
+// wait 连接断开时关闭，下次使用时重新连接
+func (s *client) wait() {
+	_ = s.client.Wait()
+	s.close()
+}
+
+// monitor 定时发送keepalive检测连接是否可用，并关闭空闲超时的连接
+func (s *client) monitor() {
+	interval := s.sh.config.KeepAlive
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeChan:
+			return
+		case <-ticker.C:
+		}
+		if s.closeIfIdle() {
+			return
+		}
+		if s.sh.config.KeepAlive <= 0 {
+			continue
+		}
+		if err := s.keepAlive(); err != nil {
+			s.close()
+			return
+		}
+	}
+}
+
+// keepAlive 和openssh的ServerAliveInterval一样发送keepalive请求，服务器不支持时也会回复，超时未回复认为连接已断开
+func (s *client) keepAlive() error {
+	errChan := make(chan error, 1)
+	go func() {
+		_, _, err := s.client.SendRequest("keepalive@openssh.com", true, nil)
+		errChan <- err
+	}()
+	timeout := s.sh.config.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckInterval
+	}
+	select {
+	case err := <-errChan:
+		return err
+	case <-time.After(timeout):
+		return ErrSSH.New("%s keepalive超时", s.serverConfig)
+	}
+}
+
+// info 连接状态
+func (s *client) info() Connection {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	conn := Connection{
+		User:        s.serverConfig.User,
+		Host:        s.serverConfig.Host,
+		Port:        s.serverConfig.Port,
+		Sessions:    s.sessions,
+		Tunnels:     s.tunnels,
+		MaxSessions: cap(s.slots),
+		CreatedAt:   s.createdAt,
+		LastUsedAt:  s.lastUsed,
+	}
+	if s.serverConfig.Jump != nil {
+		conn.Jump = s.serverConfig.Jump.String()
+	}
+	return conn
+}
+
+// dial 建立ssh连接，jump不为空时通过跳板机转发tcp连接，即ProxyJump，ctx取消时中断连接和握手
+func dial(ctx context.Context, jump *client, host string, port int, config *ssh.ClientConfig) (*ssh.Client, error) {
+	tcpAddress := fmt.Sprintf("%s:%d", host, port)
+	var conn net.Conn
+	var err error
+	if jump == nil {
+		dialer := &net.Dialer{Timeout: config.Timeout}
+		conn, err = dialer.DialContext(ctx, "tcp", tcpAddress)
+	} else {
+		conn, err = dialJump(ctx, jump, tcpAddress)
+		if err != nil {
+			err = fmt.Errorf("跳板机%s连接%s失败：%w", jump.serverConfig.Host, tcpAddress, err)
+		}
+	}
+	if err != nil {
 		return nil, err
 	}
-	return &client{
-		serverConfig: conf,
-		client:       sshClient,
-	}, nil
+	//握手过程中取消时关闭连接
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+	c, chans, reqs, err := ssh.NewClientConn(conn, tcpAddress, config)
+	close(done)
+	if err == nil && ctx.Err() != nil {
+		_ = c.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
-func (s *client) RunCmd(cmd string) (output []byte, err error) {
-	var session *ssh.Session
-	session, err = s.client.NewSession()
+// dialJump 通过跳板机连接，跳板机的连接不支持ctx，取消时放弃等待并关闭之后建立的连接
+func dialJump(ctx context.Context, jump *client, address string) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := jump.client.Dial("tcp", address)
+		ch <- result{conn, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.conn != nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *client) runCmd(cmd string) (output []byte, err error) {
+	session, err := s.client.NewSession()
 	if err != nil {
 		return nil, err
 	}
-	s.mux.Lock()
-	s.add()
-	s.mux.Unlock()
-	output, err = session.CombinedOutput(cmd)
-	_ = session.Close()
-	s.mux.Lock()
-	s.done()
-	s.mux.Unlock()
-	return
+	defer func() {
+		_ = session.Close()
+	}()
+	return session.CombinedOutput(cmd)
 }
 
 func (s *client) newTerminal(cols, rows int) (term *Terminal, err error) {
-	s.mux.Lock()
-	defer s.mux.Unlock()
 	session, err := s.client.NewSession()
 	if err != nil {
 		return nil, err
@@ -98,50 +333,52 @@ func (s *client) newTerminal(cols, rows int) (term *Terminal, err error) {
 	var writer io.Writer
 	reader, err = session.StdoutPipe()
 	if err != nil {
-		fmt.Println("session.StdoutPipe error:", err)
 		return
 	}
 	writer, err = session.StdinPipe()
 	if err != nil {
-		fmt.Println("session.StdinPipe error", err)
 		return
 	}
 	err = session.Shell()
 	if err != nil {
 		return
 	}
-	term = &Terminal{
+	return &Terminal{
 		client:  s,
 		session: session,
 		reader:  reader,
 		writer:  writer,
-	}
-	s.add()
-	return term, nil
+	}, nil
 }
 
 func (s *client) newSftp() (*Sftp, error) {
-	s.mux.Lock()
-	defer s.mux.Unlock()
 	scp, err := sftp.NewClient(s.client)
 	if err != nil {
 		return nil, err
 	}
-	_sftp := &Sftp{
+	return &Sftp{
 		client:     s,
 		sftpClient: scp,
-	}
-	s.add()
-	return _sftp, nil
+	}, nil
 }
 
-func (s *client) newRemoteExec() (*RemoteExec, error) {
-	s.mux.Lock()
-	defer s.mux.Unlock()
-	_re := &RemoteExec{
+func (s *client) newRemoteExec() *RemoteExec {
+	return &RemoteExec{
 		client: s,
 		envs:   NewEnvs(),
 	}
-	s.add()
-	return _re, nil
+}
+
+// publicKeys 服务器配置了私钥时只使用该私钥，否则使用IdentityFile
+func publicKeys(sh *Ssh, conf *ServerConfig) func() ([]ssh.Signer, error) {
+	if conf.PrivateKey == "" {
+		return sh.IdentitySigners
+	}
+	return func() ([]ssh.Signer, error) {
+		signer, err := ParsePrivateKey([]byte(conf.PrivateKey), conf.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.Signer{signer}, nil
+	}
 }
@@ -0,0 +1,375 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testServer 进程内的ssh服务器，exec时原样输出命令，支持direct-tcpip转发
+type testServer struct {
+	port    int
+	hostKey ssh.Signer
+	//为true时不回复keepalive
+	silent atomic.Bool
+	//正在转发的tcp连接数
+	tunnels atomic.Int32
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "test" {
+				return nil, errors.New("密码错误")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testServer{port: ln.Addr().(*net.TCPAddr).Port, hostKey: signer}
+	var mux sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		_ = ln.Close()
+		mux.Lock()
+		defer mux.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mux.Lock()
+			conns = append(conns, conn)
+			mux.Unlock()
+			go srv.serve(conn, config)
+		}
+	}()
+	return srv
+}
+
+func (srv *testServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go func() {
+		for req := range reqs {
+			if !srv.silent.Load() && req.WantReply {
+				_ = req.Reply(true, nil)
+			}
+		}
+	}()
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			go srv.session(nc)
+		case "direct-tcpip":
+			go srv.forward(nc)
+		default:
+			_ = nc.Reject(ssh.UnknownChannelType, nc.ChannelType())
+		}
+	}
+}
+
+func (srv *testServer) session(nc ssh.NewChannel) {
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		_ = ssh.Unmarshal(req.Payload, &payload)
+		_ = req.Reply(true, nil)
+		_, _ = ch.Write([]byte(payload.Command))
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
+
+func (srv *testServer) forward(nc ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	srv.tunnels.Add(1)
+	defer srv.tunnels.Add(-1)
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(ch, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, ch)
+		done <- struct{}{}
+	}()
+	<-done
+	_ = ch.Close()
+	_ = conn.Close()
+}
+
+func (srv *testServer) config() ServerConfig {
+	return ServerConfig{Host: "127.0.0.1", Port: srv.port, User: "test", Password: "test"}
+}
+
+// waitFor 等待条件成立，连接关闭等操作是异步的
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientAcquire(t *testing.T) {
+	srv := newTestServer(t)
+	sh, _ := NewSSH(&Config{Timeout: 5 * time.Second, MaxSessions: 2})
+	conf := srv.config()
+
+	first, err := sh.acquire(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sh.acquire(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || len(sh.Connections()) != 1 {
+		t.Fatal("同一服务器应复用连接")
+	}
+	if out, err := first.runCmd("echo ok"); err != nil || string(out) != "echo ok" {
+		t.Fatal("执行命令失败", string(out), err)
+	}
+	if info := first.info(); info.Sessions != 2 || info.MaxSessions != 2 {
+		t.Fatal("会话数错误", info)
+	}
+
+	//达到最大会话数时等待，ctx取消时立即返回
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err = sh.acquire(ctx, conf); !errors.Is(err, context.Canceled) {
+		t.Fatal("ctx取消时应返回context.Canceled", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("ctx取消后仍在等待", time.Since(start))
+	}
+
+	//释放后可以继续获取
+	first.release()
+	third, err := sh.acquire(context.Background(), conf)
+	if err != nil || third != first {
+		t.Fatal("释放后应可获取会话", err)
+	}
+	third.release()
+	second.release()
+	if info := first.info(); info.Sessions != 0 {
+		t.Fatal("会话未释放", info)
+	}
+}
+
+func TestClientAcquireTimeout(t *testing.T) {
+	srv := newTestServer(t)
+	sh, _ := NewSSH(&Config{Timeout: 200 * time.Millisecond, MaxSessions: 1})
+	sc, err := sh.acquire(context.Background(), srv.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.release()
+	if _, err = sh.acquire(context.Background(), srv.config()); err == nil {
+		t.Fatal("达到最大会话数时应超时")
+	}
+}
+
+func TestClientAcquireCanceled(t *testing.T) {
+	sh, _ := NewSSH(&Config{Timeout: 5 * time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	//不会回复ssh握手的服务器，ctx已取消时不应等待握手超时
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	start := time.Now()
+	if _, err = sh.acquire(ctx, ServerConfig{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, User: "test", Password: "test"}); err == nil {
+		t.Fatal("ctx已取消时应连接失败")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("ctx取消后仍在连接", time.Since(start))
+	}
+}
+
+func TestClientJump(t *testing.T) {
+	jumpSrv := newTestServer(t)
+	sh, _ := NewSSH(&Config{Timeout: 5 * time.Second, MaxSessions: 1})
+	jumpConf := jumpSrv.config()
+
+	//跳板机的会话数为1，转发的连接不占用会话位置
+	var clients []*client
+	for i := 0; i < 3; i++ {
+		conf := newTestServer(t).config()
+		conf.Jump = &jumpConf
+		sc, err := sh.acquire(context.Background(), conf)
+		if err != nil {
+			t.Fatal("通过跳板机连接失败", i, err)
+		}
+		if out, err := sc.runCmd("hostname"); err != nil || string(out) != "hostname" {
+			t.Fatal("执行命令失败", string(out), err)
+		}
+		clients = append(clients, sc)
+	}
+	jump := clients[0].jump
+	for _, sc := range clients {
+		if sc.jump != jump {
+			t.Fatal("应共用跳板机连接")
+		}
+	}
+	if info := jump.info(); info.Sessions != 0 || info.Tunnels != 3 {
+		t.Fatal("跳板机状态错误", info)
+	}
+	if jumpSrv.tunnels.Load() != 3 {
+		t.Fatal("跳板机转发数错误", jumpSrv.tunnels.Load())
+	}
+	//跳板机本身的会话不受转发影响
+	js, err := sh.acquire(context.Background(), jumpConf)
+	if err != nil || js != jump {
+		t.Fatal("获取跳板机会话失败", err)
+	}
+	js.release()
+
+	for _, sc := range clients {
+		sc.release()
+		sc.close()
+	}
+	if info := jump.info(); info.Tunnels != 0 {
+		t.Fatal("转发未释放", info)
+	}
+	waitFor(t, "跳板机的转发未关闭", func() bool {
+		return jumpSrv.tunnels.Load() == 0
+	})
+}
+
+func TestClientCloseIfIdle(t *testing.T) {
+	jumpSrv := newTestServer(t)
+	srv := newTestServer(t)
+	sh, _ := NewSSH(&Config{Timeout: 5 * time.Second, IdleTimeout: 50 * time.Millisecond})
+	jumpConf := jumpSrv.config()
+	conf := srv.config()
+	conf.Jump = &jumpConf
+
+	sc, err := sh.acquire(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sc.closeIfIdle() {
+		t.Fatal("有会话时不应关闭")
+	}
+	sc.release()
+	time.Sleep(100 * time.Millisecond)
+	//有转发的跳板机不应关闭
+	if sc.jump.closeIfIdle() {
+		t.Fatal("有转发时跳板机不应关闭")
+	}
+	if !sc.closeIfIdle() {
+		t.Fatal("空闲超时后应关闭")
+	}
+	if len(sh.Connections()) != 1 {
+		t.Fatal("关闭后应移出连接池", sh.Connections())
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !sc.jump.closeIfIdle() || len(sh.Connections()) != 0 {
+		t.Fatal("跳板机空闲超时后应关闭", sh.Connections())
+	}
+	if err = sc.acquire(context.Background()); !errors.Is(err, errClientClosed) {
+		t.Fatal("已关闭的连接不能获取会话", err)
+	}
+
+	//关闭后重新获取时新建连接
+	sc2, err := sh.acquire(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc2.release()
+	if sc2 == sc || sc2.jump == sc.jump {
+		t.Fatal("应新建连接")
+	}
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	srv := newTestServer(t)
+	sh, _ := NewSSH(&Config{Timeout: 200 * time.Millisecond, KeepAlive: 50 * time.Millisecond})
+	sc, err := sh.acquire(context.Background(), srv.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.release()
+	if err = sc.keepAlive(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if len(sh.Connections()) != 1 {
+		t.Fatal("keepalive正常时应保留连接")
+	}
+
+	//服务器不再回复时关闭连接
+	srv.silent.Store(true)
+	waitFor(t, "keepalive超时后应关闭连接", func() bool {
+		return len(sh.Connections()) == 0
+	})
+	if err = sc.acquire(context.Background()); !errors.Is(err, errClientClosed) {
+		t.Fatal("已关闭的连接不能获取会话", err)
+	}
+}
@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// HostKeyMismatchError 服务器的主机公钥与保存的不一致
+type HostKeyMismatchError struct {
+	Address  string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("服务器%s的主机公钥已变更(保存的指纹%s，当前的指纹%s)，可能存在中间人攻击，确认无误后请在服务器管理中接受新的主机公钥",
+		e.Address, e.Expected, e.Actual)
+}
+
+// MarshalHostKey 转换为known_hosts中使用的格式：<type> <base64>
+func MarshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// ParseHostKey 解析MarshalHostKey格式的公钥
+func ParseHostKey(s string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return nil, ErrSSH.Wrap(err)
+	}
+	return key, nil
+}
+
+// Fingerprint 主机公钥的SHA256指纹，解析失败时返回空
+func Fingerprint(hostKey string) string {
+	key, err := ParseHostKey(hostKey)
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// hostKeyCallback 已保存主机公钥时必须一致，否则信任首次连接时的公钥，并通过OnHostKey保存
+func hostKeyCallback(conf *ServerConfig) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if conf.HostKey == "" {
+			if conf.OnHostKey != nil {
+				return conf.OnHostKey(key)
+			}
+			return nil
+		}
+		expected, err := ParseHostKey(conf.HostKey)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(expected.Marshal(), key.Marshal()) {
+			return &HostKeyMismatchError{
+				Address:  hostname,
+				Expected: ssh.FingerprintSHA256(expected),
+				Actual:   ssh.FingerprintSHA256(key),
+			}
+		}
+		return nil
+	}
+}
+
+// hostKeyAlgorithms 已保存主机公钥时，要求服务器使用相同类型的公钥，避免服务器有多个公钥时误判为变更
+func hostKeyAlgorithms(hostKey string) []string {
+	key, err := ParseHostKey(hostKey)
+	if err != nil {
+		return nil
+	}
+	if key.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{key.Type()}
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+// ScanHostKey 获取服务器当前的主机公钥，不进行登录，conf.HostKey不为空时获取相同类型的公钥，
+// 配置了跳板机时通过跳板机连接
+func (s *Ssh) ScanHostKey(conf ServerConfig) (ssh.PublicKey, error) {
+	var scanned ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User:    "walle",
+		Timeout: s.config.Timeout,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			scanned = key
+			return errHostKeyScanned
+		},
+	}
+	if conf.HostKey != "" {
+		config.HostKeyAlgorithms = hostKeyAlgorithms(conf.HostKey)
+	}
+	var jump *client
+	if conf.Jump != nil {
+		var err error
+		if jump, err = s.jumpClient(context.Background(), *conf.Jump); err != nil {
+			return nil, ErrSSH.New("连接跳板机%s失败：%s", conf.Jump, err)
+		}
+		defer jump.unhold()
+	}
+	c, err := dial(context.Background(), jump, conf.Host, conf.Port, config)
+	if err == nil {
+		_ = c.Close()
+	}
+	if scanned == nil {
+		if err == nil {
+			err = errors.New("未获取到主机公钥")
+		}
+		return nil, ErrSSH.Wrap(err)
+	}
+	return scanned, nil
+}
+
+// KnownHosts known_hosts文件内容，用于导入服务器的主机公钥
+type KnownHosts struct {
+	callback ssh.HostKeyCallback
+}
+
+// NewKnownHosts 解析known_hosts文件内容，支持哈希过的主机名
+func NewKnownHosts(content string) (*KnownHosts, error) {
+	f, err := os.CreateTemp("", "walle-known-hosts-*")
+	if err != nil {
+		return nil, ErrSSH.Wrap(err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	_, err = f.WriteString(content)
+	if _err := f.Close(); err == nil {
+		err = _err
+	}
+	if err != nil {
+		return nil, ErrSSH.Wrap(err)
+	}
+	callback, err := knownhosts.New(f.Name())
+	if err != nil {
+		return nil, ErrSSH.Wrap(err)
+	}
+	return &KnownHosts{callback: callback}, nil
+}
+
+// Lookup 查找主机的所有公钥，找不到时返回空
+func (k *KnownHosts) Lookup(host string, port int) []ssh.PublicKey {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	tcpAddr := &net.TCPAddr{IP: net.ParseIP(host), Port: port}
+	//使用一个不可能存在的公钥探测，返回的KeyError中包含该主机已知的公钥
+	err := k.callback(addr, tcpAddr, probeKey{})
+	keyErr := &knownhosts.KeyError{}
+	if !errors.As(err, &keyErr) {
+		return nil
+	}
+	keys := make([]ssh.PublicKey, 0, len(keyErr.Want))
+	for _, want := range keyErr.Want {
+		keys = append(keys, want.Key)
+	}
+	return keys
+}
+
+// probeKey 用于探测known_hosts的公钥
+type probeKey struct{}
+
+func (probeKey) Type() string {
+	return "walle-probe"
+}
+
+func (probeKey) Marshal() []byte {
+	return []byte("walle-probe")
+}
+
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error {
+	return errors.New("probe key")
+}
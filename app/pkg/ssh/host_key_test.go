@@ -0,0 +1,147 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestPublicKey(t, pub)
+}
+
+func newTestPublicKey(t *testing.T, pub any) ssh.PublicKey {
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	srv := newTestServer(t)
+	serverKey := MarshalHostKey(srv.hostKey.PublicKey())
+	connect := func(conf ServerConfig) error {
+		sh, _ := NewSSH(&Config{Timeout: 5 * time.Second})
+		sc, err := sh.acquire(context.Background(), conf)
+		if err == nil {
+			sc.release()
+			sc.close()
+		}
+		return err
+	}
+
+	//首次连接信任服务器的公钥并保存
+	conf := srv.config()
+	pinned := ""
+	conf.OnHostKey = func(key ssh.PublicKey) error {
+		pinned = MarshalHostKey(key)
+		return nil
+	}
+	if err := connect(conf); err != nil {
+		t.Fatal(err)
+	}
+	if pinned != serverKey {
+		t.Fatal("保存的公钥错误", pinned)
+	}
+
+	//保存失败时不能连接
+	conf.OnHostKey = func(key ssh.PublicKey) error {
+		return errors.New("保存失败")
+	}
+	if err := connect(conf); err == nil {
+		t.Error("保存主机公钥失败时应拒绝连接")
+	}
+
+	//已保存的公钥一致时连接，不再保存
+	conf.HostKey = pinned
+	if err := connect(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	//公钥变更时拒绝连接
+	conf.HostKey = MarshalHostKey(newTestHostKey(t))
+	//握手错误只保留了错误信息，提示中需要有两个指纹
+	err := connect(conf)
+	if err == nil {
+		t.Fatal("公钥不一致时应拒绝连接")
+	}
+	if !strings.Contains(err.Error(), ssh.FingerprintSHA256(srv.hostKey.PublicKey())) || !strings.Contains(err.Error(), Fingerprint(conf.HostKey)) {
+		t.Error("提示中缺少指纹", err)
+	}
+
+	//扫描当前的公钥不需要登录
+	sh, _ := NewSSH(&Config{Timeout: 5 * time.Second})
+	conf.Password = "wrong"
+	key, err := sh.ScanHostKey(conf)
+	if err != nil || MarshalHostKey(key) != serverKey {
+		t.Error("获取主机公钥失败", err)
+	}
+}
+
+func TestKnownHostsLookup(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed, other, ec := newTestHostKey(t), newTestHostKey(t), newTestPublicKey(t, &ecKey.PublicKey)
+	//同一主机同一类型的公钥只使用第一个
+	content := fmt.Sprintf("# comment\n"+
+		"10.0.0.1,web1 %s\n"+
+		"[10.0.0.2]:2222 %s\n"+
+		"%s %s\n"+
+		"10.0.0.1 %s\n"+
+		"10.0.0.1 %s\n",
+		MarshalHostKey(ed),
+		MarshalHostKey(other),
+		knownhosts.HashHostname("10.0.0.3"), MarshalHostKey(ec),
+		MarshalHostKey(other),
+		MarshalHostKey(ec))
+	k, err := NewKnownHosts(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		host string
+		port int
+		want []ssh.PublicKey
+	}{
+		{"10.0.0.1", 22, []ssh.PublicKey{ed, ec}},
+		{"web1", 22, []ssh.PublicKey{ed}},
+		{"10.0.0.2", 2222, []ssh.PublicKey{other}},
+		{"10.0.0.2", 22, nil},
+		{"10.0.0.3", 22, []ssh.PublicKey{ec}},
+		{"10.0.0.4", 22, nil},
+	} {
+		got := k.Lookup(c.host, c.port)
+		if len(got) != len(c.want) {
+			t.Errorf("Lookup(%s:%d) = %d个公钥, want %d", c.host, c.port, len(got), len(c.want))
+			continue
+		}
+		found := make(map[string]bool)
+		for _, key := range got {
+			found[MarshalHostKey(key)] = true
+		}
+		for _, key := range c.want {
+			if !found[MarshalHostKey(key)] {
+				t.Errorf("Lookup(%s:%d)缺少公钥%s", c.host, c.port, key.Type())
+			}
+		}
+	}
+	if _, err = NewKnownHosts("10.0.0.1 ssh-ed25519 not-base64"); err == nil {
+		t.Error("格式错误时应报错")
+	}
+}
@@ -3,7 +3,10 @@ package ssh
 import (
 	"context"
 	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
 	"strings"
+	"sync"
 )
 
 type RemoteExec struct {
@@ -11,10 +14,13 @@ type RemoteExec struct {
 	envs      *Envs
 	ctx       context.Context
 	closeChan chan int
+	once      sync.Once
+	output    io.Writer
 }
 
+// Close 释放连接的会话位置
 func (e *RemoteExec) Close() error {
-	e.client.done()
+	e.once.Do(e.client.release)
 	return nil
 }
 
@@ -27,6 +33,12 @@ func (e *RemoteExec) WithEnvs(envs *Envs) Command {
 	return e
 }
 
+// WithOutput 执行过程中把输出写入w，用于实时查看输出，输出不再缓存，Run返回的输出为空
+func (e *RemoteExec) WithOutput(w io.Writer) *RemoteExec {
+	e.output = w
+	return e
+}
+
 func (e *RemoteExec) Run(cmd string) ([]byte, error) {
 	sess, err := e.client.client.NewSession()
 	if err != nil {
@@ -48,10 +60,31 @@ func (e *RemoteExec) Run(cmd string) ([]byte, error) {
 					return
 				}
 			case <-e.ctx.Done():
+				//只关闭会话时远程命令会继续执行，先通知远程进程退出
+				_ = sess.Signal(ssh.SIGTERM)
 				_ = sess.Close()
 				return
 			}
 		}()
 	}
-	return sess.CombinedOutput(cmd)
+	if e.output == nil {
+		return sess.CombinedOutput(cmd)
+	}
+	out := &combinedOutput{w: e.output}
+	sess.Stdout, sess.Stderr = out, out
+	return nil, sess.Run(cmd)
+}
+
+// combinedOutput 合并stdout和stderr写入w
+type combinedOutput struct {
+	mux sync.Mutex
+	w   io.Writer
+}
+
+func (o *combinedOutput) Write(p []byte) (int, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	//输出写入失败时不影响命令执行
+	_, _ = o.w.Write(p)
+	return len(p), nil
 }
@@ -1,33 +1,151 @@
 package ssh
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/pkg/sftp"
 	"io"
 	"os"
+	"path"
+	"sync"
+	"time"
 )
 
 type Sftp struct {
 	client     *client
 	sftpClient *sftp.Client
+	once       sync.Once
+	ctx        context.Context
+	limiters   []*RateLimiter
+	onProgress func(Progress)
 }
 
-// Close 关闭终端会话
-func (s *Sftp) Close() error {
-	defer func() {
-		s.client.done()
-	}()
-	return s.sftpClient.Close()
+// Close 关闭sftp会话，释放连接的会话位置
+func (s *Sftp) Close() (err error) {
+	s.once.Do(func() {
+		err = s.sftpClient.Close()
+		s.client.release()
+	})
+	return
+}
+
+// WithCtx ctx取消时中断上传
+func (s *Sftp) WithCtx(ctx context.Context) *Sftp {
+	s.ctx = ctx
+	return s
 }
 
+// WithProgress 上传过程中每秒回调一次进度，上传完成时再回调一次
+func (s *Sftp) WithProgress(fn func(Progress)) *Sftp {
+	s.onProgress = fn
+	return s
+}
+
+// Copy 上传文件，同时受服务器和全局的带宽限制
 func (s *Sftp) Copy(localFile, remoteFile string) error {
 	lf, err := os.Open(localFile)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = lf.Close()
+	}()
+	info, err := lf.Stat()
+	if err != nil {
+		return err
+	}
 	rf, err := s.sftpClient.Create(remoteFile)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(rf, lf)
-	return err
+	return s.write(rf, lf, info.Size())
+}
+
+// write 按带宽限制写入远程文件并关闭
+func (s *Sftp) write(rf *sftp.File, r io.Reader, size int64) error {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tr := newTransferReader(ctx, r, size, s.limiters, s.onProgress)
+	if _, err := io.Copy(rf, tr); err != nil {
+		_ = rf.Close()
+		return err
+	}
+	if err := rf.Close(); err != nil {
+		return err
+	}
+	tr.report(true)
+	return nil
+}
+
+// WriteFile 写入远程文件，先完整写到同目录的临时文件，写入失败时不会破坏原文件。
+// 新文件使用mode权限并重命名；已存在的文件从临时文件覆盖写入，保留原文件的所有者、权限和硬链接
+func (s *Sftp) WriteFile(remoteFile string, r io.Reader, size int64, mode os.FileMode) error {
+	tmp := path.Join(path.Dir(remoteFile), fmt.Sprintf(".%s.walle-%d", path.Base(remoteFile), time.Now().UnixNano()))
+	rf, err := s.sftpClient.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.sftpClient.Remove(tmp)
+	}()
+	if err = s.write(rf, r, size); err != nil {
+		return err
+	}
+	if _, err = s.sftpClient.Stat(remoteFile); errors.Is(err, os.ErrNotExist) {
+		if err = s.sftpClient.Chmod(tmp, mode); err != nil {
+			return err
+		}
+		return s.sftpClient.PosixRename(tmp, remoteFile)
+	} else if err != nil {
+		return err
+	}
+	return s.overwrite(tmp, remoteFile)
+}
+
+// overwrite 用src的内容覆盖dst，dst保持原来的inode
+func (s *Sftp) overwrite(src, dst string) error {
+	sf, err := s.sftpClient.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = sf.Close()
+	}()
+	df, err := s.sftpClient.OpenFile(dst, os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(df, sf); err != nil {
+		_ = df.Close()
+		return err
+	}
+	return df.Close()
+}
+
+// Open 打开远程文件用于读取
+func (s *Sftp) Open(remoteFile string) (io.ReadCloser, error) {
+	return s.sftpClient.Open(remoteFile)
+}
+
+// ReadDir 列出远程目录
+func (s *Sftp) ReadDir(dir string) ([]os.FileInfo, error) {
+	return s.sftpClient.ReadDir(dir)
+}
+
+// Stat 远程文件信息，符号链接返回指向的文件
+func (s *Sftp) Stat(remoteFile string) (os.FileInfo, error) {
+	return s.sftpClient.Stat(remoteFile)
+}
+
+// Lstat 远程文件信息，符号链接返回链接本身
+func (s *Sftp) Lstat(remoteFile string) (os.FileInfo, error) {
+	return s.sftpClient.Lstat(remoteFile)
+}
+
+// ReadLink 符号链接指向的路径
+func (s *Sftp) ReadLink(remoteFile string) (string, error) {
+	return s.sftpClient.ReadLink(remoteFile)
 }
@@ -1,29 +1,49 @@
 package ssh
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/zeebo/errs"
 	"golang.org/x/crypto/ssh"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
 
 var (
 	ErrSSH = errs.Class("ssh")
+
+	// errClientClosed 连接已关闭，需要重新连接
+	errClientClosed = errors.New("ssh client closed")
+)
+
+const (
+	defaultMaxSessions   = 10
+	defaultCheckInterval = 30 * time.Second
+	//连接刚建立就断开时最多重试的次数
+	maxAcquireRetries = 3
 )
 
 type Ssh struct {
 	config  *Config
 	mux     *sync.Mutex
 	clients map[string]*client
+	//全局的上传限速，以及每台服务器的上传限速
+	limiter  *RateLimiter
+	limiters map[string]*RateLimiter
 }
 
 func NewSSH(conf *Config) (*Ssh, error) {
 	return &Ssh{
-		config:  conf,
-		mux:     &sync.Mutex{},
-		clients: make(map[string]*client),
+		config:   conf,
+		mux:      &sync.Mutex{},
+		clients:  make(map[string]*client),
+		limiter:  NewRateLimiter(conf.Bandwidth * 1024),
+		limiters: make(map[string]*RateLimiter),
 	}, nil
 
 	//go func() {
@@ -49,6 +69,10 @@ type Config struct {
 	IdentityFile     string        `help:"免密登陆密钥地址" default:"$HOME/.ssh/id_rsa"`
 	IdentityPassword string        `help:"免密登陆密钥密码" default:""`
 	Timeout          time.Duration `help:"连接超时" default:"30s"`
+	KeepAlive        time.Duration `help:"发送keepalive检测连接的间隔，为0时不检测" default:"30s"`
+	IdleTimeout      time.Duration `help:"没有会话的连接保留的时间，为0时一直保留" default:"5m0s"`
+	MaxSessions      int           `help:"每个连接同时打开的最大会话数，超过时等待" default:"10"`
+	Bandwidth        int64         `help:"所有sftp上传的总带宽，单位KB/s，为0时不限制" default:"0"`
 }
 
 func (s *Ssh) IdentitySigners() (signers []ssh.Signer, err error) {
@@ -69,9 +93,22 @@ func (s *Ssh) IdentitySigner() (signer ssh.Signer, err error) {
 	if err != nil {
 		return nil, ErrSSH.Wrap(err)
 	}
-	signer, err = ssh.ParsePrivateKey(bytes)
+	return ParsePrivateKey(bytes, s.config.IdentityPassword)
+}
+
+// Signer 服务器配置了私钥时使用该私钥，否则使用IdentityFile
+func (s *Ssh) Signer(conf ServerConfig) (ssh.Signer, error) {
+	if conf.PrivateKey == "" {
+		return s.IdentitySigner()
+	}
+	return ParsePrivateKey([]byte(conf.PrivateKey), conf.Passphrase)
+}
+
+// ParsePrivateKey 解析私钥，私钥有密码时使用passphrase解密
+func ParsePrivateKey(key []byte, passphrase string) (signer ssh.Signer, err error) {
+	signer, err = ssh.ParsePrivateKey(key)
 	if _, ok := err.(*ssh.PassphraseMissingError); ok {
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(bytes, []byte(s.config.IdentityPassword))
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
 	}
 	if err != nil {
 		err = ErrSSH.Wrap(err)
@@ -84,78 +121,214 @@ type ServerConfig struct {
 	User     string `json:"user"`
 	Password string `json:"password"` //如果密码为空，则认为是免密登陆
 	Port     int    `json:"port"`
+	//私钥及其密码，为空时使用配置的IdentityFile
+	PrivateKey string `json:"-"`
+	Passphrase string `json:"-"`
+	//已信任的主机公钥，为空时信任首次连接的公钥并调用OnHostKey保存
+	HostKey   string                        `json:"host_key"`
+	OnHostKey func(key ssh.PublicKey) error `json:"-"`
+	//跳板机，不为空时通过跳板机连接，跳板机也可以有自己的跳板机
+	Jump *ServerConfig `json:"-"`
+	//sftp上传带宽，单位KB/s，为0时不限制，同一服务器的所有上传共用
+	Bandwidth int64 `json:"bandwidth"`
+}
+
+// String 用于展示，不包含密码等敏感信息
+func (s *ServerConfig) String() string {
+	str := fmt.Sprintf("%s@%s:%d", s.User, s.Host, s.Port)
+	if s.Jump != nil {
+		str += " via " + s.Jump.String()
+	}
+	return str
+}
+
+// poolKey 连接池中的key，使用不同凭证或者经过不同跳板机的连接不能复用，凭证只保留摘要
+func (s *ServerConfig) poolKey() string {
+	key := fmt.Sprintf("%s@%s:%d", s.User, s.Host, s.Port)
+	if s.Password != "" || s.PrivateKey != "" {
+		sum := sha256.Sum256([]byte(s.Password + "\n" + s.PrivateKey + "\n" + s.Passphrase))
+		key += "#" + hex.EncodeToString(sum[:8])
+	}
+	if s.Jump != nil {
+		key += " via " + s.Jump.poolKey()
+	}
+	return key
 }
 
-func (s *ServerConfig) String() (key string) {
-	return fmt.Sprintf("%s:%s@%s:%d", s.User, s.Password, s.Host, s.Port)
+// Connection 连接池中的连接状态
+type Connection struct {
+	User        string    `json:"user"`
+	Host        string    `json:"host"`
+	Port        int       `json:"port"`
+	Jump        string    `json:"jump"`
+	Sessions    int       `json:"sessions"`
+	Tunnels     int       `json:"tunnels"` //作为跳板机转发的连接数
+	MaxSessions int       `json:"max_sessions"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+}
+
+// Connections 连接池中所有的连接
+func (s *Ssh) Connections() []Connection {
+	s.mux.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mux.Unlock()
+	res := make([]Connection, 0, len(clients))
+	for _, c := range clients {
+		res = append(res, c.info())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res
 }
 
 func (s *Ssh) NewClient(conf ServerConfig) (*ssh.Client, error) {
-	sc, err := newSshClient(s, &conf)
+	sc, err := s.getClient(context.Background(), conf)
 	if err != nil {
 		return nil, err
 	}
 	return sc.client, nil
 }
 
-func (s *Ssh) newClient(conf ServerConfig) (sc *client, err error) {
+// acquire 从连接池获取连接并占用一个会话，用完后需要release，ctx取消时停止连接和等待
+func (s *Ssh) acquire(ctx context.Context, conf ServerConfig) (sc *client, err error) {
+	for i := 0; i < maxAcquireRetries; i++ {
+		if sc, err = s.getClient(ctx, conf); err != nil {
+			return nil, err
+		}
+		if err = sc.acquire(ctx); err == nil {
+			return sc, nil
+		}
+		if !errors.Is(err, errClientClosed) {
+			return nil, err
+		}
+	}
+	return nil, ErrSSH.New("%s连接已断开", &conf)
+}
+
+// jumpClient 从连接池获取跳板机连接并登记一个转发，目标服务器的连接关闭时unhold
+func (s *Ssh) jumpClient(ctx context.Context, conf ServerConfig) (sc *client, err error) {
+	for i := 0; i < maxAcquireRetries; i++ {
+		if sc, err = s.getClient(ctx, conf); err != nil {
+			return nil, err
+		}
+		if err = sc.hold(); err == nil {
+			return sc, nil
+		}
+	}
+	return nil, ErrSSH.New("%s连接已断开", &conf)
+}
+
+// getClient 获取连接池中可用的连接，没有时新建
+func (s *Ssh) getClient(ctx context.Context, conf ServerConfig) (sc *client, err error) {
+	key := conf.poolKey()
 	s.mux.Lock()
-	defer s.mux.Unlock()
-	key := conf.String()
-	if v, ok := s.clients[key]; ok {
-		return v, nil
+	sc, ok := s.clients[key]
+	s.mux.Unlock()
+	if ok {
+		return sc, nil
+	}
+	//跳板机的连接也放在连接池中，多个目标服务器共用
+	var jump *client
+	if conf.Jump != nil {
+		if jump, err = s.jumpClient(ctx, *conf.Jump); err != nil {
+			return nil, fmt.Errorf("连接跳板机%s失败：%w", conf.Jump, err)
+		}
 	}
-	sc, err = newSshClient(s, &conf)
+	sc, err = newSshClient(ctx, s, key, &conf, jump)
 	if err != nil {
+		if jump != nil {
+			jump.unhold()
+		}
 		return
 	}
-	s.clients[key] = sc
-	return
+	s.mux.Lock()
+	v, ok := s.clients[key]
+	if !ok {
+		s.clients[key] = sc
+	}
+	s.mux.Unlock()
+	//并发创建时使用先创建的连接
+	if ok {
+		sc.close()
+		return v, nil
+	}
+	return sc, nil
 }
 
-func (s *Ssh) removeClient(conf *ServerConfig) {
+func (s *Ssh) removeClient(sc *client) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	key := conf.String()
-	if _, ok := s.clients[key]; ok {
-		delete(s.clients, key)
+	if v, ok := s.clients[sc.key]; ok && v == sc {
+		delete(s.clients, sc.key)
 	}
 }
 
 // NewTerminal 获取会话终端
-func (s *Ssh) NewTerminal(conf ServerConfig, cols, rows int) (sess *Terminal, err error) {
-	sshClient, err := s.newClient(conf)
+func (s *Ssh) NewTerminal(conf ServerConfig, cols, rows int) (*Terminal, error) {
+	sshClient, err := s.acquire(context.Background(), conf)
 	if err != nil {
-		err = ErrSSH.Wrap(err)
-		return
+		return nil, ErrSSH.Wrap(err)
+	}
+	term, err := sshClient.newTerminal(cols, rows)
+	if err != nil {
+		sshClient.release()
+		return nil, err
 	}
-	return sshClient.newTerminal(cols, rows)
+	return term, nil
 }
 
 // RunCmd 直接连接执行命令
-func (s *Ssh) RunCmd(conf ServerConfig, cmd string) (output []byte, err error) {
-	sshClient, err := s.newClient(conf)
+func (s *Ssh) RunCmd(conf ServerConfig, cmd string) ([]byte, error) {
+	sshClient, err := s.acquire(context.Background(), conf)
 	if err != nil {
-		err = ErrSSH.Wrap(err)
-		return
+		return nil, ErrSSH.Wrap(err)
 	}
-	return sshClient.RunCmd(cmd)
+	defer sshClient.release()
+	return sshClient.runCmd(cmd)
 }
 
 func (s *Ssh) NewSftp(conf ServerConfig) (*Sftp, error) {
-	sshClient, err := s.newClient(conf)
+	sshClient, err := s.acquire(context.Background(), conf)
 	if err != nil {
-		err = ErrSSH.Wrap(err)
+		return nil, ErrSSH.Wrap(err)
+	}
+	_sftp, err := sshClient.newSftp()
+	if err != nil {
+		sshClient.release()
 		return nil, err
 	}
-	return sshClient.newSftp()
+	_sftp.limiters = []*RateLimiter{s.serverLimiter(&conf), s.limiter}
+	return _sftp, nil
+}
+
+// serverLimiter 服务器的上传限速，按地址区分，修改服务器限速后下次上传生效
+func (s *Ssh) serverLimiter(conf *ServerConfig) *RateLimiter {
+	key := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	l, ok := s.limiters[key]
+	if !ok {
+		l = NewRateLimiter(0)
+		s.limiters[key] = l
+	}
+	l.SetRate(conf.Bandwidth * 1024)
+	return l
 }
 
 func (s *Ssh) NewRemoteExec(conf ServerConfig) (*RemoteExec, error) {
-	sshClient, err := s.newClient(conf)
+	return s.NewRemoteExecContext(context.Background(), conf)
+}
+
+// NewRemoteExecContext ctx取消时停止连接服务器和等待会话位置
+func (s *Ssh) NewRemoteExecContext(ctx context.Context, conf ServerConfig) (*RemoteExec, error) {
+	sshClient, err := s.acquire(ctx, conf)
 	if err != nil {
-		err = ErrSSH.Wrap(err)
-		return nil, err
+		return nil, ErrSSH.Wrap(err)
 	}
-	return sshClient.newRemoteExec()
+	return sshClient.newRemoteExec(), nil
 }
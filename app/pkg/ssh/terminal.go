@@ -3,6 +3,7 @@ package ssh
 import (
 	"golang.org/x/crypto/ssh"
 	"io"
+	"sync"
 )
 
 // Terminal  模拟终端会话
@@ -12,14 +13,16 @@ type Terminal struct {
 	session *ssh.Session
 	reader  io.Reader
 	writer  io.Writer
+	once    sync.Once
 }
 
-// Close 关闭终端会话
-func (s *Terminal) Close() error {
-	defer func() {
-		s.client.done()
-	}()
-	return s.session.Close()
+// Close 关闭终端会话，释放连接的会话位置
+func (s *Terminal) Close() (err error) {
+	s.once.Do(func() {
+		err = s.session.Close()
+		s.client.release()
+	})
+	return
 }
 
 // Read 返回数据
@@ -0,0 +1,121 @@
+package ssh
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressInterval 上传进度回调的最小间隔
+const progressInterval = time.Second
+
+// Progress sftp上传进度
+type Progress struct {
+	Bytes   int64         `json:"bytes"`
+	Total   int64         `json:"total"`
+	Speed   int64         `json:"speed"` //平均速度，字节/秒
+	Elapsed time.Duration `json:"elapsed"`
+	Done    bool          `json:"done"`
+}
+
+// RateLimiter 按字节数限速，共用同一个限速器的上传平分带宽
+type RateLimiter struct {
+	mux  sync.Mutex
+	rate int64 //字节/秒，小于等于0时不限速
+	next time.Time
+}
+
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate}
+}
+
+// SetRate 修改限速，正在进行的上传立即生效
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.rate = rate
+}
+
+// Wait 预约n个字节的发送时间，需要等待时阻塞到预约的时间
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	l.mux.Lock()
+	if l.rate <= 0 {
+		l.mux.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mux.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// transferReader 读取时限速并回调上传进度
+type transferReader struct {
+	r          io.Reader
+	ctx        context.Context
+	limiters   []*RateLimiter
+	onProgress func(Progress)
+	progress   Progress
+	start      time.Time
+	reported   time.Time
+}
+
+func newTransferReader(ctx context.Context, r io.Reader, total int64, limiters []*RateLimiter, onProgress func(Progress)) *transferReader {
+	now := time.Now()
+	return &transferReader{
+		r:          r,
+		ctx:        ctx,
+		limiters:   limiters,
+		onProgress: onProgress,
+		progress:   Progress{Total: total},
+		start:      now,
+		reported:   now,
+	}
+}
+
+func (t *transferReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		for _, l := range t.limiters {
+			if _err := l.Wait(t.ctx, n); _err != nil {
+				return n, _err
+			}
+		}
+		t.progress.Bytes += int64(n)
+		if time.Since(t.reported) >= progressInterval {
+			t.report(false)
+		}
+	}
+	return n, err
+}
+
+func (t *transferReader) report(done bool) {
+	if t.onProgress == nil {
+		return
+	}
+	t.reported = time.Now()
+	t.progress.Elapsed = t.reported.Sub(t.start)
+	t.progress.Done = done
+	if seconds := t.progress.Elapsed.Seconds(); seconds > 0 {
+		t.progress.Speed = int64(float64(t.progress.Bytes) / seconds)
+	}
+	t.onProgress(t.progress)
+}
@@ -0,0 +1,125 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	//不限速时不等待
+	l := NewRateLimiter(0)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.Wait(ctx, 1024*1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("不限速时不应等待", time.Since(start))
+	}
+
+	//10KB/s发送5KB，第一次不等待，之后每KB等待100ms
+	l.SetRate(10 * 1024)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.Wait(ctx, 1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > 800*time.Millisecond {
+		t.Error("限速错误", elapsed)
+	}
+
+	//共用限速器的上传平分带宽
+	l = NewRateLimiter(10 * 1024)
+	start = time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				_ = l.Wait(ctx, 1024)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Error("共用限速器时应平分带宽", elapsed)
+	}
+
+	//等待时ctx取消立即返回
+	l = NewRateLimiter(1024)
+	_ = l.Wait(ctx, 10*1024)
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := l.Wait(ctx, 1024); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("ctx取消时应返回错误", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("ctx取消后仍在等待", time.Since(start))
+	}
+}
+
+func TestTransferReader(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 10*1024)
+	var reports []Progress
+	tr := newTransferReader(context.Background(), bytes.NewReader(data), int64(len(data)), nil, func(p Progress) {
+		reports = append(reports, p)
+	})
+	buf := make([]byte, 4*1024)
+	if n, err := tr.Read(buf); err != nil || n != len(buf) {
+		t.Fatal(n, err)
+	}
+	if len(reports) != 0 {
+		t.Error("未到回调间隔时不应回调", reports)
+	}
+	//超过回调间隔后读取时回调进度
+	tr.reported = time.Now().Add(-progressInterval)
+	if _, err := tr.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Bytes != 8*1024 || reports[0].Total != int64(len(data)) || reports[0].Done {
+		t.Fatalf("进度错误：%+v", reports)
+	}
+	if _, err := io.Copy(io.Discard, tr); err != nil {
+		t.Fatal(err)
+	}
+	tr.report(true)
+	last := reports[len(reports)-1]
+	if !last.Done || last.Bytes != int64(len(data)) || last.Speed <= 0 || last.Elapsed <= 0 {
+		t.Errorf("完成时的进度错误：%+v", last)
+	}
+
+	//限速器对每次读取生效
+	l := NewRateLimiter(10 * 1024)
+	tr = newTransferReader(context.Background(), bytes.NewReader(data[:3*1024]), 3*1024, []*RateLimiter{NewRateLimiter(0), l}, nil)
+	start := time.Now()
+	for {
+		_, err := tr.Read(buf[:1024])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Error("上传没有限速", elapsed)
+	}
+
+	//ctx取消后停止读取
+	ctx, cancel := context.WithCancel(context.Background())
+	tr = newTransferReader(ctx, bytes.NewReader(data), int64(len(data)), nil, nil)
+	cancel()
+	if _, err := tr.Read(buf); !errors.Is(err, context.Canceled) {
+		t.Error("ctx取消后应停止读取", err)
+	}
+}
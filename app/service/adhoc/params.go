@@ -0,0 +1,34 @@
+package adhoc
+
+import "go-walle/app/pkg/db"
+
+type CreateReq struct {
+	SpaceId     int64   `json:"-" binding:"required,gt=0"`
+	UserId      int64   `json:"-" binding:"required,gt=0"`
+	ServerIds   []int64 `json:"server_ids" binding:"required,min=1,max=500,dive,gt=0"`
+	Command     string  `json:"command" binding:"required,max=2000"`
+	Concurrency int     `json:"concurrency" binding:"omitempty,gte=0,max=50"` //并发数，为0时默认10
+	Timeout     int     `json:"timeout" binding:"omitempty,gte=0,max=3600"`   //每台服务器的超时时间，单位秒，为0时默认60
+}
+
+type ListReq struct {
+	SpaceId int64 `json:"-" binding:"required,gt=0"`
+	db.Paginator
+}
+
+// 控制台消息类型
+const (
+	ConsoleMsgStart  = "start"  //服务器开始执行
+	ConsoleMsgOutput = "output" //执行输出
+	ConsoleMsgResult = "result" //服务器执行结束
+	ConsoleMsgDone   = "done"   //所有服务器执行结束
+)
+
+type ConsoleMsg struct {
+	Type     string `json:"type"`
+	ServerId int64  `json:"server_id,omitempty"`
+	Server   string `json:"server,omitempty"`
+	Data     string `json:"data,omitempty"`
+	Status   int    `json:"status"` //退出码，执行前为-1；done消息为批量执行的状态
+	RunTime  int64  `json:"run_time,omitempty"`
+}
@@ -0,0 +1,127 @@
+package adhoc
+
+import (
+	"context"
+	"fmt"
+	"go-walle/app/model"
+	"go-walle/app/pkg/asciicast"
+	"sync"
+)
+
+const (
+	subscriberQueueSize = 1024      //控制台待发送的消息数，超过时断开控制台
+	maxServerOutput     = 64 * 1024 //每台服务器保留的输出，超过后不再推送和保存
+)
+
+// run 正在执行的批量命令，控制台订阅后先收到已有的消息，再实时收到新消息
+type run struct {
+	model   *model.Adhoc
+	servers []*model.Server
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mux         sync.Mutex
+	history     []*ConsoleMsg
+	subscribers map[chan *ConsoleMsg]struct{}
+	failed      bool
+	done        bool
+}
+
+func newRun(m *model.Adhoc, servers []*model.Server) *run {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &run{
+		model:       m,
+		servers:     servers,
+		ctx:         ctx,
+		cancel:      cancel,
+		subscribers: make(map[chan *ConsoleMsg]struct{}),
+	}
+}
+
+// subscribe 订阅控制台消息，执行结束后channel会被关闭
+func (r *run) subscribe() chan *ConsoleMsg {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	ch := make(chan *ConsoleMsg, len(r.history)+subscriberQueueSize)
+	for _, msg := range r.history {
+		ch <- msg
+	}
+	if r.done {
+		close(ch)
+	} else {
+		r.subscribers[ch] = struct{}{}
+	}
+	return ch
+}
+
+func (r *run) unsubscribe(ch chan *ConsoleMsg) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.subscribers[ch]; ok {
+		delete(r.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish 发送消息给所有订阅者，发送不及时的订阅者会被断开
+func (r *run) publish(msg *ConsoleMsg) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.history = append(r.history, msg)
+	for ch := range r.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+	if msg.Type == ConsoleMsgResult && msg.Status != 0 {
+		r.failed = true
+	}
+	if msg.Type == ConsoleMsgDone {
+		r.done = true
+		for ch := range r.subscribers {
+			delete(r.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// serverOutput 把服务器的输出转为控制台消息，不完整的utf8字符留到下次发送，
+// 输出超过maxServerOutput后只提示一次，之后的输出丢弃
+type serverOutput struct {
+	run       *run
+	server    *model.Server
+	pending   []byte
+	output    []byte
+	truncated bool
+}
+
+func (o *serverOutput) Write(p []byte) (int, error) {
+	if o.truncated {
+		return len(p), nil
+	}
+	data := append(o.pending, p...)
+	n := asciicast.CompleteRunes(data)
+	o.pending = append([]byte{}, data[n:]...)
+	if left := maxServerOutput - len(o.output); n > left {
+		n = asciicast.CompleteRunes(data[:left])
+		o.truncated, o.pending = true, nil
+	}
+	if n > 0 {
+		o.output = append(o.output, data[:n]...)
+		o.run.publish(&ConsoleMsg{Type: ConsoleMsgOutput, ServerId: o.server.ID, Server: o.server.Name, Data: string(data[:n])})
+	}
+	if o.truncated {
+		notice := fmt.Sprintf("\n...输出超过%dKB，之后的输出已省略\n", maxServerOutput/1024)
+		o.output = append(o.output, notice...)
+		o.run.publish(&ConsoleMsg{Type: ConsoleMsgOutput, ServerId: o.server.ID, Server: o.server.Name, Data: notice})
+	}
+	return len(p), nil
+}
+
+// String 保存到执行记录的输出
+func (o *serverOutput) String() string {
+	return string(o.output)
+}
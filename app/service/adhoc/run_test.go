@@ -0,0 +1,71 @@
+package adhoc
+
+import (
+	"go-walle/app/model"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRunSubscribe(t *testing.T) {
+	r := newRun(&model.Adhoc{ID: 1}, nil)
+	server := &model.Server{ID: 2, Name: "web"}
+	r.publish(&ConsoleMsg{Type: ConsoleMsgStart, ServerId: server.ID, Status: -1})
+
+	//中途订阅先收到已有的消息
+	ch := r.subscribe()
+	out := &serverOutput{run: r, server: server}
+	data := []byte("你好")
+	_, _ = out.Write(data[:4])
+	_, _ = out.Write(data[4:])
+	r.publish(&ConsoleMsg{Type: ConsoleMsgResult, ServerId: server.ID, Status: 1})
+	r.publish(&ConsoleMsg{Type: ConsoleMsgDone})
+
+	var types, output []string
+	for msg := range ch {
+		types = append(types, msg.Type)
+		if msg.Type == ConsoleMsgOutput {
+			output = append(output, msg.Data)
+		}
+	}
+	if strings.Join(types, ",") != "start,output,output,result,done" {
+		t.Error("消息顺序错误", types)
+	}
+	if strings.Join(output, "|") != "你|好" {
+		t.Error("utf8字符被截断", output)
+	}
+	if !r.failed {
+		t.Error("退出码不为0时没有标记失败")
+	}
+	//结束后订阅收到全部消息
+	n := 0
+	for range r.subscribe() {
+		n++
+	}
+	if n != 5 {
+		t.Error("结束后订阅的消息数错误", n)
+	}
+}
+
+func TestServerOutputTruncate(t *testing.T) {
+	r := newRun(&model.Adhoc{ID: 1}, nil)
+	out := &serverOutput{run: r, server: &model.Server{ID: 2}}
+	line := []byte(strings.Repeat("输出", 100) + "\n")
+	for i := 0; i < 2*maxServerOutput/len(line); i++ {
+		_, _ = out.Write(line)
+	}
+	saved := out.String()
+	if len(saved) > maxServerOutput+100 || !strings.Contains(saved, "之后的输出已省略") {
+		t.Fatalf("输出应被截断：%d", len(saved))
+	}
+	if !utf8.ValidString(saved) {
+		t.Error("截断位置不能拆分utf8字符")
+	}
+	var pushed int
+	for _, msg := range r.history {
+		pushed += len(msg.Data)
+	}
+	if pushed != len(saved) || strings.Count(saved, "之后的输出已省略") != 1 {
+		t.Error("推送的输出应与保存的一致", pushed, len(saved))
+	}
+}
@@ -0,0 +1,335 @@
+package adhoc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/pkg/ssh"
+	"go-walle/app/service/common"
+	"go-walle/app/service/server"
+	"go.uber.org/zap"
+	ssh2 "golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	service     *Service
+	onceService sync.Once
+)
+
+const (
+	defaultConcurrency = 10
+	defaultTimeout     = 60
+	killAfter          = 5 //停止或超时后等待命令退出的秒数，之后强制结束
+)
+
+// Service 在空间内的多台服务器上并行执行临时命令，输出实时推送到控制台，结果保存为执行记录
+type Service struct {
+	log    *zap.Logger
+	db     *gorm.DB
+	ssh    *ssh.Ssh
+	server *server.Service
+
+	mux  sync.Mutex
+	runs map[int64]*run
+}
+
+func NewService(log *zap.Logger, db *gorm.DB, ssh *ssh.Ssh, server *server.Service) *Service {
+	onceService.Do(func() {
+		service = &Service{
+			log:    log.Named("adhoc"),
+			db:     db,
+			ssh:    ssh,
+			server: server,
+			runs:   make(map[int64]*run),
+		}
+		service.stopInterrupted()
+	})
+	return service
+}
+
+// stopInterrupted 程序重启前没有执行完的批量执行已经中断，标记为停止
+func (srv *Service) stopInterrupted() {
+	err := srv.db.Model(&model.Adhoc{}).Where("status = ?", model.AdhocStatusRunning).
+		Updates(map[string]any{"status": model.AdhocStatusStopped, "finished_at": time.Now()}).Error
+	if err != nil {
+		srv.log.Error("更新中断的批量执行失败", zap.Error(err))
+	}
+}
+
+func (srv *Service) List(params *ListReq) (total int64, list []*model.Adhoc, err error) {
+	_db := srv.db.Model(&model.Adhoc{}).Where("space_id = ?", params.SpaceId)
+	if err = _db.Count(&total).Error; err != nil || total == 0 {
+		return
+	}
+	err = _db.Scopes(params.PageQuery()).Preload("User").Order("id desc").Find(&list).Error
+	return
+}
+
+// Detail 批量执行详情，包含每台服务器的执行记录
+func (srv *Service) Detail(spaceWithId *common.SpaceWithId) (*model.Adhoc, error) {
+	m := &model.Adhoc{}
+	err := srv.db.Where("space_id = ? and id = ?", spaceWithId.SpaceId, spaceWithId.ID).
+		Preload("User").
+		Preload("Records", func(db *gorm.DB) *gorm.DB { return db.Order("id asc") }).
+		Preload("Records.Server").
+		First(m).Error
+	return m, err
+}
+
+// Create 校验命令策略后开始执行，执行过程通过Console查看
+func (srv *Service) Create(params *CreateReq) (*model.Adhoc, error) {
+	ids := make([]int64, 0, len(params.ServerIds))
+	exists := make(map[int64]bool)
+	for _, id := range params.ServerIds {
+		if !exists[id] {
+			exists[id] = true
+			ids = append(ids, id)
+		}
+	}
+	servers := make([]*model.Server, 0)
+	if err := srv.db.Where("space_id = ? and id in ?", params.SpaceId, ids).Order("id asc").Find(&servers).Error; err != nil {
+		return nil, err
+	}
+	if len(servers) != len(ids) {
+		return nil, errcode.ErrInvalidParams.New("服务器不存在")
+	}
+	if err := srv.server.CheckCommand(params.SpaceId, params.UserId, params.Command); err != nil {
+		return nil, err
+	}
+	m := &model.Adhoc{
+		SpaceId:     params.SpaceId,
+		UserId:      params.UserId,
+		Command:     params.Command,
+		ServerIds:   ids,
+		Concurrency: params.Concurrency,
+		Timeout:     params.Timeout,
+		Status:      model.AdhocStatusRunning,
+	}
+	if m.Concurrency == 0 {
+		m.Concurrency = defaultConcurrency
+	}
+	if m.Timeout == 0 {
+		m.Timeout = defaultTimeout
+	}
+	if err := srv.db.Omit(clause.Associations).Create(m).Error; err != nil {
+		return nil, err
+	}
+	r := newRun(m, servers)
+	srv.mux.Lock()
+	srv.runs[m.ID] = r
+	srv.mux.Unlock()
+	go srv.run(r)
+	return m, nil
+}
+
+// Stop 停止执行，正在执行的命令会被中断，未开始的服务器不再执行
+func (srv *Service) Stop(spaceWithId *common.SpaceWithId) error {
+	r, ok := srv.getRun(spaceWithId)
+	if !ok {
+		return errcode.ErrInvalidParams.New("已经执行结束")
+	}
+	r.cancel()
+	return nil
+}
+
+// Console 推送执行过程，已经结束的推送保存的执行记录
+func (srv *Service) Console(wsConn *websocket.Conn, spaceWithId *common.SpaceWithId) error {
+	send := func(msg *ConsoleMsg) error {
+		str, _ := json.Marshal(msg)
+		return wsConn.WriteMessage(websocket.TextMessage, str)
+	}
+	r, ok := srv.getRun(spaceWithId)
+	if !ok {
+		m, err := srv.Detail(spaceWithId)
+		if err != nil {
+			return err
+		}
+		for _, record := range m.Records {
+			for _, msg := range recordMsgs(record) {
+				if err = send(msg); err != nil {
+					return err
+				}
+			}
+		}
+		return send(&ConsoleMsg{Type: ConsoleMsgDone, Status: m.Status})
+	}
+
+	ch := r.subscribe()
+	defer r.unsubscribe(ch)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//客户端断开时结束推送
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := wsConn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (srv *Service) getRun(spaceWithId *common.SpaceWithId) (*run, bool) {
+	srv.mux.Lock()
+	defer srv.mux.Unlock()
+	r, ok := srv.runs[spaceWithId.ID]
+	if !ok || r.model.SpaceId != spaceWithId.SpaceId {
+		return nil, false
+	}
+	return r, true
+}
+
+// run 按并发数在所有服务器上执行
+func (srv *Service) run(r *run) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, r.model.Concurrency)
+	for _, s := range r.servers {
+		select {
+		case sem <- struct{}{}:
+		case <-r.ctx.Done():
+			//已经停止，只记录未执行
+			srv.exec(r, s)
+			continue
+		}
+		wg.Add(1)
+		go func(s *model.Server) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			srv.exec(r, s)
+		}(s)
+	}
+	wg.Wait()
+	srv.finish(r)
+}
+
+// exec 在一台服务器上执行，已经停止时不再执行
+func (srv *Service) exec(r *run, s *model.Server) {
+	record := &model.Record{
+		Type:     model.RecordTypeAdhoc,
+		UserId:   r.model.UserId,
+		ServerId: s.ID,
+		AdhocId:  r.model.ID,
+		Status:   -1,
+		Command:  r.model.Command,
+	}
+	startT := time.Now()
+	if r.ctx.Err() != nil {
+		record.Output = "已停止，未执行"
+	} else {
+		r.publish(&ConsoleMsg{Type: ConsoleMsgStart, ServerId: s.ID, Server: s.Name, Status: -1})
+		ctx, cancel := context.WithTimeout(r.ctx, time.Duration(r.model.Timeout)*time.Second)
+		output := &serverOutput{run: r, server: s}
+		err := srv.execute(ctx, s, remoteCommand(r.model.Command, r.model.Timeout), output)
+		cancel()
+		record.Output, record.Status = output.String(), exitStatus(err)
+		if err != nil {
+			msg := err.Error()
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				msg = fmt.Sprintf("执行超时(%ds)", r.model.Timeout)
+			case context.Canceled:
+				msg = "已停止"
+			}
+			if _, ok := err.(*ssh2.ExitError); !ok || ctx.Err() != nil {
+				record.Output += "\n" + msg
+				r.publish(&ConsoleMsg{Type: ConsoleMsgOutput, ServerId: s.ID, Server: s.Name, Data: "\n" + msg})
+			}
+		}
+	}
+	record.RunTime = time.Since(startT).Milliseconds()
+	if err := srv.db.Omit(clause.Associations).Create(record).Error; err != nil {
+		srv.log.Error("保存执行记录失败", zap.Int64("adhoc_id", r.model.ID), zap.Int64("server_id", s.ID), zap.Error(err))
+	}
+	r.publish(&ConsoleMsg{Type: ConsoleMsgResult, ServerId: s.ID, Server: s.Name, Status: record.Status, RunTime: record.RunTime})
+}
+
+func (srv *Service) execute(ctx context.Context, s *model.Server, cmd string, output *serverOutput) error {
+	sshConfig, err := server.SshConfig(srv.db, s)
+	if err != nil {
+		return err
+	}
+	command, err := srv.ssh.NewRemoteExecContext(ctx, sshConfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = command.Close()
+	}()
+	command.WithCtx(ctx)
+	_, err = command.WithOutput(output).Run(cmd)
+	return err
+}
+
+// remoteCommand 使用timeout执行命令，停止时timeout把信号转发给整个进程组，
+// ssh服务器不支持信号时也会在超时后结束命令，没有timeout命令时直接执行
+func remoteCommand(cmd string, timeout int) string {
+	quoted := "'" + strings.ReplaceAll(cmd, "'", `'\''`) + "'"
+	return fmt.Sprintf(`if command -v timeout >/dev/null 2>&1; then exec timeout -k %d %d "${SHELL:-sh}" -c %s; else exec "${SHELL:-sh}" -c %s; fi`,
+		killAfter, timeout+killAfter, quoted, quoted)
+}
+
+// finish 保存执行结果，通知控制台结束
+func (srv *Service) finish(r *run) {
+	r.mux.Lock()
+	status := model.AdhocStatusSuccess
+	if r.ctx.Err() != nil {
+		status = model.AdhocStatusStopped
+	} else if r.failed {
+		status = model.AdhocStatusFailed
+	}
+	r.mux.Unlock()
+	r.cancel()
+	finishedAt := time.Now()
+	r.model.Status, r.model.FinishedAt = status, &finishedAt
+	if err := srv.db.Model(r.model).Select("status", "finished_at").Updates(r.model).Error; err != nil {
+		srv.log.Error("保存批量执行结果失败", zap.Int64("adhoc_id", r.model.ID), zap.Error(err))
+	}
+	r.publish(&ConsoleMsg{Type: ConsoleMsgDone, Status: status})
+	srv.mux.Lock()
+	delete(srv.runs, r.model.ID)
+	srv.mux.Unlock()
+}
+
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ssh2.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	return 255
+}
+
+// recordMsgs 把保存的执行记录转为控制台消息
+func recordMsgs(record *model.Record) []*ConsoleMsg {
+	msgs := []*ConsoleMsg{{Type: ConsoleMsgStart, ServerId: record.ServerId, Server: record.Server.Name, Status: -1}}
+	if record.Output != "" {
+		msgs = append(msgs, &ConsoleMsg{Type: ConsoleMsgOutput, ServerId: record.ServerId, Server: record.Server.Name, Data: record.Output})
+	}
+	return append(msgs, &ConsoleMsg{Type: ConsoleMsgResult, ServerId: record.ServerId, Server: record.Server.Name, Status: record.Status, RunTime: record.RunTime})
+}
@@ -0,0 +1,64 @@
+package adhoc
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRemoteCommand(t *testing.T) {
+	if _, err := exec.LookPath("timeout"); err != nil {
+		t.Skip("没有timeout命令")
+	}
+	out, err := exec.Command("sh", "-c", remoteCommand(`echo 'a b' "it's"`, 10)).CombinedOutput()
+	if err != nil || string(out) != "a b it's\n" {
+		t.Errorf("命令执行错误：%q %v", out, err)
+	}
+
+	//停止时后台的子进程也要结束
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cmd := exec.Command("sh", "-c", remoteCommand("sleep 30 & echo $! > "+pidFile+"; wait", 60))
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var pid int
+	for i := 0; i < 100 && pid == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		b, _ := os.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if pid == 0 {
+		t.Fatal("命令没有执行")
+	}
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("收到信号后命令没有结束")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if alive(pid) {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+		t.Error("子进程没有结束")
+	}
+}
+
+// alive 进程是否还在运行，已退出等待回收的进程视为结束
+func alive(pid int) bool {
+	b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return syscall.Kill(pid, 0) == nil
+	}
+	fields := strings.Fields(string(b[strings.LastIndexByte(string(b), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
@@ -0,0 +1,11 @@
+package audit
+
+import "go-walle/app/pkg/db"
+
+type ListReq struct {
+	SpaceId  int64  `json:"-" binding:"required,gt=0"`
+	Action   string `form:"action" json:"action" binding:"omitempty,max=50"`
+	UserId   int64  `form:"user_id" json:"user_id" binding:"omitempty,gt=0"`
+	ServerId int64  `form:"server_id" json:"server_id" binding:"omitempty,gt=0"`
+	db.Paginator
+}
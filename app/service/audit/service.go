@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"go-walle/app/model"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+)
+
+var (
+	service     *Service
+	onceService sync.Once
+)
+
+// Service 审计日志
+type Service struct {
+	log *zap.Logger
+	db  *gorm.DB
+}
+
+func NewService(log *zap.Logger, db *gorm.DB) *Service {
+	onceService.Do(func() {
+		service = &Service{log: log.Named("audit"), db: db}
+	})
+	return service
+}
+
+// Log 记录审计日志，写入失败时只记录到日志文件，不影响正在进行的操作
+func (srv *Service) Log(m *model.AuditLog) {
+	fields := []zap.Field{
+		zap.Int64("space_id", m.SpaceId),
+		zap.Int64("user_id", m.UserId),
+		zap.String("action", m.Action),
+		zap.Int64("server_id", m.ServerId),
+		zap.String("target", m.Target),
+		zap.String("detail", m.Detail),
+	}
+	if err := srv.db.Omit(clause.Associations).Create(m).Error; err != nil {
+		srv.log.Error("写入审计日志失败", append(fields, zap.Error(err))...)
+		return
+	}
+	srv.log.Info("审计", fields...)
+}
+
+func (srv *Service) List(params *ListReq) (total int64, list []*model.AuditLog, err error) {
+	_db := srv.db.Model(&model.AuditLog{}).Where("space_id = ?", params.SpaceId)
+	if params.Action != "" {
+		_db = _db.Where("action = ?", params.Action)
+	}
+	if params.UserId > 0 {
+		_db = _db.Where("user_id = ?", params.UserId)
+	}
+	if params.ServerId > 0 {
+		_db = _db.Where("server_id = ?", params.ServerId)
+	}
+	if err = _db.Count(&total).Error; err != nil || total == 0 {
+		return
+	}
+	err = _db.Scopes(params.PageQuery()).Preload("User").Order("id desc").Find(&list).Error
+	return
+}
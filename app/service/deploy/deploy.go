@@ -6,17 +6,21 @@ import (
 	"errors"
 	"fmt"
 	"github.com/wuzfei/go-helper/compress"
-	"github.com/wuzfei/go-helper/files"
 	"github.com/wuzfei/go-helper/slices"
 	"github.com/zeebo/errs"
 	"go-walle/app/global"
 	"go-walle/app/model"
+	"go-walle/app/pkg/artifact"
 	"go-walle/app/pkg/repo"
 	"go-walle/app/pkg/ssh"
+	"go-walle/app/service/environment"
+	"go-walle/app/service/notice"
+	server2 "go-walle/app/service/server"
 	"go.uber.org/zap"
 	"os"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -63,7 +67,7 @@ func init() {
 }
 
 type deployDirs struct {
-	localWarehouseDir, localCodePackage, remoteReleaseDir, remoteReleasePackage, remoteRootLink string
+	localWarehouseDir, localArtifactDir, localCodePackage, remoteReleaseDir, remoteReleasePackage, remoteRootLink string
 }
 
 type Task struct {
@@ -84,6 +88,10 @@ type Task struct {
 	deployPackage string
 	targetRoot    string
 	targetRelease string
+
+	//上传程序包的进度，每次更新时版本号加1
+	progress        map[int64]*TransferProgress
+	progressVersion int
 }
 
 func NewTask(model *model.Task, userId int64) *Task {
@@ -134,13 +142,17 @@ func (t *Task) Start() (err error) {
 	t.mux.Unlock()
 
 	//更新发布状态和版本
+	startedAt := time.Now()
 	t.model.Status = model.TaskStatusRelease
 	t.model.Version = t.createReleaseVersion()
-	err = global.DB.Select("status", "link_id").UpdateColumns(t.model).Error
+	t.model.StartedAt = &startedAt
+	err = global.DB.Select("status", "version", "started_at").UpdateColumns(t.model).Error
 	if err != nil {
 		return
 	}
 
+	t.notify(notice.EventReleaseStart)
+
 	//启动发布协程
 	t.ctx, t.cancel = context.WithCancel(context.Background())
 	t.isStop = make(chan struct{}, 1)
@@ -196,6 +208,8 @@ func (t *Task) stop() {
 	t.stopped = true
 	close(t.isStop)
 	t.mux.Unlock()
+	finishedAt := time.Now()
+	t.model.FinishedAt = &finishedAt
 	t.model.Status = model.TaskStatusFinish
 	if doneErr != nil {
 		t.model.LastError = doneErr.Error()
@@ -208,16 +222,22 @@ func (t *Task) stop() {
 	}
 	mb, _ := json.Marshal(t.model)
 
-	if t.deployDirs.localCodePackage != "" {
+	if t.deployDirs != nil && t.deployDirs.localCodePackage != "" {
 		_ = os.RemoveAll(t.deployDirs.localCodePackage)
 		_ = os.RemoveAll(t.deployDirs.localWarehouseDir)
+		_ = os.RemoveAll(t.deployDirs.localArtifactDir)
 	}
 
-	if err := global.DB.Model(t.model).Select("status", "last_error").UpdateColumns(t.model).Error; err != nil {
+	if err := global.DB.Model(t.model).Select("status", "last_error", "finished_at").UpdateColumns(t.model).Error; err != nil {
 		global.Log.Error("部署完成，更新数据库时出错", zap.ByteString("task_model", mb), zap.Error(doneErr), zap.Error(err))
 	} else {
 		global.Log.Debug("部署完成", zap.ByteString("task_model", mb))
 	}
+	if t.model.Status == model.TaskStatusFinish {
+		t.notify(notice.EventReleaseSuccess)
+	} else {
+		t.notify(notice.EventReleaseFail)
+	}
 }
 
 func (t *Task) remoteRelease() error {
@@ -260,6 +280,7 @@ func (t *Task) prevDeploy() error {
 	packageName := t.model.Version + ".tar.gz"
 	t.deployDirs = &deployDirs{
 		localWarehouseDir:    filepath.Join(localDeployDir, t.model.Version),
+		localArtifactDir:     filepath.Join(localDeployDir, t.model.Version+"-artifact"),
 		localCodePackage:     filepath.Join(localDeployDir, packageName),
 		remoteReleaseDir:     filepath.Join(t.model.Project.TargetReleases, t.model.Version),
 		remoteReleasePackage: filepath.Join(t.model.Project.TargetReleases, packageName),
@@ -283,21 +304,125 @@ func (t *Task) deploy() error {
 	if err != nil {
 		return errors.New("获取代码仓库错误：" + err.Error())
 	}
+	//每个上线单导出到独立的目录，以便下面执行编译等操作
+	dest := t.deployDirs.localWarehouseDir
+	//构建产物直接解压，不需要检出代码
+	if artifact, ok := _repo.(*repo.Artifact); ok {
+		if err = t.extractArtifact(artifact, dest); err != nil {
+			return err
+		}
+		return t.verifySignature(_repo, "")
+	}
+	var commit string
 	if t.model.Tag != "" {
-		err = _repo.CheckoutToTag(t.model.Tag)
+		commit, err = _repo.CheckoutToTag(t.model.Tag, dest)
 	} else if t.model.Branch != "" && t.model.CommitId != "" {
-		err = _repo.CheckoutToCommit(t.model.Branch, t.model.CommitId)
+		commit, err = _repo.CheckoutToCommit(t.model.Branch, t.model.CommitId, dest)
 	} else {
 		err = errors.New("发布分支选取错误")
 	}
 	if err != nil {
+		return errors.New("检出代码失败：" + err.Error())
+	}
+	if err = t.verifySignature(_repo, commit); err != nil {
 		return err
 	}
-	//2、复制发布版本代码到新目录，以便下面执行编译等操作
-	if _, err = files.CopyDirToDir(t.deployDirs.localWarehouseDir, _repo.Path()); err != nil {
-		return errors.New("检出代码失败：" + err.Error())
+	t.recordSubmodules(_repo)
+	return nil
+}
+
+// verifySignature 环境开启了签名策略时，只发布受信任密钥签名的tag或者commit，
+// commit为实际检出的版本，签名必须对应这个版本，避免校验时tag或者分支已经变化
+func (t *Task) verifySignature(_repo repo.Repo, commit string) error {
+	if t.model.Environment.SignPolicy != model.SignPolicyTrusted {
+		return nil
 	}
-	return err
+	target := "commit " + commit
+	if t.model.Tag != "" {
+		target = "tag " + t.model.Tag + " (" + commit + ")"
+	}
+	st := time.Now()
+	record := NewRecord(model.RecordTypeDeploy, t.model.ID, t.userId, "verify signature of "+target, nil, nil)
+	fail := func(err error) error {
+		_err := "签名校验失败:" + err.Error()
+		_ = record.Save(255, &_err, time.Since(st).Milliseconds())
+		return errors.New(t.model.Environment.Name + "环境只允许发布受信任密钥签名的版本，" + _err)
+	}
+	r, ok := _repo.(repo.SignatureRepo)
+	if !ok {
+		return fail(fmt.Errorf("%s仓库不支持签名校验", _repo.Type()))
+	}
+	keys, err := environment.NewService(global.DB).TrustedKeys(t.model.SpaceId)
+	if err != nil {
+		return fail(err)
+	}
+	if len(keys) == 0 {
+		return fail(errors.New("空间内没有受信任的签名公钥"))
+	}
+	if commit == "" {
+		return fail(errors.New("无法确定检出的版本"))
+	}
+	var sign *repo.Signature
+	if t.model.Tag != "" {
+		sign, err = r.VerifyTag(t.model.Tag, keys)
+	} else {
+		sign, err = r.VerifyCommit(commit, keys)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if sign.Commit != commit {
+		return fail(fmt.Errorf("签名对应的版本%s与检出的版本%s不一致", sign.Commit, commit))
+	}
+	output := fmt.Sprintf("%s %s signed by %s (%s %s)", sign.Object, sign.Hash, sign.KeyName, sign.Type, sign.Fingerprint)
+	_ = record.Save(0, &output, time.Since(st).Milliseconds())
+	return nil
+}
+
+// extractArtifact 下载或者读取上传的构建产物，校验后解压到dest目录
+func (t *Task) extractArtifact(artifact *repo.Artifact, dest string) error {
+	source := t.model.ArtifactUrl
+	if source == "" {
+		source = artifact.Url()
+	}
+	st := time.Now()
+	record := NewRecord(model.RecordTypeDeploy, t.model.ID, t.userId, "extract artifact "+source, nil, nil)
+	if err := artifact.Extract(source, t.model.ArtifactChecksum, dest); err != nil {
+		_err := "解压构建产物出错:" + err.Error()
+		_ = record.Save(255, &_err, time.Since(st).Milliseconds())
+		return errors.New("获取构建产物失败：" + err.Error())
+	}
+	output := "success"
+	if t.model.ArtifactChecksum != "" {
+		output = "sha256 " + t.model.ArtifactChecksum + " verified"
+	}
+	_ = record.Save(0, &output, time.Since(st).Milliseconds())
+	return nil
+}
+
+// recordSubmodules 记录本次检出的子模块版本
+func (t *Task) recordSubmodules(_repo repo.Repo) {
+	if !t.model.Project.RepoOptions().Submodules {
+		return
+	}
+	r, ok := _repo.(repo.SubmoduleRepo)
+	if !ok {
+		return
+	}
+	st := time.Now()
+	record := NewRecord(model.RecordTypeDeploy, t.model.ID, t.userId, "git submodule status --recursive", nil, nil)
+	subs, err := r.Submodules()
+	if err != nil {
+		_err := "获取子模块版本出错:" + err.Error()
+		_ = record.Save(255, &_err, time.Since(st).Milliseconds())
+		return
+	}
+	lines := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		lines = append(lines, sub.Hash+" "+sub.Path)
+	}
+	output := strings.Join(lines, "\n")
+	_ = record.Save(0, &output, time.Since(st).Milliseconds())
 }
 
 // postDeploy step3.推送到服务器前的操作，比如下载依赖，编译等
@@ -311,11 +436,16 @@ func (t *Task) postDeploy() error {
 			return err
 		}
 	}
-	//2、打包代码
+	//2、设置了构建产物时只打包产物
+	packDir, err := t.collectArtifacts()
+	if err != nil {
+		return err
+	}
+	//3、打包代码
 	st := time.Now()
-	cmd := fmt.Sprintf("tar -zcvf %s -C %s", t.deployDirs.localCodePackage, t.deployDirs.localWarehouseDir)
+	cmd := fmt.Sprintf("tar -zcvf %s -C %s", t.deployDirs.localCodePackage, packDir)
 	record := NewRecord(model.RecordTypePostDeploy, t.model.ID, t.userId, cmd, nil, nil)
-	err := compress.PackMatch(t.deployDirs.localCodePackage, t.deployDirs.localWarehouseDir, t.getFileMatch())
+	err = compress.PackMatch(t.deployDirs.localCodePackage, packDir, t.getFileMatch())
 	if err != nil {
 		_err := "打包代码出错:" + err.Error()
 		_ = record.Save(255, &_err, time.Since(st).Milliseconds())
@@ -326,6 +456,31 @@ func (t *Task) postDeploy() error {
 	return err
 }
 
+// collectArtifacts 将构建产物复制到单独的目录，返回需要打包的目录，未设置产物时打包整个检出目录
+func (t *Task) collectArtifacts() (string, error) {
+	paths, err := artifact.ParsePaths(t.model.Project.ArtifactPaths)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return t.deployDirs.localWarehouseDir, nil
+	}
+	st := time.Now()
+	cmd := "collect artifacts:"
+	for _, item := range paths {
+		cmd += fmt.Sprintf(" %s=>%s", item.Src, item.Dest)
+	}
+	record := NewRecord(model.RecordTypePostDeploy, t.model.ID, t.userId, cmd, nil, nil)
+	if err = artifact.Collect(t.deployDirs.localWarehouseDir, t.deployDirs.localArtifactDir, paths); err != nil {
+		_err := "收集构建产物出错:" + err.Error()
+		_ = record.Save(255, &_err, time.Since(st).Milliseconds())
+		return "", err
+	}
+	_err := "success"
+	_ = record.Save(0, &_err, time.Since(st).Milliseconds())
+	return t.deployDirs.localArtifactDir, nil
+}
+
 // prevRelease step4.推送代码到服务器前的操作
 func (t *Task) prevRelease(server *model.Server) error {
 	//解压程序包
@@ -338,9 +493,16 @@ func (t *Task) prevRelease(server *model.Server) error {
 	st := time.Now()
 	_saveCmd := fmt.Sprintf("scp -P%d %s@%s:%s %s:%s", server.Port, currentUser.Username, currentHost, t.deployDirs.localCodePackage, server.Hostname(), t.deployDirs.remoteReleasePackage)
 	record := NewRecord(model.RecordTypePrevRelease, t.model.ID, t.userId, _saveCmd, server, nil)
-	sftp, err := global.Ssh.NewSftp(ssh.ServerConfig{Host: server.Host, User: server.User, Port: server.Port})
+	var sftp *ssh.Sftp
+	sshConfig, err := server2.SshConfig(global.DB, server)
 	if err == nil {
-		err = sftp.Copy(t.deployDirs.localCodePackage, t.deployDirs.remoteReleasePackage)
+		sftp, err = global.Ssh.NewSftp(sshConfig)
+	}
+	if err == nil {
+		err = sftp.WithCtx(t.ctx).WithProgress(func(p ssh.Progress) {
+			t.setProgress(server, p)
+		}).Copy(t.deployDirs.localCodePackage, t.deployDirs.remoteReleasePackage)
+		_ = sftp.Close()
 	}
 	if err != nil {
 		_err := "上传程序出错:" + err.Error()
@@ -368,6 +530,32 @@ func (t *Task) prevRelease(server *model.Server) error {
 	return nil
 }
 
+// setProgress 记录服务器的上传进度
+func (t *Task) setProgress(server *model.Server, p ssh.Progress) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.progress == nil {
+		t.progress = make(map[int64]*TransferProgress)
+	}
+	t.progress[server.ID] = &TransferProgress{ServerId: server.ID, Server: server.Hostname(), Progress: p}
+	t.progressVersion++
+}
+
+// Progress 所有服务器的上传进度，版本号不变时进度没有变化
+func (t *Task) Progress() (int, []*TransferProgress) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	res := make([]*TransferProgress, 0, len(t.progress))
+	for _, p := range t.progress {
+		_p := *p
+		res = append(res, &_p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ServerId < res[j].ServerId
+	})
+	return t.progressVersion, res
+}
+
 // release step5.部署程序
 func (t *Task) release(server *model.Server) error {
 	//1、获取上一个部署版本，保存下来
@@ -429,8 +617,13 @@ func (t *Task) createReleaseVersion() string {
 	return fmt.Sprintf("%d_%d_%s", t.model.Project.ID, t.model.ID, time.Now().Format("20060102_150405"))
 }
 
+// notify 发送上线单事件通知
+func (t *Task) notify(event notice.Event) {
+	notice.NewService(global.Log, global.DB, &global.Cfg.Notice).Notify(event, t.model.ID, t.userId)
+}
+
 func (t *Task) getRepo() (repo.Repo, error) {
-	return global.Repo.New(repo.TypeRepo(t.model.Project.RepoType), t.model.Project.RepoUrl, fmt.Sprintf("%d", t.model.Project.ID))
+	return global.Repo.New(repo.TypeRepo(t.model.Project.RepoType), t.model.Project.RepoUrl, fmt.Sprintf("%d", t.model.Project.ID), t.model.Project.RepoOptions())
 }
 
 func (t *Task) getFileMatch() compress.Match {
@@ -3,6 +3,7 @@ package deploy
 import (
 	"go-walle/app/model"
 	"go-walle/app/pkg/db"
+	"go-walle/app/pkg/ssh"
 )
 
 type CreateReq struct {
@@ -15,6 +16,19 @@ type CreateReq struct {
 	CommitId    string  `json:"commit_id" binding:"omitempty,max=50"`
 	Description string  `json:"description" binding:"omitempty,max=500"`
 	ServerIds   []int64 `json:"server_ids" binding:"omitempty"`
+
+	ArtifactUrl      string `json:"artifact_url" binding:"omitempty,max=500"`
+	ArtifactChecksum string `json:"artifact_checksum" binding:"omitempty,max=100"`
+}
+
+type UploadArtifactReq struct {
+	SpaceId   int64 `form:"-" binding:"required,gt=0"`
+	ProjectId int64 `form:"project_id" binding:"required,gt=0"`
+}
+
+type UploadArtifactRes struct {
+	ArtifactUrl      string `json:"artifact_url"`
+	ArtifactChecksum string `json:"artifact_checksum"`
 }
 
 type ListReq struct {
@@ -32,11 +46,20 @@ type AuditReq struct {
 const TaskConsoleMsgRecords = "records"
 const TaskConsoleMsgRecord = "record"
 const TaskConsoleMsgAppend = "append"
+const TaskConsoleMsgProgress = "progress"
 
 type ConsoleMsg struct {
 }
 
 type TaskConsoleMsg struct {
-	Type    string          `json:"type"`
-	Records []*model.Record `json:"records,omitempty"`
+	Type     string              `json:"type"`
+	Records  []*model.Record     `json:"records,omitempty"`
+	Progress []*TransferProgress `json:"progress,omitempty"`
+}
+
+// TransferProgress 上传程序包到服务器的进度
+type TransferProgress struct {
+	ServerId int64  `json:"server_id"`
+	Server   string `json:"server"`
+	ssh.Progress
 }
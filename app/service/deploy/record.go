@@ -6,6 +6,7 @@ import (
 	"go-walle/app/global"
 	"go-walle/app/model"
 	"go-walle/app/pkg/ssh"
+	"go-walle/app/service/server"
 	"go.uber.org/zap"
 	ssh2 "golang.org/x/crypto/ssh"
 	"os/exec"
@@ -46,14 +47,15 @@ func (r *record) Run(ctx context.Context) (err error) {
 	if r.server == nil {
 		command = ssh.NewLocalExec()
 	} else {
-		command, err = global.Ssh.NewRemoteExec(ssh.ServerConfig{
-			Host:     r.server.Host,
-			User:     r.server.User,
-			Password: "ipfs",
-			Port:     r.server.Port,
-		})
+		var sshConfig ssh.ServerConfig
+		if sshConfig, err = server.SshConfig(global.DB, r.server); err == nil {
+			command, err = global.Ssh.NewRemoteExec(sshConfig)
+		}
 	}
 	if err == nil {
+		defer func() {
+			_ = command.Close()
+		}()
 		var output []byte
 		output, err = command.WithEnvs(r.envs).WithCtx(ctx).Run(r.model.Command)
 		r.model.Output = string(output)
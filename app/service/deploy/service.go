@@ -9,9 +9,13 @@ import (
 	"go-walle/app/global"
 	"go-walle/app/internal/errcode"
 	"go-walle/app/model"
+	"go-walle/app/pkg/repo"
 	"go-walle/app/service/common"
+	"go-walle/app/service/notice"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"io"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -22,15 +26,17 @@ var (
 )
 
 type Service struct {
-	db  *gorm.DB
-	log *zap.Logger
+	db     *gorm.DB
+	log    *zap.Logger
+	notice *notice.Service
 }
 
 func NewService() *Service {
 	onceService.Do(func() {
 		service = &Service{
-			db:  global.DB,
-			log: global.Log,
+			db:     global.DB,
+			log:    global.Log,
+			notice: notice.NewService(global.Log, global.DB, &global.Cfg.Notice),
 		}
 	})
 	return service
@@ -56,13 +62,19 @@ func (srv *Service) List(params *ListReq) (total int64, list []*model.Task, err
 
 // Create 创建上线单
 func (srv *Service) Create(params *CreateReq) error {
+	_, err := srv.CreateTask(params)
+	return err
+}
+
+// CreateTask 创建上线单，并返回创建的上线单
+func (srv *Service) CreateTask(params *CreateReq) (*model.Task, error) {
 	project := &model.Project{SpaceId: params.SpaceId, ID: params.ProjectId}
 	err := srv.db.Model(&project).Where(project).Preload("Environment").Preload("Servers").First(&project).Error
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !project.Status.IsEnable() || !project.Environment.Status.IsEnable() {
-		return errors.New("该项目或者该环境暂停上线，请联系相关负责人")
+		return nil, errors.New("该项目或者该环境暂停上线，请联系相关负责人")
 	}
 	serverIds := slices.Map(project.Servers, func(item model.Server, k int) int64 {
 		return item.ID
@@ -78,14 +90,49 @@ func (srv *Service) Create(params *CreateReq) error {
 		CommitId:      params.CommitId,
 		ServerIds:     slices.Intersect(serverIds, params.ServerIds),
 	}
+	if repo.TypeRepo(project.RepoType) == repo.ArtifactRepo {
+		m.ArtifactUrl, m.ArtifactChecksum = params.ArtifactUrl, params.ArtifactChecksum
+		if m.ArtifactUrl == "" && project.RepoUrl == "" {
+			return nil, errcode.ErrInvalidParams.New("请填写构建产物地址或者上传构建产物")
+		}
+	}
 	m.Status = model.TaskStatusAudit
 	if project.TaskAudit == 1 {
 		m.Status = model.TaskStatusWaiting
 	}
 	if len(m.ServerIds) == 0 {
-		return errcode.ErrRequest.Wrap(errors.New("服务器选择错误"))
+		return nil, errcode.ErrRequest.Wrap(errors.New("服务器选择错误"))
+	}
+	if err = srv.db.Create(m).Error; err != nil {
+		return nil, err
+	}
+	if m.Status == model.TaskStatusWaiting {
+		srv.notice.Notify(notice.EventTaskWaiting, m.ID, m.UserId)
+	} else {
+		srv.notice.Notify(notice.EventTaskCreate, m.ID, m.UserId)
+	}
+	return m, nil
+}
+
+// UploadArtifact 上传构建产物，返回的地址用于创建上线单
+func (srv *Service) UploadArtifact(params *UploadArtifactReq, name string, r io.Reader) (*UploadArtifactRes, error) {
+	project := model.Project{}
+	err := srv.db.Where("space_id = ? and id = ?", params.SpaceId, params.ProjectId).First(&project).Error
+	if err != nil {
+		return nil, err
+	}
+	if repo.TypeRepo(project.RepoType) != repo.ArtifactRepo {
+		return nil, errcode.ErrInvalidParams.New("该项目不是构建产物类型")
+	}
+	_repo, err := global.Repo.New(repo.ArtifactRepo, project.RepoUrl, strconv.Itoa(int(project.ID)), project.RepoOptions())
+	if err != nil {
+		return nil, err
 	}
-	return srv.db.Create(m).Error
+	source, checksum, err := _repo.(*repo.Artifact).Save(name, r)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadArtifactRes{ArtifactUrl: source, ArtifactChecksum: checksum}, nil
 }
 
 // Detail 上线单详情
@@ -127,12 +174,18 @@ func (srv *Service) Audit(params *AuditReq) (err error) {
 	}
 
 	m.AuditUserId = params.AuditUserId
+	event := notice.EventTaskAudit
 	if params.Audit {
 		m.Status = model.TaskStatusAudit
 	} else {
 		m.Status = model.TaskStatusReject
+		event = notice.EventTaskReject
+	}
+	if err = srv.db.Select("status", "audit_user_id").Updates(&m).Error; err != nil {
+		return
 	}
-	return srv.db.Select("status", "audit_user_id").Updates(&m).Error
+	srv.notice.Notify(event, m.ID, params.AuditUserId)
+	return
 }
 
 // Release 发布
@@ -294,7 +347,7 @@ func (srv *Service) Console(wsConn *websocket.Conn, spaceAndId *common.SpaceWith
 				str, _ := json.Marshal(msg)
 				_err := wsConn.WriteMessage(websocket.TextMessage, str)
 				if _err != nil {
-					global.Log.Error("ws发送失败", zap.Error(err), zap.Int64("id", msg.Records[0].ID))
+					global.Log.Error("ws发送失败", zap.Error(_err), zap.String("type", msg.Type))
 					writeErr <- _err
 					return
 				}
@@ -321,6 +374,20 @@ func (srv *Service) Console(wsConn *websocket.Conn, spaceAndId *common.SpaceWith
 				}
 			}
 		}
+		//上传进度只在发布过程中推送，不保存
+		progressVersion := 0
+		getProgress := func() {
+			version, progress := task.Progress()
+			if version == progressVersion {
+				return
+			}
+			progressVersion = version
+			recordChan <- &TaskConsoleMsg{
+				Type:     TaskConsoleMsgProgress,
+				Progress: progress,
+			}
+			err = <-writeErr
+		}
 		for {
 			select {
 			case <-task.IsStop():
@@ -328,7 +395,13 @@ func (srv *Service) Console(wsConn *websocket.Conn, spaceAndId *common.SpaceWith
 				global.Log.Debug("发布任务已经完成", zap.Int64("taskId", spaceAndId.ID))
 				return
 			default:
-				getRecords()
+				getProgress()
+				if err == nil {
+					getRecords()
+				}
+				if err != nil {
+					return
+				}
 				time.Sleep(time.Second * 1)
 			}
 		}
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/wuzfei/go-helper/compress"
-	"github.com/wuzfei/go-helper/path"
 	"github.com/wuzfei/go-helper/slices"
 	"github.com/zeebo/errs"
 	"go-walle/app/global"
@@ -259,21 +258,19 @@ func (t *Task) deploy() error {
 	if err != nil {
 		return errors.New("获取代码仓库错误：" + err.Error())
 	}
+	//2、导出发布版本代码到新目录，以便下面执行编译等操作
+	dest := t.deployDirs.localWarehouseDir
 	if t.model.Tag != "" {
-		err = _repo.CheckoutToTag(t.model.Tag)
+		_, err = _repo.CheckoutToTag(t.model.Tag, dest)
 	} else if t.model.Branch != "" && t.model.CommitId != "" {
-		err = _repo.CheckoutToCommit(t.model.Branch, t.model.CommitId)
+		_, err = _repo.CheckoutToCommit(t.model.Branch, t.model.CommitId, dest)
 	} else {
 		err = errors.New("发布分支选取错误")
 	}
 	if err != nil {
-		return err
-	}
-	//2、复制发布版本代码到新目录，以便下面执行编译等操作
-	if _, err = path.CopyDirToDir(t.deployDirs.localWarehouseDir, _repo.Path()); err != nil {
 		return errors.New("检出代码失败：" + err.Error())
 	}
-	return err
+	return nil
 }
 
 // postDeploy step3.推送到服务器前的操作，比如下载依赖，编译等
@@ -406,7 +403,7 @@ func (t *Task) createReleaseVersion() string {
 }
 
 func (t *Task) getRepo() (repo.Repo, error) {
-	return global.Repo.New(repo.TypeRepo(t.model.Project.RepoType), t.model.Project.RepoUrl, fmt.Sprintf("%d", t.model.Project.ID))
+	return global.Repo.New(repo.TypeRepo(t.model.Project.RepoType), t.model.Project.RepoUrl, fmt.Sprintf("%d", t.model.Project.ID), t.model.Project.RepoOptions())
 }
 
 func (t *Task) getFileMatch() compress.Match {
@@ -26,7 +26,27 @@ func (r *UpdateReq) Fields() []string {
 	return []string{"name", "status", "description", "color"}
 }
 
+// SignPolicyReq 签名策略只能由空间所有者修改
+type SignPolicyReq struct {
+	SpaceId    int64 `json:"-" binding:"required,gt=0"`
+	ID         int64 `json:"id" binding:"required"`
+	SignPolicy int8  `json:"sign_policy" binding:"oneof=0 1"`
+}
+
 type ListReq struct {
 	SpaceId int64 `json:"-" binding:"required,gt=0"`
 	db.Paginator
 }
+
+type SigningKeyCreateReq struct {
+	SpaceId   int64  `json:"-" binding:"required,gt=0"`
+	UserId    int64  `json:"-" binding:"required,gt=0"`
+	Name      string `json:"name" binding:"required,max=100"`
+	Type      string `json:"type" binding:"required,oneof=gpg ssh"`
+	PublicKey string `json:"public_key" binding:"required,max=20000"`
+}
+
+type SigningKeyListReq struct {
+	SpaceId int64 `json:"-" binding:"required,gt=0"`
+	db.Paginator
+}
@@ -34,13 +34,21 @@ func (srv *Service) List(params *ListReq) (total int64, list []*model.Environmen
 	return
 }
 
+// Create 签名策略只能由空间所有者修改，新环境沿用空间内最严格的策略
 func (srv *Service) Create(params *CreateReq) error {
+	var signPolicy int8
+	err := srv.db.Model(&model.Environment{}).Where("space_id = ?", params.SpaceId).
+		Select("COALESCE(MAX(sign_policy), 0)").Scan(&signPolicy).Error
+	if err != nil {
+		return err
+	}
 	return srv.db.Create(&model.Environment{
 		SpaceId:     params.SpaceId,
 		Name:        params.Name,
 		Description: params.Description,
 		Status:      params.Status,
 		Color:       params.Color,
+		SignPolicy:  signPolicy,
 	}).Error
 }
 
@@ -51,6 +59,13 @@ func (srv *Service) Update(params *UpdateReq) error {
 		Updates(params).Error
 }
 
+// SignPolicy 修改环境的签名策略
+func (srv *Service) SignPolicy(params *SignPolicyReq) error {
+	return srv.db.Model(model.Environment{}).
+		Where(model.Environment{SpaceId: params.SpaceId, ID: params.ID}).
+		Update("sign_policy", params.SignPolicy).Error
+}
+
 // Delete 环境下必须没有项目了才能删除
 func (srv *Service) Delete(spaceWithId *common.SpaceWithId) error {
 	total := srv.db.Model(&model.Environment{ID: spaceWithId.ID}).Association("Projects").Count()
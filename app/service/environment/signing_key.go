@@ -0,0 +1,62 @@
+package environment
+
+import (
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/pkg/repo"
+	"go-walle/app/service/common"
+	"strings"
+)
+
+// SigningKeyList 空间内受信任的签名公钥
+func (srv *Service) SigningKeyList(params *SigningKeyListReq) (total int64, list []*model.SigningKey, err error) {
+	_db := srv.db.Model(&model.SigningKey{}).Where("space_id = ?", params.SpaceId)
+	if err = _db.Count(&total).Error; err != nil || total == 0 {
+		return
+	}
+	err = _db.Scopes(params.PageQuery()).Preload("User").Order("id desc").Find(&list).Error
+	return
+}
+
+// SigningKeyCreate 添加受信任的签名公钥，同一空间内不能重复添加
+func (srv *Service) SigningKeyCreate(params *SigningKeyCreateReq) error {
+	publicKey := strings.TrimSpace(params.PublicKey)
+	fingerprint, err := repo.KeyFingerprint(repo.SignKeyType(params.Type), publicKey)
+	if err != nil {
+		return errcode.ErrInvalidParams.Wrap(err)
+	}
+	var total int64
+	if err = srv.db.Model(&model.SigningKey{}).
+		Where("space_id = ? and fingerprint = ?", params.SpaceId, fingerprint).
+		Count(&total).Error; err != nil {
+		return err
+	}
+	if total > 0 {
+		return errcode.ErrInvalidParams.New("该公钥已存在：%s", fingerprint)
+	}
+	return srv.db.Create(&model.SigningKey{
+		SpaceId:     params.SpaceId,
+		UserId:      params.UserId,
+		Name:        params.Name,
+		Type:        params.Type,
+		PublicKey:   publicKey,
+		Fingerprint: fingerprint,
+	}).Error
+}
+
+func (srv *Service) SigningKeyDelete(spaceWithId *common.SpaceWithId) error {
+	return srv.db.Delete(&model.SigningKey{}, "space_id = ? and id = ?", spaceWithId.SpaceId, spaceWithId.ID).Error
+}
+
+// TrustedKeys 空间内所有受信任的签名公钥
+func (srv *Service) TrustedKeys(spaceId int64) ([]repo.TrustedKey, error) {
+	list := make([]*model.SigningKey, 0)
+	if err := srv.db.Where("space_id = ?", spaceId).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	keys := make([]repo.TrustedKey, 0, len(list))
+	for _, item := range list {
+		keys = append(keys, repo.TrustedKey{Name: item.Name, Type: repo.SignKeyType(item.Type), PublicKey: item.PublicKey})
+	}
+	return keys, nil
+}
@@ -0,0 +1,60 @@
+package files
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+type PolicyReq struct {
+	SpaceId int64    `json:"-" binding:"required,gt=0"`
+	Roots   []string `json:"roots" binding:"omitempty,max=20,dive,required,startswith=/,max=500"` //允许访问的根目录
+}
+
+type PathReq struct {
+	SpaceId int64  `json:"-" binding:"required,gt=0"`
+	ID      int64  `json:"-" binding:"required,gt=0"` //服务器id
+	Path    string `form:"path" json:"path" binding:"required,startswith=/,max=1000"`
+}
+
+type UploadReq struct {
+	SpaceId int64  `json:"-" binding:"required,gt=0"`
+	ID      int64  `json:"-" binding:"required,gt=0"`
+	UserId  int64  `json:"-" binding:"required,gt=0"`
+	Dir     string `form:"dir" binding:"required,startswith=/,max=1000"` //上传到的目录
+}
+
+type SaveReq struct {
+	SpaceId int64  `json:"-" binding:"required,gt=0"`
+	ID      int64  `json:"-" binding:"required,gt=0"`
+	UserId  int64  `json:"-" binding:"required,gt=0"`
+	Path    string `json:"path" binding:"required,startswith=/,max=1000"`
+	Content string `json:"content"`
+}
+
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	IsDir   bool      `json:"is_dir"`
+	IsLink  bool      `json:"is_link"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func newFileInfo(dir string, info os.FileInfo) *FileInfo {
+	return &FileInfo{
+		Name:    info.Name(),
+		Path:    path.Join(dir, info.Name()),
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		IsDir:   info.IsDir(),
+		IsLink:  info.Mode()&os.ModeSymlink != 0,
+		ModTime: info.ModTime(),
+	}
+}
+
+type FileContent struct {
+	*FileInfo
+	Content string `json:"content"`
+}
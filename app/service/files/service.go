@@ -0,0 +1,403 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"go-walle/app/global"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/pkg/ssh"
+	"go-walle/app/service/audit"
+	"go-walle/app/service/server"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	service     *Service
+	onceService sync.Once
+)
+
+const maxLinks = 40 //解析路径时最多跟随的符号链接数
+
+// Service 通过sftp浏览和编辑服务器文件，只能访问空间设置的根目录，写操作记录审计日志
+type Service struct {
+	log    *zap.Logger
+	db     *gorm.DB
+	ssh    *ssh.Ssh
+	audit  *audit.Service
+	config *global.FilesConfig
+}
+
+func NewService(log *zap.Logger, db *gorm.DB, ssh *ssh.Ssh, audit *audit.Service, conf *global.FilesConfig) *Service {
+	onceService.Do(func() {
+		service = &Service{
+			log:    log.Named("files"),
+			db:     db,
+			ssh:    ssh,
+			audit:  audit,
+			config: conf,
+		}
+	})
+	return service
+}
+
+// Policy 空间的文件浏览设置
+func (srv *Service) Policy(spaceId int64) (*model.FilePolicy, error) {
+	m := &model.FilePolicy{}
+	err := srv.db.Where("space_id = ?", spaceId).First(m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &model.FilePolicy{SpaceId: spaceId}, nil
+	}
+	return m, err
+}
+
+// PolicyUpdate 根目录必须是绝对路径，保存规范后的路径
+func (srv *Service) PolicyUpdate(params *PolicyReq) error {
+	roots := make([]string, 0, len(params.Roots))
+	for _, root := range params.Roots {
+		if !path.IsAbs(root) || strings.ContainsAny(root, "\r\n\x00") {
+			return errcode.ErrInvalidParams.New("根目录必须是绝对路径：%q", root)
+		}
+		roots = append(roots, path.Clean(root))
+	}
+	m := &model.FilePolicy{SpaceId: params.SpaceId, Roots: strings.Join(roots, "\n")}
+	return srv.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "space_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"roots", "updated_at"}),
+	}).Create(m).Error
+}
+
+// List 列出目录，目录排在前面
+func (srv *Service) List(params *PathReq) (list []*FileInfo, err error) {
+	err = srv.session(params.SpaceId, params.ID, func(s *session) error {
+		dir, err := s.resolve(params.Path, true)
+		if err != nil {
+			return err
+		}
+		infos, err := s.sftp.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		list = make([]*FileInfo, 0, len(infos))
+		for _, info := range infos {
+			list = append(list, newFileInfo(dir, info))
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].IsDir != list[j].IsDir {
+				return list[i].IsDir
+			}
+			return list[i].Name < list[j].Name
+		})
+		return nil
+	})
+	return
+}
+
+func (srv *Service) Stat(params *PathReq) (info *FileInfo, err error) {
+	err = srv.session(params.SpaceId, params.ID, func(s *session) error {
+		info, err = s.stat(params.Path)
+		return err
+	})
+	return
+}
+
+// Download 下载文件，返回的ReadCloser关闭时同时关闭sftp会话
+func (srv *Service) Download(params *PathReq) (io.ReadCloser, *FileInfo, error) {
+	s, err := srv.open(params.SpaceId, params.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := s.stat(params.Path)
+	if err == nil && info.IsDir {
+		err = errcode.ErrInvalidParams.New("不能下载目录")
+	}
+	if limit := srv.config.MaxDownload << 20; err == nil && info.Size > limit {
+		err = errcode.ErrInvalidParams.New("文件超过%dMB，不能下载", srv.config.MaxDownload)
+	}
+	var f io.ReadCloser
+	if err == nil {
+		f, err = s.sftp.Open(info.Path)
+	}
+	if err != nil {
+		_ = s.sftp.Close()
+		return nil, nil, err
+	}
+	//下载过程中文件可能变大
+	return &download{Reader: io.LimitReader(f, info.Size), file: f, sftp: s.sftp}, info, nil
+}
+
+// Content 读取文本文件用于在线编辑
+func (srv *Service) Content(params *PathReq) (content *FileContent, err error) {
+	err = srv.session(params.SpaceId, params.ID, func(s *session) error {
+		info, err := s.stat(params.Path)
+		if err != nil {
+			return err
+		}
+		if err = srv.checkEdit(info.IsDir, info.Size); err != nil {
+			return err
+		}
+		f, err := s.sftp.Open(info.Path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		data, err := io.ReadAll(io.LimitReader(f, srv.config.MaxEdit<<10+1))
+		if err != nil {
+			return err
+		}
+		if err = srv.checkEdit(false, int64(len(data))); err != nil {
+			return err
+		}
+		if !utf8.Valid(data) {
+			return errcode.ErrInvalidParams.New("不是文本文件，不能在线编辑")
+		}
+		content = &FileContent{FileInfo: info, Content: string(data)}
+		return nil
+	})
+	return
+}
+
+// Save 保存在线编辑的文件，保留原来的文件权限、所有者和硬链接
+func (srv *Service) Save(params *SaveReq) error {
+	return srv.session(params.SpaceId, params.ID, func(s *session) error {
+		file, err := s.resolve(params.Path, true)
+		if err != nil {
+			return err
+		}
+		info, err := s.sftp.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err = srv.checkEdit(info.IsDir(), int64(len(params.Content))); err != nil {
+			return err
+		}
+		err = s.sftp.WriteFile(file, strings.NewReader(params.Content), int64(len(params.Content)), info.Mode().Perm())
+		srv.auditLog(s, params.UserId, model.AuditFileEdit, file, fmt.Sprintf("%d字节", len(params.Content)), err)
+		return err
+	})
+}
+
+// Upload 上传文件到目录，已存在的同名文件会被覆盖
+func (srv *Service) Upload(params *UploadReq, name string, r io.Reader, size int64) error {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		return errcode.ErrInvalidParams.New("文件名错误")
+	}
+	if size > srv.config.MaxUpload<<20 {
+		return errcode.ErrInvalidParams.New("文件超过%dMB，不能上传", srv.config.MaxUpload)
+	}
+	return srv.session(params.SpaceId, params.ID, func(s *session) error {
+		dir, err := s.resolve(params.Dir, true)
+		if err != nil {
+			return err
+		}
+		file, err := s.resolve(path.Join(dir, name), false)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if info, err := s.sftp.Stat(file); err == nil {
+			if info.IsDir() {
+				return errcode.ErrInvalidParams.New("%s是目录", file)
+			}
+			mode = info.Mode().Perm()
+		}
+		err = s.sftp.WriteFile(file, io.LimitReader(r, size), size, mode)
+		srv.auditLog(s, params.UserId, model.AuditFileUpload, file, fmt.Sprintf("%d字节", size), err)
+		return err
+	})
+}
+
+func (srv *Service) checkEdit(isDir bool, size int64) error {
+	if isDir {
+		return errcode.ErrInvalidParams.New("不能编辑目录")
+	}
+	if size > srv.config.MaxEdit<<10 {
+		return errcode.ErrInvalidParams.New("文件超过%dKB，不能在线编辑", srv.config.MaxEdit)
+	}
+	return nil
+}
+
+// auditLog 写操作记录审计日志，失败的操作同样记录
+func (srv *Service) auditLog(s *session, userId int64, action, file, detail string, err error) {
+	if err != nil {
+		detail += "，失败：" + err.Error()
+	}
+	srv.audit.Log(&model.AuditLog{
+		SpaceId:  s.server.SpaceId,
+		UserId:   userId,
+		Action:   action,
+		ServerId: s.server.ID,
+		Target:   file,
+		Detail:   detail,
+	})
+}
+
+// session 打开sftp会话执行fn，结束后关闭
+func (srv *Service) session(spaceId, serverId int64, fn func(s *session) error) error {
+	s, err := srv.open(spaceId, serverId)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.sftp.Close()
+	}()
+	return fn(s)
+}
+
+func (srv *Service) open(spaceId, serverId int64) (*session, error) {
+	policy, err := srv.Policy(spaceId)
+	if err != nil {
+		return nil, err
+	}
+	roots := policy.RootList()
+	if len(roots) == 0 {
+		return nil, errcode.ErrInvalidParams.New("空间没有设置可以浏览的目录")
+	}
+	serverDetail := model.Server{}
+	if err = srv.db.Where("space_id = ? and id = ?", spaceId, serverId).First(&serverDetail).Error; err != nil {
+		return nil, err
+	}
+	sshConfig, err := server.SshConfig(srv.db, &serverDetail)
+	if err != nil {
+		return nil, err
+	}
+	_sftp, err := srv.ssh.NewSftp(sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &session{server: serverDetail, sftp: _sftp, roots: roots}, nil
+}
+
+// session 一次sftp操作
+type session struct {
+	server    model.Server
+	sftp      *ssh.Sftp
+	roots     []string
+	realRoots []string
+}
+
+// resolve 校验路径在允许访问的根目录中，返回解析符号链接后的路径，防止通过符号链接访问根目录以外的文件。
+// exist为false时文件可以不存在，只解析所在目录，已存在时同样解析文件本身，防止写入时跟随符号链接写到根目录以外
+func (s *session) resolve(p string, exist bool) (string, error) {
+	p = path.Clean(p)
+	if !path.IsAbs(p) || !within(s.roots, p) {
+		return "", errcode.ErrInvalidParams.New("%s不在允许访问的目录中", p)
+	}
+	if s.realRoots == nil {
+		for _, root := range s.roots {
+			if realPath, err := s.realPath(root); err == nil {
+				s.realRoots = append(s.realRoots, realPath)
+			}
+		}
+	}
+	var (
+		realPath string
+		err      error
+	)
+	if !exist {
+		if _, err = s.sftp.Lstat(p); err == nil {
+			exist = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	if exist {
+		realPath, err = s.realPath(p)
+	} else if realPath, err = s.realPath(path.Dir(p)); err == nil {
+		realPath = path.Join(realPath, path.Base(p))
+	}
+	if err != nil {
+		return "", err
+	}
+	if !within(s.realRoots, realPath) {
+		return "", errcode.ErrInvalidParams.New("%s不在允许访问的目录中", p)
+	}
+	return realPath, nil
+}
+
+// realPath 逐级解析路径中的符号链接，不依赖服务器realpath的实现
+func (s *session) realPath(p string) (string, error) {
+	resolved, links := "/", 0
+	rest := strings.Split(p, "/")
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, name)
+		info, err := s.sftp.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxLinks {
+			return "", errcode.ErrInvalidParams.New("%s符号链接层数过多", p)
+		}
+		target, err := s.sftp.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+func (s *session) stat(p string) (*FileInfo, error) {
+	file, err := s.resolve(p, true)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.sftp.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(path.Dir(file), info), nil
+}
+
+// within p是否是某个根目录或在根目录中
+func within(roots []string, p string) bool {
+	for _, root := range roots {
+		if p == root || strings.HasPrefix(p, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// download 下载的文件，关闭时同时关闭sftp会话
+type download struct {
+	io.Reader
+	file io.Closer
+	sftp *ssh.Sftp
+}
+
+func (d *download) Close() error {
+	err := d.file.Close()
+	if _err := d.sftp.Close(); err == nil {
+		err = _err
+	}
+	return err
+}
@@ -0,0 +1,297 @@
+package files
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"github.com/pkg/sftp"
+	"go-walle/app/model"
+	"go-walle/app/pkg/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWithin(t *testing.T) {
+	roots := []string{"/data/www", "/etc/nginx"}
+	for p, want := range map[string]bool{
+		"/data/www":             true,
+		"/data/www/a/b":         true,
+		"/data/www2":            false,
+		"/data":                 false,
+		"/etc/nginx/nginx.conf": true,
+		"/etc/nginx":            true,
+	} {
+		if within(roots, p) != want {
+			t.Errorf("within(%s) != %v", p, want)
+		}
+	}
+	if !within([]string{"/"}, "/etc") {
+		t.Error("根目录为/时不能访问")
+	}
+}
+
+// newTestSftp 连接进程内的ssh服务器，sftp子系统直接访问本地文件
+func newTestSftp(t *testing.T) *ssh.Sftp {
+	t.Helper()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &gossh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftp(conn, config)
+		}
+	}()
+	sh, _ := ssh.NewSSH(&ssh.Config{Timeout: 5 * time.Second})
+	sf, err := sh.NewSftp(ssh.ServerConfig{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, User: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = sf.Close()
+	})
+	return sf
+}
+
+func serveSftp(conn net.Conn, config *gossh.ServerConfig) {
+	sconn, chans, reqs, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go gossh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(gossh.UnknownChannelType, nc.ChannelType())
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range chReqs {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if ok {
+					go func() {
+						defer ch.Close()
+						if server, err := sftp.NewServer(ch); err == nil {
+							_ = server.Serve()
+						}
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// testDir 临时目录，解析符号链接后的路径，和服务器返回的真实路径一致
+func testDir(t *testing.T) string {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestSessionResolve(t *testing.T) {
+	dir := testDir(t)
+	for _, d := range []string{"www/sub", "secret"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = os.WriteFile(filepath.Join(dir, "www/a.txt"), []byte("a"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "secret/s.txt"), []byte("s"), 0644)
+	for link, target := range map[string]string{
+		"www/abs":       filepath.Join(dir, "www/a.txt"),
+		"www/sub/rel":   "../a.txt",
+		"www/escape":    filepath.Join(dir, "secret/s.txt"),
+		"www/escdir":    "../secret",
+		"www/chain":     "sub/rel",
+		"www/sub/chain": "../escdir/s.txt",
+		"www/loop1":     "loop2",
+		"www/loop2":     "loop1",
+		"wwwlink":       "www",
+		"www/dangling":  "../secret/new.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &session{sftp: newTestSftp(t), roots: []string{dir + "/www", dir + "/wwwlink"}}
+	for _, c := range []struct {
+		path  string
+		exist bool
+		want  string //为空时应报错
+	}{
+		{"/www/a.txt", true, "/www/a.txt"},
+		{"/www", true, "/www"},
+		{"/www/abs", true, "/www/a.txt"},
+		{"/www/sub/rel", true, "/www/a.txt"},
+		{"/www/chain", true, "/www/a.txt"},
+		{"/www/./sub/../a.txt", true, "/www/a.txt"},
+		{"/wwwlink/sub/rel", true, "/www/a.txt"},
+		{"/www/new.txt", false, "/www/new.txt"},
+		{"/www/sub/new.txt", false, "/www/sub/new.txt"},
+		//符号链接指向根目录以外
+		{"/www/escape", true, ""},
+		{"/www/escdir/s.txt", true, ""},
+		{"/www/sub/chain", true, ""},
+		{"/www/escdir/new.txt", false, ""},
+		//上传到已存在的符号链接时解析链接本身
+		{"/www/abs", false, "/www/a.txt"},
+		{"/www/escape", false, ""},
+		{"/www/dangling", false, ""},
+		{"/www/../secret/s.txt", true, ""},
+		{"/secret/s.txt", true, ""},
+		//不存在或者循环的链接
+		{"/www/none", true, ""},
+		{"/www/none/new.txt", false, ""},
+		{"/www/loop1", true, ""},
+	} {
+		got, err := s.resolve(dir+c.path, c.exist)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("resolve(%s)应报错，解析为%s", c.path, got)
+			}
+			continue
+		}
+		if err != nil || got != dir+c.want {
+			t.Errorf("resolve(%s) = %s, %v, want %s", c.path, got, err, dir+c.want)
+		}
+	}
+	if _, err := s.resolve("www/a.txt", true); err == nil {
+		t.Error("相对路径应报错")
+	}
+}
+
+type failReader struct{}
+
+func (failReader) Read([]byte) (int, error) {
+	return 0, errors.New("upload aborted")
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := testDir(t)
+	sf := newTestSftp(t)
+	file := filepath.Join(dir, "a.conf")
+	if err := os.WriteFile(file, []byte("old content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chmod(file, 0640)
+	link := filepath.Join(dir, "hard.conf")
+	if err := os.Link(file, link); err != nil {
+		t.Fatal(err)
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		uid, gid = 1234, 5678
+		if err := os.Chown(file, uid, gid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, _ := os.Stat(file)
+
+	//写入失败时不影响原文件，也不留下临时文件
+	if err := sf.WriteFile(file, io.MultiReader(strings.NewReader("new"), failReader{}), 20, 0644); err == nil {
+		t.Fatal("写入失败时应报错")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "old content" {
+		t.Error("写入失败时原文件被修改", string(data))
+	}
+
+	//已存在的文件保留权限、所有者和硬链接
+	if err := sf.WriteFile(file, strings.NewReader("new"), 3, 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(file)
+	if data, _ := os.ReadFile(link); string(data) != "new" || !os.SameFile(before, after) {
+		t.Error("硬链接被破坏", string(data))
+	}
+	if after.Mode().Perm() != 0640 {
+		t.Errorf("文件权限被修改：%v", after.Mode())
+	}
+	if st := after.Sys().(*syscall.Stat_t); int(st.Uid) != uid || int(st.Gid) != gid {
+		t.Errorf("文件所有者被修改：%d:%d", st.Uid, st.Gid)
+	}
+
+	//新文件使用指定的权限
+	newFile := filepath.Join(dir, "new.conf")
+	if err := sf.WriteFile(newFile, strings.NewReader("created"), 7, 0604); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(newFile)
+	if err != nil || info.Mode().Perm() != 0604 {
+		t.Error("新文件权限错误", info, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Error("临时文件没有删除", entries)
+	}
+}
+
+func TestPolicyUpdate(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//sqlite驱动只会把datetime类型的列解析为时间，去掉模型中指定的time类型
+	stmt := &gorm.Statement{DB: db}
+	if err = stmt.Parse(&model.FilePolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range stmt.Schema.Fields {
+		if f.DataType == schema.Time {
+			delete(f.TagSettings, "TYPE")
+		}
+	}
+	if err = db.AutoMigrate(&model.FilePolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	srv := &Service{db: db}
+	for _, roots := range [][]string{
+		{"data/www"},
+		{"/data/www", "./etc"},
+		{"/data/www\n/"},
+		{"/data/www\x00"},
+	} {
+		if err = srv.PolicyUpdate(&PolicyReq{SpaceId: 1, Roots: roots}); err == nil {
+			t.Errorf("根目录%q应报错", roots)
+		}
+	}
+	if err = srv.PolicyUpdate(&PolicyReq{SpaceId: 1, Roots: []string{"/data/www/", "/etc/nginx/../nginx"}}); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := srv.Policy(1)
+	if err != nil || policy.Roots != "/data/www\n/etc/nginx" {
+		t.Errorf("保存的根目录错误：%q %v", policy.Roots, err)
+	}
+}
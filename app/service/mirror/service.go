@@ -0,0 +1,119 @@
+package mirror
+
+import (
+	"context"
+	"go-walle/app/model"
+	"go-walle/app/model/field"
+	"go-walle/app/pkg/repo"
+	"go-walle/app/service/common"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	service     *Service
+	onceService sync.Once
+)
+
+// Service 后台定时同步所有启用项目的仓库镜像，分支、标签和提交记录直接从本地镜像读取
+type Service struct {
+	log      *zap.Logger
+	db       *gorm.DB
+	repos    *repo.Repos
+	interval time.Duration
+	trigger  chan int64
+}
+
+func NewService(log *zap.Logger, db *gorm.DB, repos *repo.Repos, interval time.Duration) *Service {
+	onceService.Do(func() {
+		service = &Service{
+			log:      log.Named("mirror"),
+			db:       db,
+			repos:    repos,
+			interval: interval,
+			trigger:  make(chan int64, 100),
+		}
+	})
+	return service
+}
+
+// Run 启动后台同步，直到ctx结束
+func (srv *Service) Run(ctx context.Context) error {
+	var tick <-chan time.Time
+	if srv.interval > 0 {
+		ticker := time.NewTicker(srv.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		srv.fetchAll(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			srv.fetchAll(ctx)
+		case id := <-srv.trigger:
+			if _, err := srv.fetchById(id); err != nil {
+				srv.log.Error("同步仓库失败", zap.Int64("project_id", id), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Trigger 异步触发同步某个项目，如代码仓库push时
+func (srv *Service) Trigger(projectId int64) {
+	select {
+	case srv.trigger <- projectId:
+	default:
+		srv.log.Warn("同步队列已满，忽略本次同步", zap.Int64("project_id", projectId))
+	}
+}
+
+// Fetch 立即同步项目仓库，返回同步完成时间
+func (srv *Service) Fetch(spaceWithId *common.SpaceWithId) (*time.Time, error) {
+	project := &model.Project{}
+	if err := srv.db.Where(spaceWithId).First(project).Error; err != nil {
+		return nil, err
+	}
+	return srv.fetch(project)
+}
+
+func (srv *Service) fetchAll(ctx context.Context) {
+	projects := make([]*model.Project, 0)
+	if err := srv.db.Where("status = ?", field.StatusEnable).Find(&projects).Error; err != nil {
+		srv.log.Error("获取项目失败", zap.Error(err))
+		return
+	}
+	for _, p := range projects {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := srv.fetch(p); err != nil {
+			srv.log.Error("同步仓库失败", zap.Int64("project_id", p.ID), zap.Error(err))
+		}
+	}
+}
+
+func (srv *Service) fetchById(id int64) (*time.Time, error) {
+	project := &model.Project{}
+	if err := srv.db.First(project, id).Error; err != nil {
+		return nil, err
+	}
+	return srv.fetch(project)
+}
+
+func (srv *Service) fetch(project *model.Project) (*time.Time, error) {
+	r, err := srv.repos.New(repo.TypeRepo(project.RepoType), project.RepoUrl, strconv.Itoa(int(project.ID)), project.RepoOptions())
+	if err != nil {
+		return nil, err
+	}
+	if err = r.Fetch(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = srv.db.Model(project).UpdateColumn("fetched_at", now).Error
+	return &now, err
+}
@@ -1,4 +1,59 @@
 package notice
 
-type DingtalkConfig struct {
-}
\ No newline at end of file
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Dingtalk 钉钉群机器人，开启加签时需要设置secret
+type Dingtalk struct {
+	client  *http.Client
+	webhook string
+	secret  string
+}
+
+func NewDingtalk(client *http.Client, webhook, secret string) *Dingtalk {
+	return &Dingtalk{client: client, webhook: webhook, secret: secret}
+}
+
+func (d *Dingtalk) Send(msg *Message) error {
+	text, err := msg.Markdown()
+	if err != nil {
+		return err
+	}
+	data := map[string]any{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": msg.Title,
+			"text":  text,
+		},
+	}
+	result := struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}{}
+	if err = postJson(d.client, d.url(), data, &result); err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		return ErrNotice.New("dingtalk: %d %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
+
+// url 加签：HmacSHA256(timestamp+"\n"+secret)后base64，通过url参数传递
+func (d *Dingtalk) url() string {
+	if d.secret == "" {
+		return d.webhook
+	}
+	timestamp := time.Now().UnixMilli()
+	mac := hmac.New(sha256.New, []byte(d.secret))
+	mac.Write([]byte(fmt.Sprintf("%d\n%s", timestamp, d.secret)))
+	sign := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return fmt.Sprintf("%s&timestamp=%d&sign=%s", d.webhook, timestamp, sign)
+}
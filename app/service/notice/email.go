@@ -1,4 +1,165 @@
 package notice
 
-type EmailConfig struct {
-}
\ No newline at end of file
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"go-walle/app/global"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+const (
+	EncryptionNone     = "none"
+	EncryptionStartTLS = "starttls"
+	EncryptionTLS      = "tls"
+)
+
+// Email 邮件通知，收件人之间互不可见
+type Email struct {
+	config  *global.EmailConfig
+	timeout time.Duration
+	to      []string
+}
+
+func NewEmail(conf *global.EmailConfig, timeout time.Duration, to []string) *Email {
+	return &Email{config: conf, timeout: timeout, to: to}
+}
+
+func (e *Email) Send(msg *Message) error {
+	if len(e.to) == 0 {
+		return nil
+	}
+	from, err := mail.ParseAddress(e.config.From)
+	if err != nil {
+		return ErrNotice.New("email from: %s", err)
+	}
+	body, err := e.body(from, msg)
+	if err != nil {
+		return err
+	}
+	client, err := e.dial()
+	if err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	if e.config.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.Host)); err != nil {
+			return ErrNotice.Wrap(err)
+		}
+	}
+	if err = client.Mail(from.Address); err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	for _, to := range e.to {
+		if err = client.Rcpt(to); err != nil {
+			return ErrNotice.Wrap(err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	if _, err = w.Write(body); err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	if err = w.Close(); err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	return ErrNotice.Wrap(client.Quit())
+}
+
+// dial 根据加密方式建立smtp连接
+func (e *Email) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+	dialer := &net.Dialer{Timeout: e.timeout}
+	tlsConfig := &tls.Config{ServerName: e.config.Host}
+	var conn net.Conn
+	var err error
+	if e.config.Encryption == EncryptionTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(e.timeout))
+	}
+	client, err := smtp.NewClient(conn, e.config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if e.config.Encryption == EncryptionStartTLS {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// body 生成同时包含纯文本和html的邮件内容
+func (e *Email) body(from *mail.Address, msg *Message) ([]byte, error) {
+	text, err := msg.Text()
+	if err != nil {
+		return nil, err
+	}
+	html, err := msg.Html()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	mw := multipart.NewWriter(&buf)
+	messageId := make([]byte, 16)
+	_, _ = rand.Read(messageId)
+	headers := []string{
+		"From: " + from.String(),
+		"To: undisclosed-recipients:;",
+		"Subject: " + mime.BEncoding.Encode("UTF-8", msg.Title),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@%s>", hex.EncodeToString(messageId), e.config.Host),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+	for _, h := range headers {
+		buf.WriteString(h + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", text},
+		{"text/html; charset=UTF-8", html},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, ErrNotice.Wrap(err)
+		}
+		encoded := base64.StdEncoding.EncodeToString([]byte(part.content))
+		//每行最长76个字符
+		for len(encoded) > 76 {
+			_, _ = w.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		_, _ = w.Write([]byte(encoded + "\r\n"))
+	}
+	if err = mw.Close(); err != nil {
+		return nil, ErrNotice.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,186 @@
+package notice
+
+import (
+	"encoding/base64"
+	"go-walle/app/global"
+	"go-walle/app/model"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func newTestMessage(event Event) *Message {
+	return &Message{
+		Event: event,
+		Title: "[demo]" + eventTitles[event],
+		Task: &model.Task{
+			Name:        "上线<v1>",
+			Branch:      "master",
+			CommitId:    "0123456789abcdef",
+			LastError:   "exit status 1 <script>",
+			Project:     model.Project{Name: "demo"},
+			Environment: model.Environment{Name: "生产"},
+			User:        model.User{Username: "alice"},
+		},
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	cases := []struct {
+		name     string
+		msg      func(msg *Message)
+		event    Event
+		contains []string
+		excludes []string
+	}{
+		{"分支版本", nil, EventTaskCreate,
+			[]string{"[demo]新建上线单\n", "项目：demo", "环境：生产", "上线单：上线<v1>", "版本：master@01234567", "提交人：alice"},
+			[]string{"操作人", "错误", "查看详情", "0123456789"}},
+		{"标签版本", func(msg *Message) { msg.Task.Tag = "v1.0.0" }, EventReleaseStart,
+			[]string{"版本：v1.0.0"}, []string{"master"}},
+		{"操作人", func(msg *Message) { msg.Operator = &model.User{Username: "bob"} }, EventTaskAudit,
+			[]string{"操作人：bob"}, nil},
+		{"发布失败显示错误", nil, EventReleaseFail,
+			[]string{"错误：exit status 1 <script>"}, nil},
+		{"没有错误信息", func(msg *Message) { msg.Task.LastError = "" }, EventReleaseFail,
+			nil, []string{"错误"}},
+		{"待审核链接", func(msg *Message) { msg.Link = "https://walle/#/deploy/release/1" }, EventTaskWaiting,
+			[]string{"\n\n请审核：https://walle/#/deploy/release/1"}, []string{"查看详情"}},
+		{"详情链接", func(msg *Message) { msg.Link = "https://walle/#/deploy/release/1" }, EventReleaseSuccess,
+			[]string{"查看详情：https://walle/#/deploy/release/1"}, nil},
+	}
+	for _, c := range cases {
+		msg := newTestMessage(c.event)
+		if c.msg != nil {
+			c.msg(msg)
+		}
+		text, err := msg.Text()
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		for _, s := range c.contains {
+			if !strings.Contains(text, s) {
+				t.Errorf("%s：缺少%q\n%s", c.name, s, text)
+			}
+		}
+		for _, s := range c.excludes {
+			if strings.Contains(text, s) {
+				t.Errorf("%s：不应包含%q\n%s", c.name, s, text)
+			}
+		}
+	}
+}
+
+func TestMessageHtml(t *testing.T) {
+	msg := newTestMessage(EventReleaseFail)
+	msg.Link = `https://walle/#/deploy/release/1?a=1&b="2"`
+	html, err := msg.Html()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"<h3>[demo]发布失败</h3>",
+		"<td>上线&lt;v1&gt;</td>",
+		"exit status 1 &lt;script&gt;",
+		`<a href="https://walle/#/deploy/release/1?a=1&amp;b=%222%22">查看详情</a>`,
+	} {
+		if !strings.Contains(html, s) {
+			t.Errorf("缺少%q\n%s", s, html)
+		}
+	}
+	if strings.Contains(html, "<script>") {
+		t.Error("html内容没有转义")
+	}
+}
+
+func TestEmailBody(t *testing.T) {
+	e := NewEmail(&global.EmailConfig{Host: "smtp.example.com", From: "gowalle <walle@example.com>"}, 0,
+		[]string{"alice@example.com", "bob@example.com"})
+	from, _ := mail.ParseAddress(e.config.From)
+	msg := newTestMessage(EventTaskWaiting)
+	msg.Link = "https://walle/#/deploy/release/1"
+	//超过76个字符的内容需要换行
+	msg.Task.LastError = strings.Repeat("错误", 100)
+	body, err := e.body(from, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := mime.WordDecoder{}
+	headers := []struct{ key, value string }{
+		{"From", `"gowalle" <walle@example.com>`},
+		{"To", "undisclosed-recipients:;"},
+		{"MIME-Version", "1.0"},
+	}
+	for _, h := range headers {
+		if m.Header.Get(h.key) != h.value {
+			t.Errorf("%s错误：%q", h.key, m.Header.Get(h.key))
+		}
+	}
+	if subject, _ := dec.DecodeHeader(m.Header.Get("Subject")); subject != msg.Title {
+		t.Error("主题错误", subject)
+	}
+	if !strings.HasSuffix(m.Header.Get("Message-ID"), "@smtp.example.com>") {
+		t.Error("Message-ID错误", m.Header.Get("Message-ID"))
+	}
+	if _, err = m.Header.Date(); err != nil {
+		t.Error("日期格式错误", err)
+	}
+	//收件人之间互不可见
+	if strings.Contains(string(body), "alice@example.com") || strings.Contains(string(body), "bob@example.com") {
+		t.Error("邮件内容中不应包含收件人")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatal("Content-Type错误", mediaType, err)
+	}
+	text, _ := msg.Text()
+	html, _ := msg.Html()
+	parts := []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", text},
+		{"text/html; charset=UTF-8", html},
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for _, want := range parts {
+		p, err := mr.NextRawPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Header.Get("Content-Type") != want.contentType || p.Header.Get("Content-Transfer-Encoding") != "base64" {
+			t.Error("分段头错误", p.Header)
+		}
+		raw, _ := io.ReadAll(p)
+		for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\r\n") {
+			if len(line) > 76 {
+				t.Error("base64每行不能超过76个字符", len(line))
+			}
+		}
+		content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+		if err != nil || string(content) != want.content {
+			t.Errorf("%s内容错误：%v", want.contentType, err)
+		}
+	}
+	if _, err = mr.NextRawPart(); err != io.EOF {
+		t.Error("只应包含纯文本和html两部分", err)
+	}
+}
+
+func TestEmailSend(t *testing.T) {
+	msg := newTestMessage(EventReleaseSuccess)
+	//没有收件人时不发送
+	e := NewEmail(&global.EmailConfig{Host: "127.0.0.1", Port: 1}, 0, nil)
+	if err := e.Send(msg); err != nil {
+		t.Error("没有收件人时不应发送", err)
+	}
+	e = NewEmail(&global.EmailConfig{Host: "127.0.0.1", Port: 1, From: "invalid"}, 0, []string{"alice@example.com"})
+	if err := e.Send(msg); err == nil || !strings.Contains(err.Error(), "email from") {
+		t.Error("发件人格式错误时应报错", err)
+	}
+}
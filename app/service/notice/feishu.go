@@ -0,0 +1,62 @@
+package notice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Feishu 飞书/Lark自定义机器人，开启签名校验时需要设置secret
+type Feishu struct {
+	client  *http.Client
+	webhook string
+	secret  string
+}
+
+func NewFeishu(client *http.Client, webhook, secret string) *Feishu {
+	return &Feishu{client: client, webhook: webhook, secret: secret}
+}
+
+func (f *Feishu) Send(msg *Message) error {
+	text, err := msg.Markdown()
+	if err != nil {
+		return err
+	}
+	data := map[string]any{
+		"msg_type": "interactive",
+		"card": map[string]any{
+			"header": map[string]any{
+				"title": map[string]string{"tag": "plain_text", "content": msg.Title},
+			},
+			"elements": []map[string]string{
+				{"tag": "markdown", "content": text},
+			},
+		},
+	}
+	if f.secret != "" {
+		timestamp := time.Now().Unix()
+		data["timestamp"] = strconv.FormatInt(timestamp, 10)
+		data["sign"] = f.sign(timestamp)
+	}
+	result := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{}
+	if err = postJson(f.client, f.webhook, data, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return ErrNotice.New("feishu: %d %s", result.Code, result.Msg)
+	}
+	return nil
+}
+
+// sign 以timestamp+"\n"+secret为密钥，对空字符串做HmacSHA256后base64
+func (f *Feishu) sign(timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(fmt.Sprintf("%d\n%s", timestamp, f.secret)))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -1,10 +1,223 @@
 package notice
 
-type Config struct {
-	Dingtalk DingtalkConfig
-	Email    EmailConfig
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/zeebo/errs"
+	"go-walle/app/global"
+	"go-walle/app/internal/constants"
+	"go-walle/app/model"
+	"go-walle/app/model/field"
+	"go-walle/app/pkg/safehttp"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var ErrNotice = errs.Class("notice")
+
+type Event string
+
+const (
+	EventTaskCreate     Event = "task_create"     //新建上线单
+	EventTaskWaiting    Event = "task_waiting"    //上线单等待审核
+	EventTaskAudit      Event = "task_audit"      //审核通过
+	EventTaskReject     Event = "task_reject"     //审核拒绝
+	EventReleaseStart   Event = "release_start"   //开始发布
+	EventReleaseSuccess Event = "release_success" //发布成功
+	EventReleaseFail    Event = "release_fail"    //发布失败
+)
+
+const (
+	TypeDingtalk = "dingtalk"
+	TypeFeishu   = "feishu"
+	TypeWecom    = "wecom"
+)
+
+// Message 通知消息内容
+type Message struct {
+	Event    Event
+	Title    string
+	Task     *model.Task
+	Operator *model.User
+	Link     string
 }
 
 type Notice interface {
-	Send() error
+	Send(msg *Message) error
+}
+
+var (
+	service     *Service
+	onceService sync.Once
+)
+
+type Service struct {
+	log    *zap.Logger
+	db     *gorm.DB
+	config *global.NoticeConfig
+	client *http.Client
+}
+
+func NewService(log *zap.Logger, db *gorm.DB, conf *global.NoticeConfig) *Service {
+	onceService.Do(func() {
+		service = &Service{
+			log:    log.Named("notice"),
+			db:     db,
+			config: conf,
+			client: safehttp.NewClient(conf.Timeout),
+		}
+		if conf.AllowPrivate {
+			service.client = &http.Client{Timeout: conf.Timeout}
+		}
+	})
+	return service
+}
+
+// Notify 异步发送上线单事件通知，operatorId为触发该事件的用户
+func (srv *Service) Notify(event Event, taskId, operatorId int64) {
+	go func() {
+		if err := srv.notify(event, taskId, operatorId); err != nil {
+			srv.log.Error("发送通知失败", zap.String("event", string(event)), zap.Int64("task_id", taskId), zap.Error(err))
+		}
+	}()
+}
+
+func (srv *Service) notify(event Event, taskId, operatorId int64) error {
+	msg, err := srv.message(event, taskId, operatorId)
+	if err != nil {
+		return err
+	}
+	return send(srv.notices(msg), msg)
+}
+
+// send 并发发送到所有通知渠道，webhook失败后的重试等待不影响其他渠道
+func send(notices []Notice, msg *Message) error {
+	results := make([]error, len(notices))
+	wg := sync.WaitGroup{}
+	for i, n := range notices {
+		wg.Add(1)
+		go func(i int, n Notice) {
+			defer wg.Done()
+			results[i] = n.Send(msg)
+		}(i, n)
+	}
+	wg.Wait()
+	group := errs.Group{}
+	group.Add(results...)
+	return group.Err()
+}
+
+func (srv *Service) message(event Event, taskId, operatorId int64) (*Message, error) {
+	task := &model.Task{}
+	err := srv.db.Preload("Project").Preload("Environment").Preload("User").First(task, taskId).Error
+	if err != nil {
+		return nil, ErrNotice.Wrap(err)
+	}
+	if len(task.ServerIds) > 0 {
+		if err = srv.db.Find(&task.Servers, []int64(task.ServerIds)).Error; err != nil {
+			return nil, ErrNotice.Wrap(err)
+		}
+	}
+	msg := &Message{
+		Event: event,
+		Title: fmt.Sprintf("[%s]%s", task.Project.Name, eventTitles[event]),
+		Task:  task,
+	}
+	if operatorId != 0 {
+		operator := &model.User{}
+		if err = srv.db.First(operator, operatorId).Error; err == nil {
+			msg.Operator = operator
+		}
+	}
+	if srv.config.Url != "" {
+		msg.Link = fmt.Sprintf("%s/#/deploy/release/%d", srv.config.Url, task.ID)
+	}
+	return msg, nil
+}
+
+// notices 获取该上线单需要发送的所有通知渠道
+func (srv *Service) notices(msg *Message) []Notice {
+	res := make([]Notice, 0)
+	project := msg.Task.Project
+	if project.NoticeHook != "" {
+		switch project.NoticeType {
+		case TypeDingtalk:
+			res = append(res, NewDingtalk(srv.client, project.NoticeHook, project.NoticeSecret.String()))
+		case TypeFeishu:
+			res = append(res, NewFeishu(srv.client, project.NoticeHook, project.NoticeSecret.String()))
+		case TypeWecom:
+			res = append(res, NewWecom(srv.client, project.NoticeHook))
+		}
+	}
+	if srv.config.Email.Host != "" {
+		to, err := srv.emailRecipients(msg)
+		if err != nil {
+			srv.log.Error("获取邮件收件人失败", zap.Int64("task_id", msg.Task.ID), zap.Error(err))
+		} else {
+			res = append(res, NewEmail(&srv.config.Email, srv.config.Timeout, to))
+		}
+	}
+	hooks, err := srv.webhooks(msg)
+	if err != nil {
+		srv.log.Error("获取webhook失败", zap.Int64("task_id", msg.Task.ID), zap.Error(err))
+	}
+	for _, hook := range hooks {
+		res = append(res, NewWebhook(srv, hook))
+	}
+	return res
+}
+
+// emailRecipients 邮件收件人：提交人始终接收，待审核时通知空间内所有审核人，发布相关事件通知空间所有成员，并排除退订的用户
+func (srv *Service) emailRecipients(msg *Message) ([]string, error) {
+	userIds := []int64{msg.Task.UserId}
+	members := make([]int64, 0)
+	_db := srv.db.Model(&model.Member{}).Where("space_id = ?", msg.Task.SpaceId)
+	switch msg.Event {
+	case EventTaskWaiting:
+		_db = _db.Where("role in ?", []constants.Role{constants.RoleOwner, constants.RoleMaster})
+		fallthrough
+	case EventReleaseStart, EventReleaseSuccess, EventReleaseFail:
+		if err := _db.Pluck("user_id", &members).Error; err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, members...)
+	}
+	emails := make([]string, 0)
+	err := srv.db.Model(&model.User{}).
+		Where("id in ? and status = ?", userIds, field.StatusEnable).
+		Where("id not in (?)", srv.db.Model(&model.NoticeSubscription{}).
+			Select("user_id").
+			Where("event = ? and email = ?", msg.Event, field.StatusDisable)).
+		Pluck("email", &emails).Error
+	return emails, err
+}
+
+// postJson 发送json请求，并解析返回结果
+func postJson(client *http.Client, url string, data any, result any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ErrNotice.New("http status %d: %s", resp.StatusCode, respBody)
+	}
+	if result == nil {
+		return nil
+	}
+	return ErrNotice.Wrap(json.Unmarshal(respBody, result))
 }
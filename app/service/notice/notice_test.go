@@ -0,0 +1,60 @@
+package notice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type noticeFunc func(msg *Message) error
+
+func (f noticeFunc) Send(msg *Message) error {
+	return f(msg)
+}
+
+func TestSend(t *testing.T) {
+	msg := &Message{Event: EventReleaseSuccess}
+	sent := make(chan string, 3)
+	blocked := make(chan struct{})
+	notices := []Notice{
+		//重试中的webhook
+		noticeFunc(func(msg *Message) error {
+			<-blocked
+			return errors.New("webhook failed")
+		}),
+		noticeFunc(func(msg *Message) error {
+			sent <- "dingtalk"
+			return nil
+		}),
+		noticeFunc(func(msg *Message) error {
+			sent <- "email"
+			return errors.New("email failed")
+		}),
+	}
+	done := make(chan error)
+	go func() {
+		done <- send(notices, msg)
+	}()
+	//其他渠道不等待重试中的webhook
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+			t.Fatal("其他渠道被阻塞")
+		}
+	}
+	select {
+	case <-done:
+		t.Fatal("应等待所有渠道发送完成")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(blocked)
+	err := <-done
+	if err == nil || !strings.Contains(err.Error(), "webhook failed") || !strings.Contains(err.Error(), "email failed") {
+		t.Error("应返回所有渠道的错误", err)
+	}
+	if err = send(nil, msg); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,62 @@
+package notice
+
+import (
+	"go-walle/app/model/field"
+	"go-walle/app/pkg/db"
+)
+
+type SubscribeReq struct {
+	UserId int64        `json:"-" binding:"required,gt=0"`
+	Event  Event        `json:"event" binding:"required"`
+	Email  field.Status `json:"email" binding:"required,status"`
+}
+
+type SubscriptionItem struct {
+	Event Event        `json:"event"`
+	Title string       `json:"title"`
+	Email field.Status `json:"email"`
+}
+
+type WebhookCreateReq struct {
+	SpaceId   int64        `json:"-" binding:"required,gt=0"`
+	ProjectId int64        `json:"project_id" binding:"omitempty,gte=0"`
+	Name      string       `json:"name" binding:"required,max=100"`
+	Url       string       `json:"url" binding:"required,url,max=500"`
+	Secret    string       `json:"secret" binding:"omitempty,max=200"`
+	Events    []string     `json:"events" binding:"omitempty,unique"`
+	Status    field.Status `json:"status" binding:"required,status"`
+}
+
+type WebhookUpdateReq struct {
+	SpaceId     int64        `json:"-" binding:"required,gt=0"`
+	ID          int64        `json:"id" binding:"required,gt=0"`
+	ProjectId   int64        `json:"project_id" binding:"omitempty,gte=0"`
+	Name        string       `json:"name" binding:"required,max=100"`
+	Url         string       `json:"url" binding:"required,url,max=500"`
+	Secret      string       `json:"secret" binding:"omitempty,max=200"`
+	SecretClear bool         `json:"secret_clear" binding:"omitempty"` //清空签名密钥
+	Events      []string     `json:"events" binding:"omitempty,unique"`
+	Status      field.Status `json:"status" binding:"required,status"`
+}
+
+func (r *WebhookUpdateReq) Fields() []string {
+	fields := []string{"project_id", "name", "url", "events", "status"}
+	//签名密钥不会返回给前端，为空时保持原值
+	if r.Secret != "" || r.SecretClear {
+		fields = append(fields, "secret")
+	}
+	return fields
+}
+
+type WebhookListReq struct {
+	SpaceId   int64 `json:"-" binding:"required,gt=0"`
+	ProjectId int64 `form:"project_id" binding:"omitempty,gt=0"`
+	db.Paginator
+}
+
+type DeliveryListReq struct {
+	SpaceId   int64 `json:"-" binding:"required,gt=0"`
+	WebhookId int64 `json:"-" binding:"required,gt=0"`
+	TaskId    int64 `form:"task_id" binding:"omitempty,gt=0"`
+	db.Paginator
+}
@@ -0,0 +1,55 @@
+package notice
+
+import (
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/model/field"
+	"gorm.io/gorm/clause"
+)
+
+// events 所有支持订阅的事件，按顺序展示
+var events = []Event{
+	EventTaskCreate,
+	EventTaskWaiting,
+	EventTaskAudit,
+	EventTaskReject,
+	EventReleaseStart,
+	EventReleaseSuccess,
+	EventReleaseFail,
+}
+
+// Subscriptions 获取用户所有事件的订阅情况，未设置的默认订阅
+func (srv *Service) Subscriptions(userId int64) ([]*SubscriptionItem, error) {
+	list := make([]*model.NoticeSubscription, 0)
+	if err := srv.db.Where("user_id = ?", userId).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	settings := make(map[string]field.Status)
+	for _, v := range list {
+		settings[v.Event] = v.Email
+	}
+	res := make([]*SubscriptionItem, 0, len(events))
+	for _, e := range events {
+		item := &SubscriptionItem{Event: e, Title: eventTitles[e], Email: field.StatusEnable}
+		if v, ok := settings[string(e)]; ok {
+			item.Email = v
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
+// Subscribe 订阅或者退订某个事件
+func (srv *Service) Subscribe(params *SubscribeReq) error {
+	if _, ok := eventTitles[params.Event]; !ok {
+		return errcode.ErrInvalidParams.New("不支持的通知事件：%s", params.Event)
+	}
+	return srv.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "event"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "updated_at"}),
+	}).Create(&model.NoticeSubscription{
+		UserId: params.UserId,
+		Event:  string(params.Event),
+		Email:  params.Email,
+	}).Error
+}
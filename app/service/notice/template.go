@@ -0,0 +1,129 @@
+package notice
+
+import (
+	"bytes"
+	htmlTpl "html/template"
+	"text/template"
+)
+
+var eventTitles = map[Event]string{
+	EventTaskCreate:     "新建上线单",
+	EventTaskWaiting:    "上线单等待审核",
+	EventTaskAudit:      "上线单审核通过",
+	EventTaskReject:     "上线单审核拒绝",
+	EventReleaseStart:   "开始发布",
+	EventReleaseSuccess: "发布成功",
+	EventReleaseFail:    "发布失败",
+}
+
+var templateFuncs = template.FuncMap{
+	"short": func(s string) string {
+		if len(s) > 8 {
+			return s[:8]
+		}
+		return s
+	},
+}
+
+// markdownTemplate 机器人markdown消息模版
+var markdownTemplate = template.Must(template.New("markdown").Funcs(templateFuncs).Parse(`### {{.Title}}
+- 项目：{{.Task.Project.Name}}
+- 环境：{{.Task.Environment.Name}}
+- 上线单：{{.Task.Name}}
+{{- if .Task.Tag}}
+- 版本：{{.Task.Tag}}
+{{- else}}
+- 版本：{{.Task.Branch}}@{{short .Task.CommitId}}
+{{- end}}
+- 提交人：{{.Task.User.Username}}
+{{- if .Operator}}
+- 操作人：{{.Operator.Username}}
+{{- end}}
+{{- if and (eq .Event "release_fail") .Task.LastError}}
+- 错误：{{.Task.LastError}}
+{{- end}}
+{{- if .Link}}
+
+[查看详情]({{.Link}})
+{{- end}}
+`))
+
+// Markdown 生成markdown格式的消息内容
+func (msg *Message) Markdown() (string, error) {
+	buf := bytes.Buffer{}
+	if err := markdownTemplate.Execute(&buf, msg); err != nil {
+		return "", ErrNotice.Wrap(err)
+	}
+	return buf.String(), nil
+}
+
+// textTemplate 纯文本邮件模版
+var textTemplate = template.Must(template.New("text").Funcs(templateFuncs).Parse(`{{.Title}}
+
+项目：{{.Task.Project.Name}}
+环境：{{.Task.Environment.Name}}
+上线单：{{.Task.Name}}
+{{- if .Task.Tag}}
+版本：{{.Task.Tag}}
+{{- else}}
+版本：{{.Task.Branch}}@{{short .Task.CommitId}}
+{{- end}}
+提交人：{{.Task.User.Username}}
+{{- if .Operator}}
+操作人：{{.Operator.Username}}
+{{- end}}
+{{- if and (eq .Event "release_fail") .Task.LastError}}
+错误：{{.Task.LastError}}
+{{- end}}
+{{- if .Link}}
+
+{{if eq .Event "task_waiting"}}请审核：{{else}}查看详情：{{end}}{{.Link}}
+{{- end}}
+`))
+
+// htmlTemplate html邮件模版
+var htmlTemplate = htmlTpl.Must(htmlTpl.New("html").Funcs(htmlTpl.FuncMap(templateFuncs)).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family:Arial,sans-serif;font-size:14px;color:#333;">
+<h3>{{.Title}}</h3>
+<table cellpadding="4">
+<tr><td>项目</td><td>{{.Task.Project.Name}}</td></tr>
+<tr><td>环境</td><td>{{.Task.Environment.Name}}</td></tr>
+<tr><td>上线单</td><td>{{.Task.Name}}</td></tr>
+{{- if .Task.Tag}}
+<tr><td>版本</td><td>{{.Task.Tag}}</td></tr>
+{{- else}}
+<tr><td>版本</td><td>{{.Task.Branch}}@{{short .Task.CommitId}}</td></tr>
+{{- end}}
+<tr><td>提交人</td><td>{{.Task.User.Username}}</td></tr>
+{{- if .Operator}}
+<tr><td>操作人</td><td>{{.Operator.Username}}</td></tr>
+{{- end}}
+{{- if and (eq .Event "release_fail") .Task.LastError}}
+<tr><td>错误</td><td style="color:#f5222d;">{{.Task.LastError}}</td></tr>
+{{- end}}
+</table>
+{{- if .Link}}
+<p><a href="{{.Link}}">{{if eq .Event "task_waiting"}}前往审核{{else}}查看详情{{end}}</a></p>
+{{- end}}
+</body>
+</html>
+`))
+
+// Text 生成纯文本格式的消息内容
+func (msg *Message) Text() (string, error) {
+	buf := bytes.Buffer{}
+	if err := textTemplate.Execute(&buf, msg); err != nil {
+		return "", ErrNotice.Wrap(err)
+	}
+	return buf.String(), nil
+}
+
+// Html 生成html格式的消息内容
+func (msg *Message) Html() (string, error) {
+	buf := bytes.Buffer{}
+	if err := htmlTemplate.Execute(&buf, msg); err != nil {
+		return "", ErrNotice.Wrap(err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,380 @@
+package notice
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/model/field"
+	"go-walle/app/service/common"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	WebhookEventHeader     = "X-Walle-Event"
+	WebhookDeliveryHeader  = "X-Walle-Delivery"
+	WebhookSignatureHeader = "X-Walle-Signature-256"
+
+	//响应内容最多记录的长度
+	maxWebhookResponse = 2000
+)
+
+// WebhookPayload webhook推送内容
+type WebhookPayload struct {
+	Event       Event              `json:"event"`
+	Timestamp   int64              `json:"timestamp"`
+	Task        WebhookTask        `json:"task"`
+	Project     WebhookProject     `json:"project"`
+	Environment WebhookEnvironment `json:"environment"`
+	Servers     []WebhookServer    `json:"servers"`
+	Operator    *WebhookUser       `json:"operator"`
+	Timing      WebhookTiming      `json:"timing"`
+	Link        string             `json:"link"`
+}
+
+type WebhookTask struct {
+	ID          int64        `json:"id"`
+	Name        string       `json:"name"`
+	Status      field.Status `json:"status"`
+	Branch      string       `json:"branch"`
+	Tag         string       `json:"tag"`
+	CommitId    string       `json:"commit_id"`
+	Version     string       `json:"version"`
+	PrevVersion string       `json:"prev_version"`
+	IsRollback  int          `json:"is_rollback"`
+	LastError   string       `json:"last_error"`
+	User        WebhookUser  `json:"user"`
+}
+
+type WebhookProject struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	RepoUrl string `json:"repo_url"`
+}
+
+type WebhookEnvironment struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebhookServer struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type WebhookUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type WebhookTiming struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	//发布耗时，单位秒
+	Duration int64 `json:"duration"`
+}
+
+// Webhook 推送签名的json数据到用户配置的地址
+type Webhook struct {
+	srv  *Service
+	hook *model.Webhook
+}
+
+func NewWebhook(srv *Service, hook *model.Webhook) *Webhook {
+	return &Webhook{srv: srv, hook: hook}
+}
+
+func (w *Webhook) Send(msg *Message) error {
+	payload, err := json.Marshal(w.srv.webhookPayload(msg))
+	if err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	delivery := &model.WebhookDelivery{
+		WebhookId: w.hook.ID,
+		TaskId:    msg.Task.ID,
+		Event:     string(msg.Event),
+		Payload:   string(payload),
+	}
+	if err = w.srv.db.Create(delivery).Error; err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	return w.srv.deliver(w.hook, delivery, w.srv.config.Webhook.Retries)
+}
+
+// WebhookSign 使用secret对推送内容进行HMAC-SHA256签名
+func WebhookSign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (srv *Service) webhookPayload(msg *Message) *WebhookPayload {
+	task := msg.Task
+	payload := &WebhookPayload{
+		Event:     msg.Event,
+		Timestamp: time.Now().Unix(),
+		Task: WebhookTask{
+			ID:          task.ID,
+			Name:        task.Name,
+			Status:      task.Status,
+			Branch:      task.Branch,
+			Tag:         task.Tag,
+			CommitId:    task.CommitId,
+			Version:     task.Version,
+			PrevVersion: task.PrevVersion,
+			IsRollback:  task.IsRollback,
+			LastError:   task.LastError,
+			User:        WebhookUser{ID: task.User.ID, Username: task.User.Username},
+		},
+		Project:     WebhookProject{ID: task.Project.ID, Name: task.Project.Name, RepoUrl: task.Project.RepoUrl},
+		Environment: WebhookEnvironment{ID: task.Environment.ID, Name: task.Environment.Name},
+		Servers:     make([]WebhookServer, 0, len(task.Servers)),
+		Timing: WebhookTiming{
+			CreatedAt:  task.CreatedAt,
+			StartedAt:  task.StartedAt,
+			FinishedAt: task.FinishedAt,
+		},
+		Link: msg.Link,
+	}
+	for _, s := range task.Servers {
+		payload.Servers = append(payload.Servers, WebhookServer{ID: s.ID, Name: s.Name, Host: s.Host, Port: s.Port})
+	}
+	if msg.Operator != nil {
+		payload.Operator = &WebhookUser{ID: msg.Operator.ID, Username: msg.Operator.Username}
+	}
+	if task.StartedAt != nil && task.FinishedAt != nil {
+		payload.Timing.Duration = int64(task.FinishedAt.Sub(*task.StartedAt).Seconds())
+	}
+	return payload
+}
+
+// webhooks 获取上线单对应的所有启用的webhook
+func (srv *Service) webhooks(msg *Message) ([]*model.Webhook, error) {
+	list := make([]*model.Webhook, 0)
+	err := srv.db.Where("space_id = ? and project_id in ? and status = ?",
+		msg.Task.SpaceId, []int64{0, msg.Task.ProjectId}, field.StatusEnable).Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*model.Webhook, 0, len(list))
+	for _, v := range list {
+		if len(v.Events) == 0 {
+			res = append(res, v)
+			continue
+		}
+		for _, e := range v.Events {
+			if e == string(msg.Event) {
+				res = append(res, v)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// deliver 推送并记录结果，失败后按照指数退避重试retries次
+func (srv *Service) deliver(hook *model.Webhook, delivery *model.WebhookDelivery, retries int) error {
+	backoff := srv.config.Webhook.Backoff
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err = srv.post(hook, delivery)
+		delivery.Attempts++
+		delivery.Status = field.StatusEnable
+		delivery.Error = ""
+		if err != nil {
+			delivery.Status = field.StatusDisable
+			delivery.Error = err.Error()
+		}
+		dbErr := srv.db.Select("status", "status_code", "response", "error", "attempts", "duration").Updates(delivery).Error
+		if dbErr != nil {
+			srv.log.Error("更新webhook推送记录失败", zap.Int64("delivery_id", delivery.ID), zap.Error(dbErr))
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (srv *Service) post(hook *model.Webhook, delivery *model.WebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return ErrNotice.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-walle")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	if hook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, WebhookSign(hook.Secret.String(), body))
+	}
+	start := time.Now()
+	resp, err := srv.client.Do(req)
+	delivery.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.StatusCode = 0
+		delivery.Response = ""
+		return ErrNotice.Wrap(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse))
+	delivery.StatusCode = resp.StatusCode
+	delivery.Response = string(respBody)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return ErrNotice.New("http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (srv *Service) WebhookList(params *WebhookListReq) (total int64, list []*model.Webhook, err error) {
+	_db := srv.db.Model(&model.Webhook{}).Where("space_id = ?", params.SpaceId)
+	if params.ProjectId > 0 {
+		_db = _db.Where("project_id = ?", params.ProjectId)
+	}
+	err = _db.Count(&total).Error
+	if err != nil || total == 0 {
+		return
+	}
+	err = _db.Preload("Project").Scopes(params.PageQuery()).Order("id desc").Find(&list).Error
+	return
+}
+
+func (srv *Service) WebhookCreate(params *WebhookCreateReq) error {
+	if err := srv.checkWebhook(params.SpaceId, params.ProjectId, params.Events); err != nil {
+		return err
+	}
+	return srv.db.Create(&model.Webhook{
+		SpaceId:   params.SpaceId,
+		ProjectId: params.ProjectId,
+		Name:      params.Name,
+		Url:       params.Url,
+		Secret:    field.Secret(params.Secret),
+		Events:    params.Events,
+		Status:    params.Status,
+	}).Error
+}
+
+func (srv *Service) WebhookUpdate(params *WebhookUpdateReq) error {
+	if err := srv.checkWebhook(params.SpaceId, params.ProjectId, params.Events); err != nil {
+		return err
+	}
+	m := &model.Webhook{
+		ProjectId: params.ProjectId,
+		Name:      params.Name,
+		Url:       params.Url,
+		Secret:    field.Secret(params.Secret),
+		Events:    params.Events,
+		Status:    params.Status,
+	}
+	res := srv.db.Select(params.Fields()).Where("space_id = ? and id = ?", params.SpaceId, params.ID).Updates(m)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errcode.ErrNotFound
+	}
+	return nil
+}
+
+func (srv *Service) WebhookDelete(spaceWith *common.SpaceWithId) error {
+	res := srv.db.Where("space_id = ? and id = ?", spaceWith.SpaceId, spaceWith.ID).Delete(&model.Webhook{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errcode.ErrNotFound
+	}
+	return nil
+}
+
+// Deliveries webhook的推送记录
+func (srv *Service) Deliveries(params *DeliveryListReq) (total int64, list []*model.WebhookDelivery, err error) {
+	if _, err = srv.findWebhook(params.SpaceId, params.WebhookId); err != nil {
+		return
+	}
+	_db := srv.db.Model(&model.WebhookDelivery{}).Where("webhook_id = ?", params.WebhookId)
+	if params.TaskId > 0 {
+		_db = _db.Where("task_id = ?", params.TaskId)
+	}
+	err = _db.Count(&total).Error
+	if err != nil || total == 0 {
+		return
+	}
+	err = _db.Scopes(params.PageQuery()).Order("id desc").Find(&list).Error
+	return
+}
+
+// Replay 使用原推送内容重新推送一次，生成新的推送记录
+func (srv *Service) Replay(spaceWith *common.SpaceWithId) (*model.WebhookDelivery, error) {
+	old := &model.WebhookDelivery{}
+	if err := srv.db.First(old, spaceWith.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errcode.ErrNotFound
+		}
+		return nil, err
+	}
+	hook, err := srv.findWebhook(spaceWith.SpaceId, old.WebhookId)
+	if err != nil {
+		return nil, err
+	}
+	delivery := &model.WebhookDelivery{
+		WebhookId: old.WebhookId,
+		TaskId:    old.TaskId,
+		Event:     old.Event,
+		Payload:   old.Payload,
+	}
+	if err = srv.db.Create(delivery).Error; err != nil {
+		return nil, err
+	}
+	//重放时不重试，直接返回本次推送结果
+	_ = srv.deliver(hook, delivery, 0)
+	return delivery, nil
+}
+
+func (srv *Service) findWebhook(spaceId, id int64) (*model.Webhook, error) {
+	hook := &model.Webhook{}
+	err := srv.db.Where("space_id = ? and id = ?", spaceId, id).First(hook).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errcode.ErrNotFound
+	}
+	return hook, err
+}
+
+func (srv *Service) checkWebhook(spaceId, projectId int64, events []string) error {
+	for _, e := range events {
+		if _, ok := eventTitles[Event(e)]; !ok {
+			return errcode.ErrInvalidParams.New("不支持的通知事件：%s", e)
+		}
+	}
+	if projectId == 0 {
+		return nil
+	}
+	var total int64
+	err := srv.db.Model(&model.Project{}).Where("space_id = ? and id = ?", spaceId, projectId).Count(&total).Error
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return errcode.ErrInvalidParams.New("项目不存在")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package notice
+
+import (
+	"encoding/json"
+	"go-walle/app/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookSign(t *testing.T) {
+	sign := WebhookSign("secret", []byte(`{"event":"release_success"}`))
+	if sign != "sha256=6a606bc6ff37eb0e5d0dfd265d60a8c1f2e137b2aa7112d1353fe39f64ede873" {
+		t.Error("签名错误", sign)
+	}
+}
+
+func TestWebhookPost(t *testing.T) {
+	payload := `{"event":"release_success"}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(WebhookEventHeader) != "release_success" || r.Header.Get(WebhookDeliveryHeader) != "7" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get(WebhookSignatureHeader) != WebhookSign("secret", []byte(payload)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	srv := &Service{client: ts.Client()}
+	delivery := &model.WebhookDelivery{ID: 7, Event: "release_success", Payload: payload}
+	if err := srv.post(&model.Webhook{Url: ts.URL, Secret: "secret"}, delivery); err != nil {
+		t.Fatal(err)
+	}
+	if delivery.StatusCode != http.StatusOK || delivery.Response != "ok" {
+		t.Error("推送结果记录错误", delivery.StatusCode, delivery.Response)
+	}
+	if err := srv.post(&model.Webhook{Url: ts.URL, Secret: "other"}, delivery); err == nil || delivery.StatusCode != http.StatusUnauthorized {
+		t.Error("错误签名推送成功", delivery.StatusCode)
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	data, _ := json.Marshal(&model.Webhook{Secret: "secret"})
+	if strings.Contains(string(data), "secret") {
+		t.Error("签名密钥不应返回", string(data))
+	}
+	has := func(fields []string) bool {
+		for _, f := range fields {
+			if f == "secret" {
+				return true
+			}
+		}
+		return false
+	}
+	if has((&WebhookUpdateReq{}).Fields()) {
+		t.Error("密钥为空时应保持原值")
+	}
+	if !has((&WebhookUpdateReq{Secret: "new"}).Fields()) || !has((&WebhookUpdateReq{SecretClear: true}).Fields()) {
+		t.Error("应更新密钥")
+	}
+}
@@ -0,0 +1,37 @@
+package notice
+
+import (
+	"net/http"
+)
+
+// Wecom 企业微信群机器人，webhook地址中的key即为凭证，不支持签名
+type Wecom struct {
+	client  *http.Client
+	webhook string
+}
+
+func NewWecom(client *http.Client, webhook string) *Wecom {
+	return &Wecom{client: client, webhook: webhook}
+}
+
+func (w *Wecom) Send(msg *Message) error {
+	text, err := msg.Markdown()
+	if err != nil {
+		return err
+	}
+	data := map[string]any{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"content": text},
+	}
+	result := struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}{}
+	if err = postJson(w.client, w.webhook, data, &result); err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		return ErrNotice.New("wecom: %d %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
@@ -1,68 +1,153 @@
 package project
 
-import "go-walle/app/pkg/db"
+import (
+	"go-walle/app/pkg/db"
+	"go-walle/app/pkg/repo"
+	"time"
+)
 
 type CreateReq struct {
 	SpaceId       int64  `json:"-" binding:"required,gt=0"`
 	Name          string `json:"name" binding:"required,max=50"`
 	EnvironmentId int64  `json:"environment_id" binding:"required,gt=0"`
-	RepoUrl       string `json:"repo_url" binding:"required,max=500"`
-	RepoType      string `json:"repo_type" binding:"required,max=20"`
+	RepoUrl       string `json:"repo_url" binding:"required_unless=RepoType artifact,max=500"`
+	RepoType      string `json:"repo_type" binding:"required,oneof=git svn artifact"`
 	RepoMode      string `json:"repo_mode" binding:"required,max=20"`
+	RepoAuthType  string `json:"repo_auth_type" binding:"omitempty,oneof=basic token ssh"`
+	RepoUsername  string `json:"repo_username" binding:"omitempty,max=100"`
+
+	RepoPassword   string `json:"repo_password" binding:"omitempty,max=300"`
+	RepoPrivateKey string `json:"repo_private_key" binding:"required_if=RepoAuthType ssh,max=10000"`
+	RepoPassphrase string `json:"repo_passphrase" binding:"omitempty,max=300"`
+	RepoSubmodules int8   `json:"repo_submodules" binding:"omitempty,oneof=0 1"`
+	RepoLfs        int8   `json:"repo_lfs" binding:"omitempty,oneof=0 1"`
+	RepoBackend    string `json:"repo_backend" binding:"omitempty,oneof=go-git cli"`
+	RepoSubDir     string `json:"repo_sub_dir" binding:"max=200"`
 
 	ServerIds      []int64 `json:"server_ids" binding:"required,unique,dive,gt=0"`
 	TargetRoot     string  `json:"target_root" binding:"required,max=100"`
 	TargetReleases string  `json:"target_releases" binding:"required,max=100"`
 	KeepVersionNum int     `json:"keep_version_num" binding:"required,gt=0"`
 
-	Excludes    string `json:"excludes" binding:"omitempty"`
-	IsInclude   int8   `json:"is_include" binding:"omitempty"`
-	TaskVars    string `json:"task_vars" binding:"omitempty"`
-	PrevDeploy  string `json:"prev_deploy" binding:"omitempty"`
-	PostDeploy  string `json:"post_deploy" binding:"omitempty"`
-	PrevRelease string `json:"prev_release" binding:"omitempty"`
-	PostRelease string `json:"post_release" binding:"omitempty"`
+	Excludes      string `json:"excludes" binding:"omitempty"`
+	IsInclude     int8   `json:"is_include" binding:"omitempty"`
+	ArtifactPaths string `json:"artifact_paths" binding:"omitempty,max=1000"`
+	TaskVars      string `json:"task_vars" binding:"omitempty"`
+	PrevDeploy    string `json:"prev_deploy" binding:"omitempty"`
+	PostDeploy    string `json:"post_deploy" binding:"omitempty"`
+	PrevRelease   string `json:"prev_release" binding:"omitempty"`
+	PostRelease   string `json:"post_release" binding:"omitempty"`
 
 	TaskAudit int8 `json:"task_audit" binding:"omitempty"`
 
+	HookSecret  string `json:"hook_secret" binding:"omitempty,max=100"`
+	HookRefs    string `json:"hook_refs" binding:"omitempty,max=1000"`
+	HookRelease int8   `json:"hook_release" binding:"omitempty"`
+
+	NoticeType   string `json:"notice_type" binding:"omitempty,oneof=dingtalk feishu wecom"`
+	NoticeHook   string `json:"notice_hook" binding:"omitempty,url,max=500"`
+	NoticeSecret string `json:"notice_secret" binding:"omitempty,max=200"`
+
 	Description string `json:"description" binding:"omitempty,max=500"`
 }
 
 type UpdateReq struct {
 	SpaceId int64 `json:"-" binding:"required,gt=0"`
+	UserId  int64 `json:"-" binding:"required,gt=0"`
 
 	ID            int64  `json:"id" validate:"required,gt=0"`
 	Name          string `json:"name" binding:"required,max=50"`
 	EnvironmentId int64  `json:"environment_id" binding:"required,gt=0"`
-	RepoUrl       string `json:"repo_url" binding:"required,max=500"`
-	RepoType      string `json:"repo_type" binding:"required,max=20"`
+	RepoUrl       string `json:"repo_url" binding:"required_unless=RepoType artifact,max=500"`
+	RepoType      string `json:"repo_type" binding:"required,oneof=git svn artifact"`
 	RepoMode      string `json:"repo_mode" binding:"required,max=20"`
+	RepoAuthType  string `json:"repo_auth_type" binding:"omitempty,oneof=basic token ssh"`
+	RepoUsername  string `json:"repo_username" binding:"omitempty,max=100"`
+
+	RepoPassword   string `json:"repo_password" binding:"omitempty,max=300"`
+	RepoPrivateKey string `json:"repo_private_key" binding:"omitempty,max=10000"`
+	RepoPassphrase string `json:"repo_passphrase" binding:"omitempty,max=300"`
+	RepoSubmodules int8   `json:"repo_submodules" binding:"omitempty,oneof=0 1"`
+	RepoLfs        int8   `json:"repo_lfs" binding:"omitempty,oneof=0 1"`
+	RepoBackend    string `json:"repo_backend" binding:"omitempty,oneof=go-git cli"`
+	RepoSubDir     string `json:"repo_sub_dir" binding:"max=200"`
 
 	ServerIds      []int64 `json:"server_ids" binding:"required,unique,dive,gt=0"`
 	TargetRoot     string  `json:"target_root" binding:"required,max=100"`
 	TargetReleases string  `json:"target_releases" binding:"required,max=100"`
 	KeepVersionNum int     `json:"keep_version_num" binding:"required,gt=0"`
 
-	Excludes    string `json:"excludes" binding:"omitempty"`
-	IsInclude   int8   `json:"is_include" binding:"omitempty"`
-	TaskVars    string `json:"task_vars" binding:"omitempty"`
-	PrevDeploy  string `json:"prev_deploy" binding:"omitempty"`
-	PostDeploy  string `json:"post_deploy" binding:"omitempty"`
-	PrevRelease string `json:"prev_release" binding:"omitempty"`
-	PostRelease string `json:"post_release" binding:"omitempty"`
+	Excludes      string `json:"excludes" binding:"omitempty"`
+	IsInclude     int8   `json:"is_include" binding:"omitempty"`
+	ArtifactPaths string `json:"artifact_paths" binding:"omitempty,max=1000"`
+	TaskVars      string `json:"task_vars" binding:"omitempty"`
+	PrevDeploy    string `json:"prev_deploy" binding:"omitempty"`
+	PostDeploy    string `json:"post_deploy" binding:"omitempty"`
+	PrevRelease   string `json:"prev_release" binding:"omitempty"`
+	PostRelease   string `json:"post_release" binding:"omitempty"`
 
 	TaskAudit int8 `json:"task_audit" binding:"omitempty"`
 
+	HookSecret      string `json:"hook_secret" binding:"omitempty,max=100"`
+	HookSecretClear bool   `json:"hook_secret_clear" binding:"omitempty"` //清空webhook签名密钥
+	HookRefs        string `json:"hook_refs" binding:"omitempty,max=1000"`
+	HookRelease     int8   `json:"hook_release" binding:"omitempty"`
+
+	NoticeType        string `json:"notice_type" binding:"omitempty,oneof=dingtalk feishu wecom"`
+	NoticeHook        string `json:"notice_hook" binding:"omitempty,url,max=500"`
+	NoticeSecret      string `json:"notice_secret" binding:"omitempty,max=200"`
+	NoticeSecretClear bool   `json:"notice_secret_clear" binding:"omitempty"` //清空通知机器人签名密钥
+
 	Description string `json:"description" binding:"omitempty,max=500"`
 }
 
 func (r *UpdateReq) Fields() []string {
-	return []string{
-		"name", "environment_id", "repo_url", "repo_type", "repo_mode",
+	fields := []string{
+		"name", "environment_id", "repo_url", "repo_type", "repo_mode", "repo_auth_type", "repo_username", "repo_submodules", "repo_lfs", "repo_backend", "repo_sub_dir",
 		"target_root", "target_releases", "keep_version_num",
-		"excludes", "is_include", "task_vars", "prev_deploy", "post_deploy", "prev_release", "post_release",
-		"task_audit", "description",
+		"excludes", "is_include", "artifact_paths", "task_vars", "prev_deploy", "post_deploy", "prev_release", "post_release",
+		"task_audit", "description", "hook_refs", "hook_release",
+		"notice_type", "notice_hook",
+	}
+	//仓库密钥不会返回给前端，为空时保持原值，不是当前授权方式使用的密钥清空
+	secrets, used := r.repoSecrets()
+	for k, v := range secrets {
+		if v != "" || !used[k] {
+			fields = append(fields, k)
+		}
+	}
+	//webhook签名密钥不会返回给前端，为空时保持原值
+	if r.HookSecret != "" || r.HookSecretClear {
+		fields = append(fields, "hook_secret")
+	}
+	//通知机器人签名密钥同样不返回，关闭通知时一并清空
+	if r.NoticeSecret != "" || r.NoticeSecretClear || r.NoticeType == "" {
+		fields = append(fields, "notice_secret")
 	}
+	return fields
+}
+
+// repoAuthSecrets 各授权方式使用的仓库密钥，使用全局配置时都不使用
+var repoAuthSecrets = map[repo.AuthType]map[string]bool{
+	repo.AuthBasic: {"repo_password": true},
+	repo.AuthToken: {"repo_password": true},
+	repo.AuthSsh:   {"repo_private_key": true, "repo_passphrase": true},
+}
+
+// repoSecrets 提交的仓库密钥，切换授权方式后不再使用的密钥为空
+func (r *UpdateReq) repoSecrets() (secrets map[string]string, used map[string]bool) {
+	used = repoAuthSecrets[repo.AuthType(r.RepoAuthType)]
+	secrets = map[string]string{
+		"repo_password":    r.RepoPassword,
+		"repo_private_key": r.RepoPrivateKey,
+		"repo_passphrase":  r.RepoPassphrase,
+	}
+	for k := range secrets {
+		if !used[k] {
+			secrets[k] = ""
+		}
+	}
+	return
 }
 
 type ListReq struct {
@@ -78,3 +163,9 @@ type DetectionMsg struct {
 	Error string `json:"error"`
 	Todo  string `json:"todo"`
 }
+
+// RefsRes 分支、标签或提交记录，均读取自本地镜像，FetchedAt为镜像最后同步时间，为空时还没有同步过
+type RefsRes[T any] struct {
+	Items     []T        `json:"items"`
+	FetchedAt *time.Time `json:"fetched_at"`
+}
@@ -0,0 +1,49 @@
+package project
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestUpdateReqRepoSecrets(t *testing.T) {
+	cases := []struct {
+		name   string
+		req    UpdateReq
+		fields string
+		values map[string]string
+	}{
+		{"使用全局配置时清空", UpdateReq{RepoPassword: "p", RepoPrivateKey: "k"},
+			"repo_passphrase,repo_password,repo_private_key", map[string]string{}},
+		{"basic为空时保留密码，清空私钥", UpdateReq{RepoAuthType: "basic"},
+			"repo_passphrase,repo_private_key", map[string]string{}},
+		{"basic修改密码，忽略提交的私钥", UpdateReq{RepoAuthType: "basic", RepoPassword: "p", RepoPrivateKey: "k", RepoPassphrase: "pp"},
+			"repo_passphrase,repo_password,repo_private_key", map[string]string{"repo_password": "p"}},
+		{"token修改token", UpdateReq{RepoAuthType: "token", RepoPassword: "t"},
+			"repo_passphrase,repo_password,repo_private_key", map[string]string{"repo_password": "t"}},
+		{"ssh为空时保留私钥，清空密码", UpdateReq{RepoAuthType: "ssh", RepoPassword: "p"},
+			"repo_password", map[string]string{}},
+		{"ssh只修改私钥密码", UpdateReq{RepoAuthType: "ssh", RepoPassphrase: "pp"},
+			"repo_passphrase,repo_password", map[string]string{"repo_passphrase": "pp"}},
+		{"ssh修改私钥", UpdateReq{RepoAuthType: "ssh", RepoPrivateKey: "k"},
+			"repo_password,repo_private_key", map[string]string{"repo_private_key": "k"}},
+	}
+	for _, c := range cases {
+		var fields []string
+		for _, f := range c.req.Fields() {
+			if strings.HasPrefix(f, "repo_pa") || f == "repo_private_key" {
+				fields = append(fields, f)
+			}
+		}
+		sort.Strings(fields)
+		if strings.Join(fields, ",") != c.fields {
+			t.Errorf("%s：更新字段%v", c.name, fields)
+		}
+		secrets, _ := c.req.repoSecrets()
+		for k, v := range secrets {
+			if v != c.values[k] {
+				t.Errorf("%s：%s=%q", c.name, k, v)
+			}
+		}
+	}
+}
@@ -3,14 +3,19 @@ package project
 import (
 	"errors"
 	"fmt"
+	"go-walle/app/internal/constants"
+	"go-walle/app/internal/errcode"
 	"go-walle/app/model"
 	"go-walle/app/model/field"
 	"go-walle/app/pkg/repo"
+	"go-walle/app/pkg/secret"
 	"go-walle/app/pkg/ssh"
 	"go-walle/app/service/common"
+	server2 "go-walle/app/service/server"
 	"gorm.io/gorm"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -60,22 +65,41 @@ func (srv *Service) Create(params *CreateReq) error {
 		EnvironmentId: params.EnvironmentId,
 		RepoUrl:       params.RepoUrl,
 		RepoMode:      params.RepoMode,
-		RepoType:      params.RepoType,
-		TaskAudit:     params.TaskAudit,
-		Description:   params.Description,
+		RepoAuthType:  params.RepoAuthType,
+		RepoUsername:  params.RepoUsername,
+
+		RepoPassword:   field.Secret(params.RepoPassword),
+		RepoPrivateKey: field.Secret(params.RepoPrivateKey),
+		RepoPassphrase: field.Secret(params.RepoPassphrase),
+		RepoSubmodules: params.RepoSubmodules,
+		RepoLfs:        params.RepoLfs,
+		RepoBackend:    params.RepoBackend,
+		RepoSubDir:     repo.CleanSubDir(params.RepoSubDir),
+		RepoType:       params.RepoType,
+		TaskAudit:      params.TaskAudit,
+		Description:    params.Description,
 
 		TargetRoot:     params.TargetRoot,
 		TargetReleases: params.TargetReleases,
 		KeepVersionNum: params.KeepVersionNum,
 
-		Excludes:    params.Excludes,
-		IsInclude:   params.IsInclude,
-		TaskVars:    params.TaskVars,
-		PrevDeploy:  params.PrevRelease,
-		PostDeploy:  params.PostDeploy,
-		PrevRelease: params.PrevRelease,
-		PostRelease: params.PostRelease,
-		Status:      field.StatusEnable,
+		Excludes:      params.Excludes,
+		IsInclude:     params.IsInclude,
+		ArtifactPaths: params.ArtifactPaths,
+		TaskVars:      params.TaskVars,
+		PrevDeploy:    params.PrevRelease,
+		PostDeploy:    params.PostDeploy,
+		PrevRelease:   params.PrevRelease,
+		PostRelease:   params.PostRelease,
+
+		HookSecret:  field.Secret(params.HookSecret),
+		HookRefs:    params.HookRefs,
+		HookRelease: params.HookRelease,
+
+		NoticeType:   params.NoticeType,
+		NoticeHook:   params.NoticeHook,
+		NoticeSecret: field.Secret(params.NoticeSecret),
+		Status:       field.StatusEnable,
 	}
 	servers := make([]model.Server, 0)
 	return srv.db.Transaction(func(tx *gorm.DB) error {
@@ -88,12 +112,48 @@ func (srv *Service) Create(params *CreateReq) error {
 	})
 }
 
+// RotateDeployKey 生成或重新生成项目部署密钥，返回新的公钥，需要重新添加到代码仓库。
+// 私钥加密存储，未配置secret.key时不能生成
+func (srv *Service) RotateDeployKey(spaceWithId *common.SpaceWithId) (string, error) {
+	if !secret.Enabled() {
+		return "", errcode.ErrInvalidParams.New("未配置secret.key，不能生成部署密钥")
+	}
+	m := model.Project{}
+	if err := srv.db.Where(spaceWithId).First(&m).Error; err != nil {
+		return "", err
+	}
+	publicKey, privateKey, err := repo.GenerateDeployKey(deployKeyComment(m.Name))
+	if err != nil {
+		return "", err
+	}
+	err = srv.db.Model(&m).Select("deploy_public_key", "deploy_private_key").Updates(&model.Project{
+		DeployPublicKey:  publicKey,
+		DeployPrivateKey: field.Secret(privateKey),
+	}).Error
+	return publicKey, err
+}
+
+func deployKeyComment(name string) string {
+	return "walle@" + strings.ReplaceAll(name, " ", "_")
+}
+
 func (srv *Service) Update(params *UpdateReq) error {
 	m := model.Project{}
 	err := srv.db.Where("space_id = ? and id = ?", params.SpaceId, params.ID).First(&m).Error
 	if err != nil {
 		return err
 	}
+	secrets, _ := params.repoSecrets()
+	//切换为ssh授权时需要设置私钥
+	if params.RepoAuthType == string(repo.AuthSsh) && secrets["repo_private_key"] == "" &&
+		(m.RepoAuthType != params.RepoAuthType || m.RepoPrivateKey == "") {
+		return errcode.ErrInvalidParams.New("请设置仓库私钥")
+	}
+	if m.EnvironmentId != params.EnvironmentId {
+		if err = srv.checkEnvironment(params, m.EnvironmentId); err != nil {
+			return err
+		}
+	}
 	m = model.Project{
 		ID:      params.ID,
 		SpaceId: params.SpaceId,
@@ -102,21 +162,40 @@ func (srv *Service) Update(params *UpdateReq) error {
 		EnvironmentId: params.EnvironmentId,
 		RepoUrl:       params.RepoUrl,
 		RepoMode:      params.RepoMode,
-		RepoType:      params.RepoType,
-		TaskAudit:     params.TaskAudit,
-		Description:   params.Description,
+		RepoAuthType:  params.RepoAuthType,
+		RepoUsername:  params.RepoUsername,
+
+		RepoPassword:   field.Secret(secrets["repo_password"]),
+		RepoPrivateKey: field.Secret(secrets["repo_private_key"]),
+		RepoPassphrase: field.Secret(secrets["repo_passphrase"]),
+		RepoSubmodules: params.RepoSubmodules,
+		RepoLfs:        params.RepoLfs,
+		RepoBackend:    params.RepoBackend,
+		RepoSubDir:     repo.CleanSubDir(params.RepoSubDir),
+		RepoType:       params.RepoType,
+		TaskAudit:      params.TaskAudit,
+		Description:    params.Description,
 
 		TargetRoot:     params.TargetRoot,
 		TargetReleases: params.TargetReleases,
 		KeepVersionNum: params.KeepVersionNum,
 
-		Excludes:    params.Excludes,
-		IsInclude:   params.IsInclude,
-		TaskVars:    params.TaskVars,
-		PrevDeploy:  params.PrevRelease,
-		PostDeploy:  params.PostDeploy,
-		PrevRelease: params.PrevRelease,
-		PostRelease: params.PostRelease,
+		Excludes:      params.Excludes,
+		IsInclude:     params.IsInclude,
+		ArtifactPaths: params.ArtifactPaths,
+		TaskVars:      params.TaskVars,
+		PrevDeploy:    params.PrevRelease,
+		PostDeploy:    params.PostDeploy,
+		PrevRelease:   params.PrevRelease,
+		PostRelease:   params.PostRelease,
+
+		HookSecret:  field.Secret(params.HookSecret),
+		HookRefs:    params.HookRefs,
+		HookRelease: params.HookRelease,
+
+		NoticeType:   params.NoticeType,
+		NoticeHook:   params.NoticeHook,
+		NoticeSecret: field.Secret(params.NoticeSecret),
 	}
 	return srv.db.Transaction(func(tx *gorm.DB) error {
 		servers := make([]model.Server, 0)
@@ -135,6 +214,39 @@ func (srv *Service) Update(params *UpdateReq) error {
 	})
 }
 
+// checkEnvironment 签名策略只能由空间所有者放宽，其他成员不能把项目移到签名策略更宽松的环境
+func (srv *Service) checkEnvironment(params *UpdateReq, oldEnvironmentId int64) error {
+	envs := make([]model.Environment, 0)
+	err := srv.db.Where("space_id = ? and id in ?", params.SpaceId, []int64{oldEnvironmentId, params.EnvironmentId}).Find(&envs).Error
+	if err != nil {
+		return err
+	}
+	var oldPolicy, newPolicy int8 = -1, -1
+	for _, env := range envs {
+		if env.ID == oldEnvironmentId {
+			oldPolicy = env.SignPolicy
+		}
+		if env.ID == params.EnvironmentId {
+			newPolicy = env.SignPolicy
+		}
+	}
+	if newPolicy < 0 {
+		return errcode.ErrInvalidParams.New("环境不存在")
+	}
+	if newPolicy >= oldPolicy || constants.IsSuperUser(params.UserId) {
+		return nil
+	}
+	member := model.Member{}
+	err = srv.db.Where("space_id = ? and user_id = ?", params.SpaceId, params.UserId).First(&member).Error
+	if err != nil {
+		return err
+	}
+	if constants.Role(member.Role).Level() < constants.RoleOwner.Level() {
+		return errcode.ErrInvalidParams.New("只有空间所有者可以把项目移到签名策略更宽松的环境")
+	}
+	return nil
+}
+
 func (srv *Service) Delete(spaceAndId *common.SpaceWithId) error {
 	return srv.db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Model(&model.Project{ID: spaceAndId.ID}).Association("Servers").Clear(); err != nil {
@@ -163,7 +275,7 @@ func (srv *Service) Detection(spaceWithId *common.SpaceWithId) (ret []*Detection
 	if err != nil {
 		return
 	}
-	_, err = srv.repo.New(repo.TypeRepo(project.RepoType), project.RepoUrl, strconv.Itoa(int(project.ID)))
+	_, err = srv.repo.New(repo.TypeRepo(project.RepoType), project.RepoUrl, strconv.Itoa(int(project.ID)), project.RepoOptions())
 	if err != nil {
 		ret = append(ret, &DetectionMsg{
 			Title: "代码clone失败",
@@ -197,7 +309,11 @@ func (srv *Service) Detection(spaceWithId *common.SpaceWithId) (ret []*Detection
 	for _, server := range project.Servers {
 		go func(server model.Server) {
 			defer wg.Done()
-			re, _err := srv.ssh.NewRemoteExec(ssh.ServerConfig{User: server.User, Port: server.Port, Host: server.Host})
+			sshConfig, _err := server2.SshConfig(srv.db, &server)
+			var re *ssh.RemoteExec
+			if _err == nil {
+				re, _err = srv.ssh.NewRemoteExec(sshConfig)
+			}
 			if _err != nil {
 				detectionMsgChan <- &DetectionMsg{
 					Title: "远程目标机器免密码登录失败",
@@ -246,41 +362,45 @@ func (srv *Service) Detection(spaceWithId *common.SpaceWithId) (ret []*Detection
 	return
 }
 
-func (srv *Service) GetBranches(spaceWithId *common.SpaceWithId) (res []repo.Branch, err error) {
-	var rep repo.Repo
-	rep, err = srv.getRepoBySpaceWithId(spaceWithId)
+func (srv *Service) GetBranches(spaceWithId *common.SpaceWithId) (res *RefsRes[repo.Branch], err error) {
+	rep, project, err := srv.getRepoBySpaceWithId(spaceWithId)
 	if err != nil {
 		return
 	}
-	return rep.Branches()
+	items, err := rep.Branches()
+	return newRefsRes(items, project), err
 }
 
-func (srv *Service) GetTags(spaceWithId *common.SpaceWithId) (res []repo.Tag, err error) {
-	var rep repo.Repo
-	rep, err = srv.getRepoBySpaceWithId(spaceWithId)
+func (srv *Service) GetTags(spaceWithId *common.SpaceWithId) (res *RefsRes[repo.Tag], err error) {
+	rep, project, err := srv.getRepoBySpaceWithId(spaceWithId)
 	if err != nil {
 		return
 	}
-	return rep.Tags()
+	items, err := rep.Tags()
+	return newRefsRes(items, project), err
 }
 
-func (srv *Service) GetCommits(spaceWithId *common.SpaceWithId, branch string) (res []repo.Commit, err error) {
-	var rep repo.Repo
-	rep, err = srv.getRepoBySpaceWithId(spaceWithId)
+func (srv *Service) GetCommits(spaceWithId *common.SpaceWithId, branch string) (res *RefsRes[repo.Commit], err error) {
+	rep, project, err := srv.getRepoBySpaceWithId(spaceWithId)
 	if err != nil {
 		return
 	}
-	return rep.Commits(branch)
+	items, err := rep.Commits(branch)
+	return newRefsRes(items, project), err
+}
+
+func newRefsRes[T any](items []T, project *model.Project) *RefsRes[T] {
+	return &RefsRes[T]{Items: items, FetchedAt: project.FetchedAt}
 }
 
-func (srv *Service) getRepoBySpaceWithId(spaceWithId *common.SpaceWithId) (rep repo.Repo, err error) {
-	var projectModel *model.Project
+func (srv *Service) getRepoBySpaceWithId(spaceWithId *common.SpaceWithId) (rep repo.Repo, projectModel *model.Project, err error) {
 	err = srv.db.Where(spaceWithId).First(&projectModel).Error
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if !projectModel.Status.IsEnable() {
-		return nil, errors.New("该项目已经禁用")
+		return nil, nil, errors.New("该项目已经禁用")
 	}
-	return srv.repo.New(repo.TypeRepo(projectModel.RepoType), projectModel.RepoUrl, strconv.Itoa(int(projectModel.ID)))
+	rep, err = srv.repo.New(repo.TypeRepo(projectModel.RepoType), projectModel.RepoUrl, strconv.Itoa(int(projectModel.ID)), projectModel.RepoOptions())
+	return rep, projectModel, err
 }
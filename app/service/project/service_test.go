@@ -0,0 +1,75 @@
+package project
+
+import (
+	"go-walle/app/internal/constants"
+	"go-walle/app/model"
+	"go-walle/app/pkg/secret"
+	"go-walle/app/service/common"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotateDeployKeyWithoutSecret(t *testing.T) {
+	s, _ := secret.NewSecret(&secret.Config{})
+	secret.SetDefault(s)
+	defer secret.SetDefault(nil)
+	_, err := (&Service{}).RotateDeployKey(&common.SpaceWithId{SpaceId: 1, ID: 1})
+	if err == nil || !strings.Contains(err.Error(), "secret.key") {
+		t.Error("未配置secret.key时应提示配置", err)
+	}
+}
+
+func TestCheckEnvironment(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Discard, DisableForeignKeyConstraintWhenMigrating: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//sqlite驱动只会把datetime类型的列解析为时间，去掉模型中指定的time类型
+	for _, m := range []any{&model.Environment{}, &model.Member{}} {
+		stmt := &gorm.Statement{DB: db}
+		if err = stmt.Parse(m); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range stmt.Schema.Fields {
+			if f.DataType == schema.Time {
+				delete(f.TagSettings, "TYPE")
+			}
+		}
+		if err = db.AutoMigrate(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Create(&[]model.Environment{
+		{ID: 1, SpaceId: 1, Name: "prod", SignPolicy: model.SignPolicyTrusted},
+		{ID: 2, SpaceId: 1, Name: "test", SignPolicy: model.SignPolicyNone},
+		{ID: 3, SpaceId: 1, Name: "pre", SignPolicy: model.SignPolicyTrusted},
+		{ID: 4, SpaceId: 2, Name: "other", SignPolicy: model.SignPolicyTrusted},
+	})
+	db.Create(&[]model.Member{
+		{UserId: 10, SpaceId: 1, Role: string(constants.RoleMaster)},
+		{UserId: 11, SpaceId: 1, Role: string(constants.RoleOwner)},
+	})
+	srv := &Service{db: db}
+	for _, c := range []struct {
+		userId   int64
+		from, to int64
+		ok       bool
+	}{
+		{10, 2, 1, true},
+		{10, 1, 3, true},
+		{10, 1, 2, false},
+		{11, 1, 2, true},
+		{10, 2, 4, false},
+	} {
+		err = srv.checkEnvironment(&UpdateReq{SpaceId: 1, UserId: c.userId, EnvironmentId: c.to}, c.from)
+		if (err == nil) != c.ok {
+			t.Errorf("用户%d把项目从环境%d移到%d：%v", c.userId, c.from, c.to, err)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package recording
+
+import "go-walle/app/pkg/db"
+
+type ListReq struct {
+	SpaceId  int64 `json:"-" binding:"required,gt=0"`
+	ServerId int64 `form:"server_id" json:"server_id" binding:"omitempty,gt=0"`
+	UserId   int64 `form:"user_id" json:"user_id" binding:"omitempty,gt=0"`
+	db.Paginator
+}
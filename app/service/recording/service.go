@@ -0,0 +1,174 @@
+package recording
+
+import (
+	"context"
+	"fmt"
+	"go-walle/app/global"
+	"go-walle/app/model"
+	"go-walle/app/pkg/asciicast"
+	"go-walle/app/service/common"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	service     *Service
+	onceService sync.Once
+)
+
+// Service web终端会话录像，按asciinema v2格式保存，可以在浏览器中回放
+type Service struct {
+	log    *zap.Logger
+	db     *gorm.DB
+	config *global.RecordingConfig
+}
+
+func NewService(log *zap.Logger, db *gorm.DB, conf *global.RecordingConfig) *Service {
+	onceService.Do(func() {
+		service = &Service{
+			log:    log.Named("recording"),
+			db:     db,
+			config: conf,
+		}
+	})
+	return service
+}
+
+// Recorder 正在录制的终端会话
+type Recorder struct {
+	*asciicast.Writer
+	srv     *Service
+	file    *os.File
+	session *model.TerminalSession
+	once    sync.Once
+}
+
+// Start 开始录制终端会话，录像文件按月份分目录保存
+func (srv *Service) Start(session *model.TerminalSession) (*Recorder, error) {
+	session.StartedAt = time.Now()
+	if err := srv.db.Omit(clause.Associations).Create(session).Error; err != nil {
+		return nil, err
+	}
+	session.File = filepath.ToSlash(filepath.Join(session.StartedAt.Format("200601"), fmt.Sprintf("%d.cast", session.ID)))
+	file := filepath.Join(srv.config.Dir, filepath.FromSlash(session.File))
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := asciicast.NewWriter(f, asciicast.Header{
+		Width:     session.Cols,
+		Height:    session.Rows,
+		Timestamp: session.StartedAt.Unix(),
+		Title:     session.Server.Hostname(),
+		Env:       map[string]string{"TERM": "xterm"},
+	})
+	if err == nil {
+		err = srv.db.Model(session).UpdateColumn("file", session.File).Error
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(file)
+		return nil, err
+	}
+	return &Recorder{Writer: w, srv: srv, file: f, session: session}, nil
+}
+
+// SessionId 终端会话id
+func (r *Recorder) SessionId() int64 {
+	return r.session.ID
+}
+
+// Close 结束录制，记录结束时间和文件大小
+func (r *Recorder) Close() {
+	r.once.Do(func() {
+		r.Writer.Close()
+		endedAt := time.Now()
+		r.session.EndedAt = &endedAt
+		if info, err := r.file.Stat(); err == nil {
+			r.session.Size = info.Size()
+		}
+		if err := r.file.Close(); err != nil {
+			r.srv.log.Error("关闭录像文件出错", zap.Int64("session_id", r.session.ID), zap.Error(err))
+		}
+		if err := r.srv.db.Model(r.session).Select("ended_at", "size").Updates(r.session).Error; err != nil {
+			r.srv.log.Error("更新终端会话出错", zap.Int64("session_id", r.session.ID), zap.Error(err))
+		}
+	})
+}
+
+func (srv *Service) List(params *ListReq) (total int64, list []*model.TerminalSession, err error) {
+	_db := srv.db.Model(&model.TerminalSession{}).Where("space_id = ?", params.SpaceId)
+	if params.ServerId > 0 {
+		_db = _db.Where("server_id = ?", params.ServerId)
+	}
+	if params.UserId > 0 {
+		_db = _db.Where("user_id = ?", params.UserId)
+	}
+	if err = _db.Count(&total).Error; err != nil || total == 0 {
+		return
+	}
+	err = _db.Scopes(params.PageQuery()).Preload("User").Preload("Server").Order("id desc").Find(&list).Error
+	return
+}
+
+// File 录像文件的路径，正在录制的会话也可以回放已录制的部分
+func (srv *Service) File(spaceWithId *common.SpaceWithId) (string, error) {
+	session := model.TerminalSession{}
+	if err := srv.db.Where(spaceWithId).First(&session).Error; err != nil {
+		return "", err
+	}
+	if session.File == "" {
+		return "", fmt.Errorf("终端会话%d没有录像", session.ID)
+	}
+	return filepath.Join(srv.config.Dir, filepath.FromSlash(session.File)), nil
+}
+
+// Run 定时清理过期的录像，直到ctx结束
+func (srv *Service) Run(ctx context.Context) error {
+	if srv.config.Retention <= 0 || srv.config.CleanInterval <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(srv.config.CleanInterval)
+	defer ticker.Stop()
+	for {
+		srv.clean()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// clean 删除开始时间早于保留时间的录像文件和记录
+func (srv *Service) clean() {
+	list := make([]*model.TerminalSession, 0)
+	err := srv.db.Where("started_at < ?", time.Now().Add(-srv.config.Retention)).Limit(1000).Find(&list).Error
+	if err != nil {
+		srv.log.Error("查询过期录像出错", zap.Error(err))
+		return
+	}
+	for _, session := range list {
+		if session.File != "" {
+			err = os.Remove(filepath.Join(srv.config.Dir, filepath.FromSlash(session.File)))
+			if err != nil && !os.IsNotExist(err) {
+				srv.log.Error("删除过期录像出错", zap.Int64("session_id", session.ID), zap.Error(err))
+				continue
+			}
+		}
+		if err = srv.db.Delete(session).Error; err != nil {
+			srv.log.Error("删除终端会话出错", zap.Int64("session_id", session.ID), zap.Error(err))
+		}
+	}
+	if len(list) > 0 {
+		srv.log.Info("清理过期录像", zap.Int("count", len(list)))
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/model/field"
+	"go-walle/app/pkg/ssh"
+	"go-walle/app/service/common"
+	gossh "golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// CredentialList 空间内的服务器登录凭证，不返回私钥和密码
+func (srv *Service) CredentialList(params *CredentialListReq) (total int64, list []*model.Credential, err error) {
+	_db := srv.db.Model(&model.Credential{}).Where("space_id = ?", params.SpaceId)
+	if err = _db.Count(&total).Error; err != nil || total == 0 {
+		return
+	}
+	err = _db.Scopes(params.PageQuery()).Order("id desc").Find(&list).Error
+	return
+}
+
+func (srv *Service) CredentialCreate(params *CredentialCreateReq) error {
+	m := &model.Credential{
+		SpaceId:     params.SpaceId,
+		Name:        params.Name,
+		Password:    field.Secret(params.Password),
+		PrivateKey:  field.Secret(strings.TrimSpace(params.PrivateKey)),
+		Passphrase:  field.Secret(params.Passphrase),
+		Description: params.Description,
+	}
+	if err := checkCredential(m); err != nil {
+		return err
+	}
+	return srv.db.Create(m).Error
+}
+
+// CredentialUpdate 密码、私钥为空时保留原来的值，需要清空时设置对应的clear参数
+func (srv *Service) CredentialUpdate(params *CredentialUpdateReq) error {
+	m := &model.Credential{}
+	if err := srv.db.Where("space_id = ? and id = ?", params.SpaceId, params.ID).First(m).Error; err != nil {
+		return err
+	}
+	m.Name, m.Description = params.Name, params.Description
+	if params.Password != "" || params.PasswordClear {
+		m.Password = field.Secret(params.Password)
+	}
+	if params.PrivateKey != "" || params.PrivateKeyClear {
+		m.PrivateKey, m.Passphrase = field.Secret(strings.TrimSpace(params.PrivateKey)), field.Secret(params.Passphrase)
+	}
+	if err := checkCredential(m); err != nil {
+		return err
+	}
+	return srv.db.Select("name", "password", "private_key", "passphrase", "public_key", "description").Updates(m).Error
+}
+
+// CredentialDelete 还有服务器使用时不能删除
+func (srv *Service) CredentialDelete(spaceWithId *common.SpaceWithId) error {
+	var total int64
+	if err := srv.db.Model(&model.Server{}).
+		Where("space_id = ? and credential_id = ?", spaceWithId.SpaceId, spaceWithId.ID).
+		Count(&total).Error; err != nil {
+		return err
+	}
+	if total > 0 {
+		return errcode.ErrInvalidParams.New("还有%d台服务器使用该凭证，不能删除", total)
+	}
+	return srv.db.Delete(&model.Credential{}, "space_id = ? and id = ?", spaceWithId.SpaceId, spaceWithId.ID).Error
+}
+
+// checkCredential 密码和私钥至少设置一个，私钥需能用passphrase解析，并生成对应的公钥
+func checkCredential(m *model.Credential) error {
+	if m.Password == "" && m.PrivateKey == "" {
+		return errcode.ErrInvalidParams.New("密码和私钥至少设置一个")
+	}
+	m.PublicKey = ""
+	if m.PrivateKey == "" {
+		return nil
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(m.PrivateKey), m.Passphrase.String())
+	if err != nil {
+		return errcode.ErrInvalidParams.New("私钥或私钥密码错误：%s", err)
+	}
+	m.PublicKey = strings.TrimSpace(string(gossh.MarshalAuthorizedKey(signer.PublicKey())))
+	return nil
+}
+
+// checkCredentialId 服务器只能使用本空间的凭证，0表示使用默认私钥
+func (srv *Service) checkCredentialId(spaceId, credentialId int64) error {
+	if credentialId == 0 {
+		return nil
+	}
+	var total int64
+	if err := srv.db.Model(&model.Credential{}).
+		Where("space_id = ? and id = ?", spaceId, credentialId).
+		Count(&total).Error; err != nil {
+		return err
+	}
+	if total == 0 {
+		return errcode.ErrInvalidParams.New("登录凭证不存在")
+	}
+	return nil
+}
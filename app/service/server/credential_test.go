@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"go-walle/app/model"
+	"testing"
+)
+
+func newTestPrivateKey(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+func TestCredentialUpdate(t *testing.T) {
+	srv := newTestService(t)
+	key := newTestPrivateKey(t)
+	if err := srv.CredentialCreate(&CredentialCreateReq{SpaceId: 1, Name: "deploy", Password: "secret", PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	m := &model.Credential{}
+	srv.db.First(m)
+	get := func() *model.Credential {
+		current := &model.Credential{}
+		srv.db.First(current, m.ID)
+		return current
+	}
+	if m.PublicKey == "" {
+		t.Fatal("没有生成公钥")
+	}
+
+	cases := []struct {
+		name       string
+		req        CredentialUpdateReq
+		password   string
+		privateKey bool
+		err        bool
+	}{
+		{"为空时不修改", CredentialUpdateReq{}, "secret", true, false},
+		{"修改密码", CredentialUpdateReq{Password: "new"}, "new", true, false},
+		{"清空密码", CredentialUpdateReq{PasswordClear: true}, "", true, false},
+		{"不能同时清空密码和私钥", CredentialUpdateReq{PrivateKeyClear: true}, "", true, true},
+		{"设置密码并清空私钥", CredentialUpdateReq{Password: "again", PrivateKeyClear: true}, "again", false, false},
+		{"私钥错误", CredentialUpdateReq{PrivateKey: "invalid"}, "again", false, true},
+		{"重新设置私钥", CredentialUpdateReq{PrivateKey: key, PasswordClear: true}, "", true, false},
+	}
+	for _, c := range cases {
+		c.req.SpaceId, c.req.ID, c.req.Name = 1, m.ID, "deploy"
+		err := srv.CredentialUpdate(&c.req)
+		if (err != nil) != c.err {
+			t.Fatalf("%s：%v", c.name, err)
+		}
+		current := get()
+		if current.Password.String() != c.password || (current.PrivateKey != "") != c.privateKey ||
+			(current.PublicKey != "") != c.privateKey {
+			t.Fatalf("%s：密码%q，私钥%t，公钥%q", c.name, current.Password, current.PrivateKey != "", current.PublicKey)
+		}
+	}
+	if get().PublicKey != m.PublicKey {
+		t.Error("重新设置相同私钥后公钥应不变")
+	}
+
+	//不能修改其他空间的凭证
+	if err := srv.CredentialUpdate(&CredentialUpdateReq{SpaceId: 2, ID: m.ID, Name: "deploy", PasswordClear: true}); err == nil {
+		t.Error("不能修改其他空间的凭证")
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/pkg/ssh"
+	"go-walle/app/service/common"
+	gossh "golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// pinHostKey 保存首次连接时的主机公钥，并发连接时以先保存的为准
+func pinHostKey(db *gorm.DB, m *model.Server, key gossh.PublicKey) error {
+	hostKey := ssh.MarshalHostKey(key)
+	res := db.Model(&model.Server{}).Where("id = ? and host_key = ''", m.ID).UpdateColumn("host_key", hostKey)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		current := model.Server{}
+		if err := db.Select("host_key").Where("id = ?", m.ID).First(&current).Error; err != nil {
+			return err
+		}
+		if current.HostKey != hostKey {
+			return &ssh.HostKeyMismatchError{
+				Address:  m.Host,
+				Expected: ssh.Fingerprint(current.HostKey),
+				Actual:   gossh.FingerprintSHA256(key),
+			}
+		}
+	}
+	m.HostKey = hostKey
+	return nil
+}
+
+// HostKey 查看保存的主机公钥，以及服务器当前的主机公钥
+func (srv *Service) HostKey(spaceWithId *common.SpaceWithId) (*HostKeyRes, error) {
+	m := model.Server{}
+	if err := srv.db.Where(spaceWithId).First(&m).Error; err != nil {
+		return nil, err
+	}
+	res := &HostKeyRes{HostKey: m.HostKey, Fingerprint: ssh.Fingerprint(m.HostKey)}
+	current, err := srv.scanHostKey(&m)
+	if err != nil {
+		res.ScanError = err.Error()
+		return res, nil
+	}
+	res.CurrentHostKey = ssh.MarshalHostKey(current)
+	res.CurrentFingerprint = gossh.FingerprintSHA256(current)
+	res.Match = res.HostKey == res.CurrentHostKey
+	return res, nil
+}
+
+// AcceptHostKey 信任服务器当前的主机公钥，指纹需与用户确认的一致
+func (srv *Service) AcceptHostKey(params *AcceptHostKeyReq) error {
+	m := model.Server{}
+	if err := srv.db.Where(&model.Server{SpaceId: params.SpaceId, ID: params.ID}).First(&m).Error; err != nil {
+		return err
+	}
+	current, err := srv.scanHostKey(&m)
+	if err != nil {
+		return err
+	}
+	if fingerprint := gossh.FingerprintSHA256(current); fingerprint != params.Fingerprint {
+		return errcode.ErrInvalidParams.New("服务器当前的主机公钥指纹为%s，与确认的不一致", fingerprint)
+	}
+	return srv.db.Model(&m).UpdateColumn("host_key", ssh.MarshalHostKey(current)).Error
+}
+
+// ResetHostKey 清除保存的主机公钥，下次连接时重新信任
+func (srv *Service) ResetHostKey(spaceWithId *common.SpaceWithId) error {
+	res := srv.db.Model(&model.Server{}).Where(spaceWithId).UpdateColumn("host_key", "")
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errcode.ErrNotFound.New("服务器不存在")
+	}
+	return nil
+}
+
+// ImportKnownHosts 从known_hosts文件内容中导入空间内服务器的主机公钥，默认只导入还没有保存公钥的服务器
+func (srv *Service) ImportKnownHosts(params *ImportKnownHostsReq) (*ImportKnownHostsRes, error) {
+	knownHosts, err := ssh.NewKnownHosts(params.Content)
+	if err != nil {
+		return nil, errcode.ErrInvalidParams.Wrap(err)
+	}
+	list := make([]*model.Server, 0)
+	if err = srv.db.Where("space_id = ?", params.SpaceId).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	res := &ImportKnownHostsRes{Servers: make([]string, 0)}
+	for _, m := range list {
+		if m.HostKey != "" && !params.Overwrite {
+			continue
+		}
+		key := preferredHostKey(knownHosts.Lookup(m.Host, m.Port))
+		if key == nil {
+			continue
+		}
+		if err = srv.db.Model(m).UpdateColumn("host_key", ssh.MarshalHostKey(key)).Error; err != nil {
+			return nil, err
+		}
+		res.Servers = append(res.Servers, m.Name)
+	}
+	return res, nil
+}
+
+// scanHostKey 优先获取与保存的公钥相同类型的公钥，服务器不再支持该类型时获取默认的公钥
+func (srv *Service) scanHostKey(m *model.Server) (gossh.PublicKey, error) {
+	conf, err := SshConfig(srv.db, m)
+	if err != nil {
+		return nil, err
+	}
+	key, err := srv.ssh.ScanHostKey(conf)
+	if err != nil && conf.HostKey != "" {
+		conf.HostKey = ""
+		key, err = srv.ssh.ScanHostKey(conf)
+	}
+	return key, err
+}
+
+// hostKeyPreference known_hosts中同一主机有多个公钥时，按该顺序选择
+var hostKeyPreference = []string{
+	gossh.KeyAlgoED25519,
+	gossh.KeyAlgoECDSA256,
+	gossh.KeyAlgoECDSA384,
+	gossh.KeyAlgoECDSA521,
+	gossh.KeyAlgoRSA,
+}
+
+func preferredHostKey(keys []gossh.PublicKey) gossh.PublicKey {
+	for _, typ := range hostKeyPreference {
+		for _, key := range keys {
+			if key.Type() == typ {
+				return key
+			}
+		}
+	}
+	if len(keys) > 0 {
+		return keys[0]
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"go-walle/app/model"
+	"go-walle/app/pkg/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+func newHostKey(t *testing.T) gossh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestPinHostKey(t *testing.T) {
+	srv := newTestService(t)
+	m := &model.Server{SpaceId: 1, Name: "web", Host: "10.0.0.1", Port: 22, User: "root"}
+	if err := srv.db.Create(m).Error; err != nil {
+		t.Fatal(err)
+	}
+	first, second := newHostKey(t), newHostKey(t)
+	hostKey := func() string {
+		current := model.Server{}
+		srv.db.First(&current, m.ID)
+		return current.HostKey
+	}
+
+	//首次连接保存主机公钥
+	if err := pinHostKey(srv.db, m, first); err != nil {
+		t.Fatal(err)
+	}
+	if hostKey() != ssh.MarshalHostKey(first) || m.HostKey != ssh.MarshalHostKey(first) {
+		t.Fatal("主机公钥没有保存", hostKey())
+	}
+	//并发连接时以先保存的为准，相同的公钥可以连接
+	stale := &model.Server{ID: m.ID, Host: m.Host}
+	if err := pinHostKey(srv.db, stale, first); err != nil {
+		t.Error("相同的公钥应可以连接", err)
+	}
+	stale = &model.Server{ID: m.ID, Host: m.Host}
+	err := pinHostKey(srv.db, stale, second)
+	if _, ok := err.(*ssh.HostKeyMismatchError); !ok {
+		t.Fatal("不同的公钥应拒绝连接", err)
+	}
+	if !strings.Contains(err.Error(), gossh.FingerprintSHA256(first)) || !strings.Contains(err.Error(), gossh.FingerprintSHA256(second)) {
+		t.Error("提示中缺少指纹", err)
+	}
+	if hostKey() != ssh.MarshalHostKey(first) || stale.HostKey != "" {
+		t.Error("先保存的公钥被覆盖", hostKey())
+	}
+}
+
+func TestImportKnownHosts(t *testing.T) {
+	srv := newTestService(t)
+	pinned, key, other := newHostKey(t), newHostKey(t), newHostKey(t)
+	servers := []*model.Server{
+		{SpaceId: 1, Name: "new", Host: "10.0.0.1", Port: 22},
+		{SpaceId: 1, Name: "pinned", Host: "10.0.0.2", Port: 22, HostKey: ssh.MarshalHostKey(pinned)},
+		{SpaceId: 1, Name: "port", Host: "10.0.0.3", Port: 2222},
+		{SpaceId: 1, Name: "unknown", Host: "10.0.0.4", Port: 22},
+		{SpaceId: 2, Name: "other space", Host: "10.0.0.1", Port: 22},
+	}
+	if err := srv.db.Create(servers).Error; err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf("10.0.0.1,10.0.0.2 %s\n[10.0.0.3]:2222 %s\n", ssh.MarshalHostKey(key), ssh.MarshalHostKey(other))
+	hostKeys := func() map[string]string {
+		res := make(map[string]string)
+		list := make([]*model.Server, 0)
+		srv.db.Find(&list)
+		for _, m := range list {
+			res[m.Name] = m.HostKey
+		}
+		return res
+	}
+
+	//默认只导入没有保存公钥的服务器
+	res, err := srv.ImportKnownHosts(&ImportKnownHostsReq{SpaceId: 1, Content: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(res.Servers, ",") != "new,port" {
+		t.Error("导入的服务器错误", res.Servers)
+	}
+	want := map[string]string{
+		"new":         ssh.MarshalHostKey(key),
+		"pinned":      ssh.MarshalHostKey(pinned),
+		"port":        ssh.MarshalHostKey(other),
+		"unknown":     "",
+		"other space": "",
+	}
+	for name, hostKey := range hostKeys() {
+		if hostKey != want[name] {
+			t.Errorf("%s的主机公钥错误", name)
+		}
+	}
+
+	//覆盖已保存的公钥
+	if res, err = srv.ImportKnownHosts(&ImportKnownHostsReq{SpaceId: 1, Content: content, Overwrite: true}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(res.Servers, ",") != "new,pinned,port" || hostKeys()["pinned"] != ssh.MarshalHostKey(key) {
+		t.Error("没有覆盖已保存的公钥", res.Servers)
+	}
+	if _, err = srv.ImportKnownHosts(&ImportKnownHostsReq{SpaceId: 1, Content: "10.0.0.1 ssh-ed25519 !"}); err == nil {
+		t.Error("格式错误时应报错")
+	}
+}
@@ -9,6 +9,10 @@ type CreateReq struct {
 	Host        string `json:"host" binding:"required,ip"`
 	Port        int    `json:"port" binding:"required,min=22,max=65535"`
 	Description string `json:"description" binding:"omitempty,max=500"`
+
+	CredentialId int64 `json:"credential_id" binding:"omitempty,gte=0"`  //登录凭证，为0时使用默认私钥
+	JumpServerId int64 `json:"jump_server_id" binding:"omitempty,gte=0"` //跳板机，为0时直接连接
+	Bandwidth    int64 `json:"bandwidth" binding:"omitempty,gte=0"`      //上传带宽限制，单位KB/s，为0时不限制
 }
 
 type UpdateReq struct {
@@ -19,10 +23,14 @@ type UpdateReq struct {
 	Host        string `json:"host" binding:"required,ip"`
 	Port        int    `json:"port" binding:"required,min=22,max=65535"`
 	Description string `json:"description" binding:"omitempty,max=500"`
+
+	CredentialId int64 `json:"credential_id" binding:"omitempty,gte=0"`  //登录凭证，为0时使用默认私钥
+	JumpServerId int64 `json:"jump_server_id" binding:"omitempty,gte=0"` //跳板机，为0时直接连接
+	Bandwidth    int64 `json:"bandwidth" binding:"omitempty,gte=0"`      //上传带宽限制，单位KB/s，为0时不限制
 }
 
 func (r *UpdateReq) Fields() []string {
-	return []string{"name", "user", "host", "port", "description"}
+	return []string{"name", "user", "host", "port", "description", "credential_id", "jump_server_id", "bandwidth"}
 }
 
 type SetAuthorizedReq struct {
@@ -38,3 +46,64 @@ type ListReq struct {
 	//common.Order
 	db.Paginator
 }
+
+type AcceptHostKeyReq struct {
+	SpaceId     int64  `json:"-" binding:"required,gt=0"`
+	ID          int64  `json:"-" binding:"required,gt=0"`
+	Fingerprint string `json:"fingerprint" binding:"required,max=100"`
+}
+
+type ImportKnownHostsReq struct {
+	SpaceId   int64  `json:"-" binding:"required,gt=0"`
+	Content   string `json:"content" binding:"required,max=1000000"`
+	Overwrite bool   `json:"overwrite"` //是否覆盖已经保存的主机公钥
+}
+
+type ImportKnownHostsRes struct {
+	Servers []string `json:"servers"` //导入了主机公钥的服务器
+}
+
+type HostKeyRes struct {
+	HostKey            string `json:"host_key"`
+	Fingerprint        string `json:"fingerprint"`
+	CurrentHostKey     string `json:"current_host_key"`
+	CurrentFingerprint string `json:"current_fingerprint"`
+	Match              bool   `json:"match"`
+	ScanError          string `json:"scan_error,omitempty"`
+}
+
+type CredentialListReq struct {
+	SpaceId int64 `json:"-" binding:"required,gt=0"`
+	db.Paginator
+}
+
+type CredentialCreateReq struct {
+	SpaceId     int64  `json:"-" binding:"required,gt=0"`
+	Name        string `json:"name" binding:"required,max=100"`
+	Password    string `json:"password" binding:"omitempty,max=100"`
+	PrivateKey  string `json:"private_key" binding:"omitempty,max=20000"`
+	Passphrase  string `json:"passphrase" binding:"omitempty,max=100"`
+	Description string `json:"description" binding:"omitempty,max=500"`
+}
+
+type CredentialUpdateReq struct {
+	SpaceId     int64  `json:"-" binding:"required,gt=0"`
+	ID          int64  `json:"id" binding:"required,gt=0"`
+	Name        string `json:"name" binding:"required,max=100"`
+	Password    string `json:"password" binding:"omitempty,max=100"`      //为空时不修改
+	PrivateKey  string `json:"private_key" binding:"omitempty,max=20000"` //为空时不修改
+	Passphrase  string `json:"passphrase" binding:"omitempty,max=100"`
+	Description string `json:"description" binding:"omitempty,max=500"`
+
+	PasswordClear   bool `json:"password_clear"`    //为true时清空密码
+	PrivateKeyClear bool `json:"private_key_clear"` //为true时清空私钥和私钥密码
+}
+
+type TerminalPolicyReq struct {
+	SpaceId       int64    `json:"-" binding:"required,gt=0"`
+	DenyCommands  []string `json:"deny_commands" binding:"omitempty,max=100,dive,max=500"`  //禁止执行的命令正则
+	AllowCommands []string `json:"allow_commands" binding:"omitempty,max=100,dive,max=500"` //允许执行的命令正则，为空时不限制
+	ReadOnlyRoles []string `json:"read_only_roles" binding:"omitempty,dive,oneof=owner master developer"`
+	MaxDuration   int      `json:"max_duration" binding:"omitempty,gte=0"` //单次会话最长时间，单位分钟，为0时不限制
+	MaxSessions   int      `json:"max_sessions" binding:"omitempty,gte=0"` //每个用户同时打开的会话数，为0时不限制
+}
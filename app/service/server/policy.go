@@ -0,0 +1,348 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"go-walle/app/internal/constants"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// TerminalPolicy 空间的终端策略，没有设置过时返回不做任何限制的默认策略
+func (srv *Service) TerminalPolicy(spaceId int64) (*model.TerminalPolicy, error) {
+	m := &model.TerminalPolicy{}
+	err := srv.db.Where("space_id = ?", spaceId).First(m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &model.TerminalPolicy{SpaceId: spaceId}, nil
+	}
+	return m, err
+}
+
+func (srv *Service) TerminalPolicyUpdate(params *TerminalPolicyReq) error {
+	m := &model.TerminalPolicy{
+		SpaceId:       params.SpaceId,
+		DenyCommands:  strings.Join(params.DenyCommands, "\n"),
+		AllowCommands: strings.Join(params.AllowCommands, "\n"),
+		ReadOnlyRoles: strings.Join(params.ReadOnlyRoles, ","),
+		MaxDuration:   params.MaxDuration,
+		MaxSessions:   params.MaxSessions,
+	}
+	if _, err := newCommandPolicy(m); err != nil {
+		return errcode.ErrInvalidParams.Wrap(err)
+	}
+	return srv.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "space_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"deny_commands", "allow_commands", "read_only_roles", "max_duration", "max_sessions", "updated_at"}),
+	}).Create(m).Error
+}
+
+// CheckCommand 按空间的终端策略校验在终端以外执行的命令，例如批量执行，被拦截时记录审计日志
+func (srv *Service) CheckCommand(spaceId, userId int64, cmd string) error {
+	policy, err := srv.TerminalPolicy(spaceId)
+	if err != nil {
+		return err
+	}
+	commands, err := newCommandPolicy(policy)
+	if err != nil {
+		return err
+	}
+	role, err := srv.memberRole(spaceId, userId)
+	if err != nil {
+		return err
+	}
+	reason, ok := "只读角色不能执行命令", !policy.IsReadOnly(role)
+	for _, line := range commandLines(cmd) {
+		if !ok {
+			break
+		}
+		reason, ok = commands.check(line)
+	}
+	if ok {
+		return nil
+	}
+	srv.audit.Log(&model.AuditLog{
+		SpaceId: spaceId,
+		UserId:  userId,
+		Action:  model.AuditCommandBlocked,
+		Target:  cmd,
+		Detail:  reason,
+	})
+	return errcode.ErrInvalidParams.New("命令已被拦截：%s", reason)
+}
+
+// memberRole 用户在空间中的角色，超级管理员不是空间成员
+func (srv *Service) memberRole(spaceId, userId int64) (string, error) {
+	if constants.IsSuperUser(userId) {
+		return string(constants.RoleSuper), nil
+	}
+	member := model.Member{}
+	err := srv.db.Where("space_id = ? and user_id = ?", spaceId, userId).First(&member).Error
+	return member.Role, err
+}
+
+type sessionKey struct {
+	spaceId int64
+	userId  int64
+}
+
+// openSession 占用用户的一个终端会话名额，超过max时返回false，max为0时不限制
+func (srv *Service) openSession(spaceId, userId int64, max int) bool {
+	srv.mux.Lock()
+	defer srv.mux.Unlock()
+	key := sessionKey{spaceId: spaceId, userId: userId}
+	if max > 0 && srv.sessions[key] >= max {
+		return false
+	}
+	srv.sessions[key]++
+	return true
+}
+
+func (srv *Service) closeSession(spaceId, userId int64) {
+	srv.mux.Lock()
+	defer srv.mux.Unlock()
+	key := sessionKey{spaceId: spaceId, userId: userId}
+	if srv.sessions[key]--; srv.sessions[key] <= 0 {
+		delete(srv.sessions, key)
+	}
+}
+
+// commandPolicy 编译后的命令黑白名单
+type commandPolicy struct {
+	deny  []*regexp.Regexp
+	allow []*regexp.Regexp
+}
+
+func newCommandPolicy(m *model.TerminalPolicy) (p *commandPolicy, err error) {
+	p = &commandPolicy{}
+	if p.deny, err = compileCommands(m.DenyCommands); err != nil {
+		return nil, err
+	}
+	if p.allow, err = compileCommands(m.AllowCommands); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func compileCommands(s string) (list []*regexp.Regexp, err error) {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("命令规则%s格式错误：%w", line, err)
+		}
+		list = append(list, re)
+	}
+	return list, nil
+}
+
+// enabled 是否设置了命令规则
+func (p *commandPolicy) enabled() bool {
+	return len(p.deny) > 0 || len(p.allow) > 0
+}
+
+// check 校验一行命令，不允许执行时返回原因。
+// 黑名单匹配整行；设置了白名单时，用;|&分隔的每一段命令都需要匹配白名单，且不能使用命令替换
+func (p *commandPolicy) check(line string) (reason string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", true
+	}
+	for _, re := range p.deny {
+		if re.MatchString(line) {
+			return "匹配禁止规则 " + re.String(), false
+		}
+	}
+	if len(p.allow) == 0 {
+		return "", true
+	}
+	if strings.Contains(line, "$(") || strings.Contains(line, "`") {
+		return "不允许使用命令替换", false
+	}
+	//重定向可以覆盖任意文件，只允许部分命令时同样禁止
+	if strings.ContainsAny(line, "<>") {
+		return "不允许使用重定向", false
+	}
+	for _, cmd := range strings.FieldsFunc(line, func(r rune) bool { return r == ';' || r == '|' || r == '&' }) {
+		if cmd = strings.TrimSpace(cmd); cmd == "" {
+			continue
+		}
+		if !matchAny(p.allow, cmd) {
+			return "不在允许的命令中：" + cmd, false
+		}
+	}
+	return "", true
+}
+
+// commandLines 把续行(行尾奇数个\)与下一行连接后按行拆分，和shell解析的命令一致
+func commandLines(cmd string) []string {
+	lines := make([]string, 0)
+	var line []rune
+	backslashes := 0
+	for _, r := range strings.ReplaceAll(cmd, "\r\n", "\n") {
+		if r == '\n' || r == '\r' {
+			if backslashes%2 == 1 {
+				line = line[:len(line)-1]
+			} else {
+				lines = append(lines, string(line))
+				line = line[:0]
+			}
+			backslashes = 0
+			continue
+		}
+		if r == '\\' {
+			backslashes++
+		} else {
+			backslashes = 0
+		}
+		line = append(line, r)
+	}
+	return append(lines, string(line))
+}
+
+// continued 行尾有奇数个\时，shell会把下一行连接到本行
+func continued(line string) bool {
+	return (len(line)-len(strings.TrimRight(line, `\`)))%2 == 1
+}
+
+func matchAny(list []*regexp.Regexp, s string) bool {
+	for _, re := range list {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineTracker 根据终端输入还原当前正在输入的命令行。
+// 只能识别普通字符、退格、Ctrl-C/Ctrl-U/Ctrl-W，使用方向键、Tab补全、历史搜索等
+// 无法得知shell中实际的命令，此时标记为dirty
+type lineTracker struct {
+	line   []rune
+	dirty  bool
+	escape int //0:普通输入 1:收到ESC 2:在CSI/SS3序列中
+}
+
+// feed 处理输入的一个字符，遇到回车时返回输入的整行命令
+func (t *lineTracker) feed(r rune) (line string, dirty bool, enter bool) {
+	switch t.escape {
+	case 1:
+		t.escape = 0
+		if r == '[' || r == 'O' {
+			t.escape = 2
+		}
+		return
+	case 2:
+		if r >= 0x40 && r <= 0x7e {
+			t.escape = 0
+		}
+		return
+	}
+	switch r {
+	case '\r', '\n':
+		line, dirty = string(t.line), t.dirty
+		t.reset()
+		return line, dirty, true
+	case 0x1b:
+		t.escape, t.dirty = 1, true
+	case 0x7f, '\b':
+		if len(t.line) > 0 {
+			t.line = t.line[:len(t.line)-1]
+		}
+	case 0x03, 0x15: //Ctrl-C Ctrl-U
+		t.reset()
+	case 0x17: //Ctrl-W
+		s := strings.TrimRight(string(t.line), " ")
+		t.line = []rune(s[:strings.LastIndex(s, " ")+1])
+	default:
+		if r < 0x20 || r == utf8.RuneError {
+			t.dirty = true
+		} else {
+			t.line = append(t.line, r)
+		}
+	}
+	return
+}
+
+func (t *lineTracker) reset() {
+	t.line, t.dirty = t.line[:0], false
+}
+
+// terminalUser 终端会话的参与者，会话所有者或加入会话的协助者
+type terminalUser struct {
+	id       int64
+	name     string
+	readOnly bool //角色只读
+	warned   bool //已经提示过只读
+	//只读用户的输入不会发送给shell，单独还原输入的命令用于审计
+	tracker lineTracker
+}
+
+// terminalGuard 对一个终端会话的输入执行命令策略，所有参与者共用shell中的同一行输入
+type terminalGuard struct {
+	policy  *commandPolicy
+	tracker lineTracker
+	//以\续行时已输入的内容，续行结束后和最后一行一起校验
+	pending      string
+	pendingDirty bool
+	// onBlock 输入被拦截时调用，用于写审计日志
+	onBlock func(userId int64, action, target, detail string)
+}
+
+// filter 返回可以发送给shell的输入和需要提示用户的信息。
+// 被拦截的命令用Ctrl-C代替回车发送，清除shell中已经输入的内容
+func (g *terminalGuard) filter(user *terminalUser, input string) (out []byte, notices []string) {
+	if user.readOnly {
+		if !user.warned {
+			user.warned = true
+			notices = append(notices, "当前为只读终端，不能输入命令")
+		}
+		//提示只发送一次，每次回车尝试执行的命令都记录审计日志
+		for _, r := range input {
+			if line, _, enter := user.tracker.feed(r); enter && line != "" {
+				g.onBlock(user.id, model.AuditTerminalReadOnly, line, "只读角色尝试输入")
+			}
+		}
+		return nil, notices
+	}
+	if !g.policy.enabled() {
+		return []byte(input), nil
+	}
+	for _, r := range input {
+		if r == 0x03 { //Ctrl-C同时取消续行
+			g.pending, g.pendingDirty = "", false
+		}
+		line, dirty, enter := g.tracker.feed(r)
+		if !enter {
+			out = utf8.AppendRune(out, r)
+			continue
+		}
+		//续行时shell不会执行，等整条命令输入完成后再校验
+		if continued(line) {
+			g.pending += line[:len(line)-1]
+			g.pendingDirty = g.pendingDirty || dirty
+			out = utf8.AppendRune(out, r)
+			continue
+		}
+		line, dirty = g.pending+line, g.pendingDirty || dirty
+		g.pending, g.pendingDirty = "", false
+		reason, ok := g.policy.check(line)
+		if ok && dirty {
+			reason, ok = "使用了方向键、Tab补全或历史命令，无法校验实际执行的命令", false
+		}
+		if ok {
+			out = utf8.AppendRune(out, r)
+			continue
+		}
+		out = append(out, 0x03)
+		notices = append(notices, "命令已被拦截："+reason)
+		g.onBlock(user.id, model.AuditTerminalBlocked, line, reason)
+	}
+	return out, notices
+}
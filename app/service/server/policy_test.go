@@ -0,0 +1,229 @@
+package server
+
+import (
+	"go-walle/app/internal/constants"
+	"go-walle/app/model"
+	"go-walle/app/pkg/secret"
+	"go-walle/app/service/audit"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	testDB     *gorm.DB
+	testModels = []any{&model.Member{}, &model.TerminalPolicy{}, &model.AuditLog{}, &model.Server{}, &model.Credential{}}
+	onceTestDB sync.Once
+)
+
+// newTestService 使用内存sqlite数据库的服务，审计服务是单例，所有测试共用一个数据库，每次清空数据
+func newTestService(t *testing.T) *Service {
+	onceTestDB.Do(func() {
+		db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+		if err != nil {
+			t.Fatal(err)
+		}
+		//内存数据库每个连接是独立的，只使用一个连接
+		sqlDB, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sqlDB.SetMaxOpenConns(1)
+		//sqlite驱动只会把datetime类型的列解析为时间，去掉模型中指定的time类型
+		for _, m := range testModels {
+			stmt := &gorm.Statement{DB: db}
+			if err = stmt.Parse(m); err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range stmt.Schema.Fields {
+				if f.DataType == schema.Time {
+					delete(f.TagSettings, "TYPE")
+				}
+			}
+		}
+		if err = db.AutoMigrate(testModels...); err != nil {
+			t.Fatal(err)
+		}
+		//凭证的密码和私钥加密存储
+		s, err := secret.NewSecret(&secret.Config{Key: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret.SetDefault(s)
+		testDB = db
+	})
+	if testDB == nil {
+		t.Fatal("初始化测试数据库失败")
+	}
+	for _, m := range testModels {
+		if err := testDB.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	log := zap.NewNop()
+	return &Service{log: log, db: testDB, audit: audit.NewService(log, testDB),
+		sessions: make(map[sessionKey]int), lives: make(map[int64]*liveSession)}
+}
+
+func TestCommandPolicyCheck(t *testing.T) {
+	p, err := newCommandPolicy(&model.TerminalPolicy{
+		DenyCommands:  `rm\s+-rf\s+/` + "\n\n" + `^shutdown`,
+		AllowCommands: "^ls\\b\n^cat\\b\n^grep\\b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line, want := range map[string]bool{
+		"":                     true,
+		"ls -la":               true,
+		"cat a.log | grep err": true,
+		"shutdown -h now":      false,
+		"ls; rm -rf /":         false,
+		"ls && vim a":          false,
+		"ls $(whoami)":         false,
+		"cat `which ls`":       false,
+		"cat a > /etc/passwd":  false,
+		"grep root < a.log":    false,
+	} {
+		if _, ok := p.check(line); ok != want {
+			t.Errorf("check(%q) != %v", line, want)
+		}
+	}
+	if _, err = newCommandPolicy(&model.TerminalPolicy{DenyCommands: "(rm"}); err == nil {
+		t.Error("错误的正则校验通过")
+	}
+}
+
+func TestTerminalGuardFilter(t *testing.T) {
+	p, _ := newCommandPolicy(&model.TerminalPolicy{DenyCommands: `^rm\b`})
+	var blocked []string
+	g := &terminalGuard{policy: p, onBlock: func(userId int64, action, target, detail string) {
+		blocked = append(blocked, target)
+	}}
+	user := &terminalUser{id: 1}
+	//逐字输入，退格修改后的命令
+	out, _ := g.filter(user, "ls")
+	if string(out) != "ls" {
+		t.Errorf("普通输入被修改：%q", out)
+	}
+	out, _ = g.filter(user, "\x7f\x7frm -f a\r")
+	if string(out) != "\x7f\x7frm -f a\x03" || len(blocked) != 1 || blocked[0] != "rm -f a" {
+		t.Errorf("禁止的命令没有被拦截：%q %v", out, blocked)
+	}
+	//粘贴多行时只拦截禁止的那一行
+	out, notices := g.filter(user, "pwd\rrm a\rls\r")
+	if string(out) != "pwd\rrm a\x03ls\r" || len(notices) != 1 {
+		t.Errorf("多行输入处理错误：%q %v", out, notices)
+	}
+	//历史命令无法校验
+	out, _ = g.filter(user, "\x1b[A\r")
+	if string(out) != "\x1b[A\x03" {
+		t.Errorf("历史命令没有被拦截：%q", out)
+	}
+	//Ctrl-W删除单词
+	out, _ = g.filter(user, "echo rm\x17\x17ls\r")
+	if out[len(out)-1] != '\r' {
+		t.Errorf("Ctrl-W处理错误：%q", out)
+	}
+
+	//续行时等整条命令输入完成后再校验
+	blocked = nil
+	out, _ = g.filter(user, "rm \\\r-f b\r")
+	if string(out) != "rm \\\r-f b\x03" {
+		t.Errorf("续行输入处理错误：%q", out)
+	}
+	out, _ = g.filter(user, "r\\\rm a\r")
+	if string(out) != "r\\\rm a\x03" || len(blocked) != 2 || blocked[1] != "rm a" {
+		t.Errorf("续行拼接的命令没有被拦截：%q %v", out, blocked)
+	}
+	//Ctrl-C取消续行，偶数个\不是续行
+	out, _ = g.filter(user, "r\\\r\x03m a\rls \\\\\r")
+	if string(out) != "r\\\r\x03m a\rls \\\\\r" {
+		t.Errorf("取消续行处理错误：%q", out)
+	}
+
+	blocked = nil
+	user = &terminalUser{id: 1, readOnly: true}
+	out, notices = g.filter(user, "ls\r")
+	if len(out) != 0 || len(notices) != 1 {
+		t.Error("只读终端可以输入")
+	}
+	if _, notices = g.filter(user, "pw"); len(notices) != 0 {
+		t.Error("只读提示重复发送")
+	}
+	g.filter(user, "d\r\r")
+	if !reflect.DeepEqual(blocked, []string{"ls", "pwd"}) {
+		t.Errorf("只读用户每次输入的命令都应记录：%v", blocked)
+	}
+}
+
+func TestCommandLines(t *testing.T) {
+	for cmd, want := range map[string][]string{
+		"ls":                   {"ls"},
+		"ls\npwd":              {"ls", "pwd"},
+		"rm -rf \\\n/":         {"rm -rf /"},
+		"rm -rf \\\r\n/":       {"rm -rf /"},
+		"echo a\\\\\nrm -rf /": {"echo a\\\\", "rm -rf /"},
+		"ls\rpwd":              {"ls", "pwd"},
+	} {
+		if got := commandLines(cmd); !reflect.DeepEqual(got, want) {
+			t.Errorf("commandLines(%q) = %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	srv := newTestService(t)
+	srv.db.Create(&model.Member{SpaceId: 1, UserId: 2, Role: string(constants.RoleMaster)})
+	srv.db.Create(&model.Member{SpaceId: 1, UserId: 3, Role: string(constants.RoleDeveloper)})
+	err := srv.TerminalPolicyUpdate(&TerminalPolicyReq{
+		SpaceId:       1,
+		DenyCommands:  []string{`rm\s+-rf\s+/`},
+		AllowCommands: []string{`^ls\b`, `^cat\b`, `^rm\b`},
+		ReadOnlyRoles: []string{string(constants.RoleDeveloper)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		userId int64
+		cmd    string
+		ok     bool
+	}{
+		{2, "ls -la", true},
+		{2, "ls\ncat a.log", true},
+		{2, "rm -rf \\\n/", false},
+		{2, "cat a\\\n > /etc/passwd", false},
+		{2, "ls\nvim a", false},
+		{3, "ls", false},
+		{4, "ls", false},
+	}
+	for _, c := range cases {
+		err := srv.CheckCommand(1, c.userId, c.cmd)
+		if (err == nil) != c.ok {
+			t.Errorf("CheckCommand(%d, %q) = %v", c.userId, c.cmd, err)
+		}
+	}
+	//不同空间的策略互不影响
+	if err = srv.CheckCommand(2, constants.SuperUserId, "vim a"); err != nil {
+		t.Error("没有设置策略的空间不限制", err)
+	}
+	var logs []*model.AuditLog
+	srv.db.Order("id").Find(&logs)
+	if len(logs) != 4 {
+		t.Fatalf("被拦截的命令应记录审计日志：%d", len(logs))
+	}
+	if logs[0].Action != model.AuditCommandBlocked || logs[0].UserId != 2 || logs[0].SpaceId != 1 ||
+		logs[0].Target != "rm -rf \\\n/" || !strings.Contains(logs[0].Detail, "禁止") {
+		t.Errorf("审计日志内容错误：%+v", logs[0])
+	}
+	if !strings.Contains(logs[1].Detail, "重定向") || !strings.Contains(logs[3].Detail, "只读") || logs[3].UserId != 3 {
+		t.Errorf("拦截原因错误：%s %s", logs[1].Detail, logs[3].Detail)
+	}
+}
@@ -3,10 +3,13 @@ package server
 import (
 	"errors"
 	"fmt"
+	"go-walle/app/internal/errcode"
 	"go-walle/app/model"
 	"go-walle/app/model/field"
 	"go-walle/app/pkg/ssh"
+	"go-walle/app/service/audit"
 	"go-walle/app/service/common"
+	"go-walle/app/service/recording"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"sync"
@@ -18,14 +21,21 @@ var (
 )
 
 type Service struct {
-	log *zap.Logger
-	db  *gorm.DB
-	ssh *ssh.Ssh
+	log       *zap.Logger
+	db        *gorm.DB
+	ssh       *ssh.Ssh
+	recording *recording.Service
+	audit     *audit.Service
+
+	mux      sync.Mutex
+	sessions map[sessionKey]int     //每个用户打开的终端会话数
+	lives    map[int64]*liveSession //正在进行的终端会话
 }
 
-func NewService(log *zap.Logger, db *gorm.DB, ssh *ssh.Ssh) *Service {
+func NewService(log *zap.Logger, db *gorm.DB, ssh *ssh.Ssh, recording *recording.Service, audit *audit.Service) *Service {
 	onceService.Do(func() {
-		service = &Service{db: db, log: log, ssh: ssh}
+		service = &Service{db: db, log: log, ssh: ssh, recording: recording, audit: audit,
+			sessions: make(map[sessionKey]int), lives: make(map[int64]*liveSession)}
 	})
 	return service
 }
@@ -36,7 +46,7 @@ func (srv *Service) List(params *ListReq) (total int64, list []*model.Server, er
 	if err != nil || total == 0 {
 		return
 	}
-	err = _db.Scopes(params.PageQuery()).Find(&list).Error
+	err = _db.Scopes(params.PageQuery()).Preload("Credential").Preload("JumpServer").Find(&list).Error
 	return
 }
 
@@ -49,6 +59,16 @@ func (srv *Service) Create(params *CreateReq) error {
 		User:        params.User,
 		Status:      field.StatusDisable,
 		Description: params.Description,
+
+		CredentialId: params.CredentialId,
+		JumpServerId: params.JumpServerId,
+		Bandwidth:    params.Bandwidth,
+	}
+	if err := srv.checkCredentialId(m.SpaceId, m.CredentialId); err != nil {
+		return err
+	}
+	if err := srv.checkJumpServerId(m.SpaceId, 0, m.JumpServerId); err != nil {
+		return err
 	}
 	_m, err := srv.FindByHostIp(m.SpaceId, m.User, m.Host, m.Port)
 	if err != nil {
@@ -68,10 +88,39 @@ func (srv *Service) Update(params *UpdateReq) error {
 	if _m.ID != 0 && _m.ID != params.ID {
 		return errors.New("更新错误")
 	}
-	return srv.db.Model(model.Server{}).Select(params.Fields()).Where(model.Server{SpaceId: params.SpaceId, ID: params.ID}).Updates(params).Error
+	if err = srv.checkCredentialId(params.SpaceId, params.CredentialId); err != nil {
+		return err
+	}
+	if err = srv.checkJumpServerId(params.SpaceId, params.ID, params.JumpServerId); err != nil {
+		return err
+	}
+	old := model.Server{}
+	if err = srv.db.Where(model.Server{SpaceId: params.SpaceId, ID: params.ID}).First(&old).Error; err != nil {
+		return err
+	}
+	return srv.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(model.Server{}).Select(params.Fields()).Where(model.Server{SpaceId: params.SpaceId, ID: params.ID}).Updates(params).Error
+		if err != nil {
+			return err
+		}
+		//更换了地址，需要重新信任主机公钥
+		if old.Host != params.Host || old.Port != params.Port {
+			return tx.Model(&old).UpdateColumn("host_key", "").Error
+		}
+		return nil
+	})
 }
 
 func (srv *Service) Delete(spaceWith *common.SpaceWithId) error {
+	var total int64
+	if err := srv.db.Model(&model.Server{}).
+		Where("space_id = ? and jump_server_id = ?", spaceWith.SpaceId, spaceWith.ID).
+		Count(&total).Error; err != nil {
+		return err
+	}
+	if total > 0 {
+		return errcode.ErrInvalidParams.New("还有%d台服务器使用该服务器作为跳板机，不能删除", total)
+	}
 	return srv.db.Transaction(func(tx *gorm.DB) error {
 		err := tx.Model(&model.Server{ID: spaceWith.ID}).Association("Projects").Clear()
 		if err != nil {
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"go-walle/app/model"
+	"go-walle/app/pkg/ssh"
+	"go-walle/app/service/common"
+	"go-walle/app/service/recording"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	scrollbackSize  = 32 * 1024 //加入会话时回放的最近输出
+	viewerQueueSize = 256       //观看者待发送的消息数，超过时断开观看者
+	wsMsgTypeGrant  = "grant"   //会话所有者授权协助者输入
+	wsMsgTypeRevoke = "revoke"  //会话所有者收回输入权限
+)
+
+// liveSession 正在进行的终端会话，其他成员可以通过会话id加入观看，或在所有者授权后协助输入
+type liveSession struct {
+	log      *zap.Logger
+	id       int64
+	spaceId  int64
+	server   model.Server
+	owner    *terminalUser
+	terminal *ssh.Terminal
+	recorder *recording.Recorder
+	guard    *terminalGuard
+	// notices 发送给会话所有者的提示，由所有者的ws主循环发送
+	notices   chan string
+	startedAt time.Time
+
+	mux        sync.Mutex
+	viewers    map[*viewer]struct{}
+	scrollback []byte
+	closed     bool
+}
+
+// viewer 加入会话的用户
+type viewer struct {
+	user    *terminalUser
+	copilot bool //请求协助输入
+	granted bool //所有者已授权输入
+	out     chan []byte
+	closed  bool
+}
+
+// LiveSession 正在进行的终端会话
+type LiveSession struct {
+	ID        int64     `json:"id"`
+	ServerId  int64     `json:"server_id"`
+	Server    string    `json:"server"`
+	UserId    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Viewers   []string  `json:"viewers"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// startLive 登记正在进行的会话
+func (srv *Service) startLive(live *liveSession) {
+	srv.mux.Lock()
+	defer srv.mux.Unlock()
+	srv.lives[live.id] = live
+}
+
+// endLive 会话结束，断开所有观看者
+func (srv *Service) endLive(live *liveSession) {
+	srv.mux.Lock()
+	delete(srv.lives, live.id)
+	srv.mux.Unlock()
+
+	live.mux.Lock()
+	defer live.mux.Unlock()
+	live.closed = true
+	for v := range live.viewers {
+		live.send(v, []byte("\r\n"+terminalMsg("会话已结束", errorMsg)))
+		live.drop(v)
+	}
+}
+
+// LiveSessions 空间内正在进行的终端会话
+func (srv *Service) LiveSessions(spaceId int64) []*LiveSession {
+	srv.mux.Lock()
+	defer srv.mux.Unlock()
+	list := make([]*LiveSession, 0)
+	for _, live := range srv.lives {
+		if live.spaceId != spaceId {
+			continue
+		}
+		item := &LiveSession{
+			ID:        live.id,
+			ServerId:  live.server.ID,
+			Server:    live.server.Name,
+			UserId:    live.owner.id,
+			Username:  live.owner.name,
+			Viewers:   make([]string, 0),
+			StartedAt: live.startedAt,
+		}
+		live.mux.Lock()
+		for v := range live.viewers {
+			item.Viewers = append(item.Viewers, v.user.name)
+		}
+		live.mux.Unlock()
+		list = append(list, item)
+	}
+	return list
+}
+
+// Attach 加入正在进行的终端会话，copilot为true时请求协助输入，需要会话所有者授权
+func (srv *Service) Attach(wsConn *websocket.Conn, spaceWithId *common.SpaceWithId, userId int64, username string, copilot bool) error {
+	wsSendMsg := func(msg string, msgType int) error {
+		return wsConn.WriteMessage(websocket.TextMessage, []byte(terminalMsg(msg, msgType)))
+	}
+	srv.mux.Lock()
+	live, ok := srv.lives[spaceWithId.ID]
+	srv.mux.Unlock()
+	if !ok || live.spaceId != spaceWithId.SpaceId {
+		_ = wsSendMsg("会话不存在或已结束！", errorMsg)
+		return errors.New("会话不存在或已结束")
+	}
+	role, err := srv.memberRole(live.spaceId, userId)
+	if err != nil {
+		_ = wsSendMsg(err.Error(), errorMsg)
+		return err
+	}
+	policy, err := srv.TerminalPolicy(live.spaceId)
+	if err != nil {
+		_ = wsSendMsg(err.Error(), errorMsg)
+		return err
+	}
+	user := &terminalUser{id: userId, name: username, readOnly: policy.IsReadOnly(role)}
+	v, err := live.join(user, copilot)
+	if err != nil {
+		_ = wsSendMsg(err.Error(), errorMsg)
+		return err
+	}
+	defer live.leave(v)
+	mode := "观看"
+	if copilot {
+		mode = "协助"
+	}
+	srv.audit.Log(&model.AuditLog{
+		SpaceId:  live.spaceId,
+		UserId:   userId,
+		Action:   model.AuditTerminalAttach,
+		ServerId: live.server.ID,
+		Target:   fmt.Sprintf("%d", live.id),
+		Detail:   fmt.Sprintf("加入%s的终端会话(%s)", live.owner.name, mode),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			_, msg, err := wsConn.ReadMessage()
+			if err != nil {
+				return
+			}
+			wsMsg := new(TerminalWsMsg)
+			if err = json.Unmarshal(msg, wsMsg); err != nil || wsMsg.Typ != wsMsgTypeCmd {
+				continue
+			}
+			if err = live.viewerInput(v, wsMsg.Cmd); err != nil {
+				srv.log.Error("协助输入出错", zap.Int64("session_id", live.id), zap.Error(err))
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case data, ok := <-v.out:
+			if !ok {
+				return nil
+			}
+			if err = wsConn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// join 加入会话，先回放最近的输出
+func (live *liveSession) join(user *terminalUser, copilot bool) (*viewer, error) {
+	live.mux.Lock()
+	defer live.mux.Unlock()
+	if live.closed {
+		return nil, errors.New("会话已结束")
+	}
+	v := &viewer{user: user, copilot: copilot, out: make(chan []byte, viewerQueueSize)}
+	live.send(v, append([]byte{}, live.scrollback...))
+	live.viewers[v] = struct{}{}
+	if copilot {
+		live.notify(user.name + " 以协助者身份加入了会话，所有者授权后可以输入")
+	} else {
+		live.notify(user.name + " 加入了会话（观看）")
+	}
+	return v, nil
+}
+
+// leave 离开会话
+func (live *liveSession) leave(v *viewer) {
+	live.mux.Lock()
+	defer live.mux.Unlock()
+	if _, ok := live.viewers[v]; !ok {
+		return
+	}
+	live.drop(v)
+	live.notify(v.user.name + " 离开了会话")
+}
+
+// grant 会话所有者授权或收回协助者的输入权限
+func (live *liveSession) grant(userId int64, granted bool) {
+	live.mux.Lock()
+	defer live.mux.Unlock()
+	for v := range live.viewers {
+		if v.user.id != userId || !v.copilot || v.granted == granted {
+			continue
+		}
+		v.granted = granted
+		if granted {
+			live.notify("已授权 " + v.user.name + " 输入")
+		} else {
+			live.notify("已收回 " + v.user.name + " 的输入权限")
+		}
+	}
+}
+
+// input 执行命令策略后写入shell，返回需要提示输入者的信息
+func (live *liveSession) input(user *terminalUser, cmd string) ([]string, error) {
+	live.mux.Lock()
+	defer live.mux.Unlock()
+	live.record(live.recorder.WriteInput([]byte(cmd)))
+	data, notices := live.guard.filter(user, cmd)
+	if len(data) > 0 {
+		if _, err := live.terminal.Write(data); err != nil {
+			return notices, err
+		}
+	}
+	return notices, nil
+}
+
+// viewerInput 协助者的输入，未授权时提示
+func (live *liveSession) viewerInput(v *viewer, cmd string) error {
+	live.mux.Lock()
+	granted := v.granted
+	if !granted {
+		live.send(v, []byte("\r\n"+terminalMsg("需要会话所有者授权后才能输入", waringMsg)))
+	}
+	live.mux.Unlock()
+	if !granted {
+		return nil
+	}
+	notices, err := live.input(v.user, cmd)
+	live.mux.Lock()
+	defer live.mux.Unlock()
+	for _, notice := range notices {
+		live.send(v, []byte("\r\n"+terminalMsg(notice, waringMsg)))
+	}
+	return err
+}
+
+// broadcast 把终端输出发送给所有观看者，并保留最近的输出用于回放
+func (live *liveSession) broadcast(data []byte) {
+	live.mux.Lock()
+	defer live.mux.Unlock()
+	live.scrollback = append(live.scrollback, data...)
+	if n := len(live.scrollback) - scrollbackSize; n > 0 {
+		//从完整的utf8字符开始
+		for n < len(live.scrollback) && !utf8.RuneStart(live.scrollback[n]) {
+			n++
+		}
+		live.scrollback = append([]byte{}, live.scrollback[n:]...)
+	}
+	for v := range live.viewers {
+		live.send(v, data)
+	}
+}
+
+// notify 在所有参与者的终端中提示，所有者的提示由主循环发送并录像，调用时需持有锁
+func (live *liveSession) notify(msg string) {
+	data := []byte("\r\n" + terminalMsg(msg, waringMsg))
+	for v := range live.viewers {
+		live.send(v, data)
+	}
+	select {
+	case live.notices <- msg:
+	default:
+	}
+}
+
+// send 发送给观看者，发送不及时的观看者会被断开，调用时需持有锁
+func (live *liveSession) send(v *viewer, data []byte) {
+	if v.closed || len(data) == 0 {
+		return
+	}
+	select {
+	case v.out <- data:
+	default:
+		live.drop(v)
+	}
+}
+
+// drop 断开观看者，调用时需持有锁
+func (live *liveSession) drop(v *viewer) {
+	delete(live.viewers, v)
+	if !v.closed {
+		v.closed = true
+		close(v.out)
+	}
+}
+
+func (live *liveSession) record(err error) {
+	if err != nil {
+		live.log.Error("终端录像出错", zap.Int64("session_id", live.id), zap.Error(err))
+	}
+}
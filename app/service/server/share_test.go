@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLiveSessionViewers(t *testing.T) {
+	live := &liveSession{
+		owner:   &terminalUser{id: 1, name: "owner"},
+		notices: make(chan string, 10),
+		viewers: make(map[*viewer]struct{}),
+	}
+	live.broadcast(bytes.Repeat([]byte("中"), scrollbackSize))
+	if len(live.scrollback) > scrollbackSize || !strings.HasPrefix(string(live.scrollback), "中") {
+		t.Error("回放内容截断错误")
+	}
+
+	v, err := live.join(&terminalUser{id: 2, name: "copilot"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := <-v.out; !bytes.Equal(data, live.scrollback) {
+		t.Error("加入时没有回放最近的输出")
+	}
+	if notice := <-live.notices; !strings.Contains(notice, "copilot") {
+		t.Error("所有者没有收到加入提示", notice)
+	}
+	live.grant(2, true)
+	if !v.granted {
+		t.Error("授权失败")
+	}
+
+	//发送不及时的观看者被断开
+	for i := 0; i <= viewerQueueSize; i++ {
+		live.broadcast([]byte("x"))
+	}
+	if _, ok := live.viewers[v]; ok || !v.closed {
+		t.Error("没有断开发送不及时的观看者")
+	}
+	live.leave(v)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/pkg/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// maxJumpDepth 跳板机最多的层级
+const maxJumpDepth = 5
+
+// SshConfig 服务器的ssh连接参数，所有连接服务器的地方都需要使用，使用服务器配置的登录凭证和跳板机，首次连接时保存主机公钥
+func SshConfig(db *gorm.DB, m *model.Server) (ssh.ServerConfig, error) {
+	return sshConfig(db, m, 0)
+}
+
+func sshConfig(db *gorm.DB, m *model.Server, depth int) (ssh.ServerConfig, error) {
+	conf := ssh.ServerConfig{
+		User:    m.User,
+		Host:    m.Host,
+		Port:    m.Port,
+		HostKey: m.HostKey,
+		OnHostKey: func(key gossh.PublicKey) error {
+			return pinHostKey(db, m, key)
+		},
+		Bandwidth: m.Bandwidth,
+	}
+	if m.CredentialId != 0 {
+		if m.Credential == nil || m.Credential.ID != m.CredentialId {
+			credential := &model.Credential{}
+			if err := db.Where("space_id = ? and id = ?", m.SpaceId, m.CredentialId).First(credential).Error; err != nil {
+				return conf, fmt.Errorf("服务器%s的登录凭证不存在：%w", m.Name, err)
+			}
+			m.Credential = credential
+		}
+		conf.Password = m.Credential.Password.String()
+		conf.PrivateKey = m.Credential.PrivateKey.String()
+		conf.Passphrase = m.Credential.Passphrase.String()
+	}
+	if m.JumpServerId != 0 {
+		if depth >= maxJumpDepth {
+			return conf, fmt.Errorf("服务器%s的跳板机超过%d级", m.Name, maxJumpDepth)
+		}
+		if m.JumpServer == nil || m.JumpServer.ID != m.JumpServerId {
+			jump := &model.Server{}
+			if err := db.Where("space_id = ? and id = ?", m.SpaceId, m.JumpServerId).First(jump).Error; err != nil {
+				return conf, fmt.Errorf("服务器%s的跳板机不存在：%w", m.Name, err)
+			}
+			m.JumpServer = jump
+		}
+		jumpConf, err := sshConfig(db, m.JumpServer, depth+1)
+		if err != nil {
+			return conf, err
+		}
+		conf.Jump = &jumpConf
+	}
+	return conf, nil
+}
+
+// checkJumpServerId 跳板机只能使用本空间的服务器，不能形成环，也不能超过最大层级，0表示直接连接
+func (srv *Service) checkJumpServerId(spaceId, id, jumpServerId int64) error {
+	if id != 0 && jumpServerId == id {
+		return errcode.ErrInvalidParams.New("跳板机不能是服务器自身")
+	}
+	//新建服务器时id为0，不会被其他服务器引用
+	visited := make(map[int64]bool)
+	if id != 0 {
+		visited[id] = true
+	}
+	for depth := 0; jumpServerId != 0; depth++ {
+		if visited[jumpServerId] {
+			return errcode.ErrInvalidParams.New("跳板机不能形成环")
+		}
+		if depth >= maxJumpDepth {
+			return errcode.ErrInvalidParams.New("跳板机不能超过%d级", maxJumpDepth)
+		}
+		visited[jumpServerId] = true
+		jump := model.Server{}
+		err := srv.db.Select("id", "jump_server_id").Where("space_id = ? and id = ?", spaceId, jumpServerId).First(&jump).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errcode.ErrInvalidParams.New("跳板机不存在")
+		}
+		if err != nil {
+			return err
+		}
+		jumpServerId = jump.JumpServerId
+	}
+	return nil
+}
+
+// Connections ssh连接池中的连接，所有空间共用一个连接池
+func (srv *Service) Connections() []ssh.Connection {
+	return srv.ssh.Connections()
+}
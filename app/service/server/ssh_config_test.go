@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"go-walle/app/model"
+	"strings"
+	"testing"
+)
+
+func TestCheckJumpServerId(t *testing.T) {
+	srv := newTestService(t)
+	create := func(spaceId int64, name string, jumpServerId int64) int64 {
+		m := &model.Server{SpaceId: spaceId, Name: name, User: "root", Host: "10.0.0.1", Port: 22, JumpServerId: jumpServerId}
+		if err := srv.db.Create(m).Error; err != nil {
+			t.Fatal(err)
+		}
+		return m.ID
+	}
+	//a <- b <- c，x和y已经形成环
+	a := create(1, "a", 0)
+	b := create(1, "b", a)
+	c := create(1, "c", b)
+	x := create(1, "x", 0)
+	y := create(1, "y", x)
+	srv.db.Model(&model.Server{ID: x}).Update("jump_server_id", y)
+	other := create(2, "other", 0)
+	//6级的跳板机
+	chain := int64(0)
+	for i := 0; i <= maxJumpDepth; i++ {
+		chain = create(1, fmt.Sprintf("chain%d", i), chain)
+	}
+
+	cases := []struct {
+		name         string
+		id           int64
+		jumpServerId int64
+		err          string
+	}{
+		{"直接连接", a, 0, ""},
+		{"新建服务器", 0, c, ""},
+		{"修改跳板机", c, a, ""},
+		{"跳板机是自身", a, a, "自身"},
+		{"两台服务器形成环", a, b, "环"},
+		{"多台服务器形成环", a, c, "环"},
+		{"跳板机已有环", 0, x, "环"},
+		{"其他空间的跳板机", a, other, "不存在"},
+		{"跳板机不存在", a, 1000, "不存在"},
+		{"超过最大层级", 0, chain, "级"},
+	}
+	for _, tc := range cases {
+		err := srv.checkJumpServerId(1, tc.id, tc.jumpServerId)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s：%v", tc.name, err)
+		}
+	}
+
+	//修改服务器时拒绝形成环
+	err := srv.Update(&UpdateReq{SpaceId: 1, ID: a, Name: "a", User: "root", Host: "10.0.0.2", Port: 22, JumpServerId: b})
+	if err == nil || !strings.Contains(err.Error(), "环") {
+		t.Error("修改服务器时应拒绝形成环", err)
+	}
+	current := model.Server{}
+	srv.db.First(&current, a)
+	if current.JumpServerId != 0 || current.Host != "10.0.0.1" {
+		t.Error("拒绝后不应修改服务器", current.JumpServerId, current.Host)
+	}
+}
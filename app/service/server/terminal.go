@@ -1,7 +1,7 @@
 package server
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -10,13 +10,14 @@ import (
 	"github.com/gorilla/websocket"
 	"go-walle/app/model"
 	"go-walle/app/model/field"
+	"go-walle/app/pkg/asciicast"
 	"go-walle/app/pkg/ssh"
 	"go-walle/app/service/common"
+	"go-walle/app/service/recording"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"os"
 	"time"
-	"unicode/utf8"
 )
 
 const (
@@ -27,6 +28,8 @@ const (
 )
 
 const (
+	terminalCols       = 200
+	terminalRows       = 40
 	connectTimeout     = time.Minute * 10 //保持连接最长时间
 	buffTime           = time.Microsecond * 500
 	wsMsgTypeResize    = "resize"
@@ -39,6 +42,8 @@ type TerminalWsMsg struct {
 	Cmd string `json:"cmd,omitempty"`
 	Col int    `json:"col,omitempty"`
 	Row int    `json:"row,omitempty"`
+
+	UserId int64 `json:"user_id,omitempty"` //授权或收回输入权限的协助者
 }
 
 func (srv *Service) Check(spaceWithId *common.SpaceWithId) error {
@@ -47,11 +52,11 @@ func (srv *Service) Check(spaceWithId *common.SpaceWithId) error {
 	if err != nil {
 		return err
 	}
-	output, err := srv.ssh.RunCmd(ssh.ServerConfig{
-		User: serverDetail.User,
-		Host: serverDetail.Host,
-		Port: serverDetail.Port,
-	}, "pwd")
+	sshConfig, err := SshConfig(srv.db, &serverDetail)
+	if err != nil {
+		return err
+	}
+	output, err := srv.ssh.RunCmd(sshConfig, "pwd")
 	srv.log.Debug("CheckConnect", zap.String("cmd", "pwd"), zap.ByteString("output", output), zap.Error(err))
 	if err != nil && serverDetail.Status.IsEnable() {
 		return srv.db.Model(&serverDetail).Where("id=?", serverDetail.ID).UpdateColumn("status", field.StatusDisable).Error
@@ -71,19 +76,20 @@ func (srv *Service) SetAuthorized(params *SetAuthorizedReq) error {
 	if serverDetail.Status.IsEnable() {
 		return errors.New("该服务器能正常连接，无需设置")
 	}
-	signer, err := srv.ssh.IdentitySigner()
+	sshConfig, err := SshConfig(srv.db, &serverDetail)
+	if err != nil {
+		return err
+	}
+	//授权服务器登录凭证中的私钥，没有时授权默认私钥
+	signer, err := srv.ssh.Signer(sshConfig)
 	if err != nil {
 		return err
 	}
 	hostname, _ := os.Hostname()
 	publicKeyStr := fmt.Sprintf("%s %s %s", signer.PublicKey().Type(), base64.StdEncoding.EncodeToString(signer.PublicKey().Marshal()), hostname)
 	runCmd := fmt.Sprintf("mkdir -p $HOME/.ssh && echo '%s' >> $HOME/.ssh/authorized_keys && chmod 600 $HOME/.ssh/authorized_keys", publicKeyStr)
-	output, err := srv.ssh.RunCmd(ssh.ServerConfig{
-		User:     serverDetail.User,
-		Host:     serverDetail.Host,
-		Password: params.Password,
-		Port:     serverDetail.Port,
-	}, runCmd)
+	sshConfig.Password = params.Password
+	output, err := srv.ssh.RunCmd(sshConfig, runCmd)
 	srv.log.Debug("Setting", zap.String("cmd", runCmd), zap.ByteString("output", output), zap.Error(err))
 	if err == nil {
 		_err := srv.db.Model(&serverDetail).Where("id=?", serverDetail.ID).UpdateColumn("status", field.StatusEnable).Error
@@ -94,7 +100,7 @@ func (srv *Service) SetAuthorized(params *SetAuthorizedReq) error {
 	return err
 }
 
-func (srv *Service) Terminal(wsConn *websocket.Conn, spaceWithId *common.SpaceWithId, username string) error {
+func (srv *Service) Terminal(wsConn *websocket.Conn, spaceWithId *common.SpaceWithId, userId int64, username string) error {
 	wsSendMsg := func(msg string, msgType int) error {
 		_err := wsConn.WriteMessage(websocket.TextMessage, []byte(terminalMsg(msg, msgType)))
 		if _err != nil {
@@ -113,15 +119,49 @@ func (srv *Service) Terminal(wsConn *websocket.Conn, spaceWithId *common.SpaceWi
 		return err
 	}
 
+	//终端策略
+	policy, err := srv.TerminalPolicy(serverDetail.SpaceId)
+	if err != nil {
+		_ = wsSendMsg(err.Error(), errorMsg)
+		return err
+	}
+	commands, err := newCommandPolicy(policy)
+	if err != nil {
+		_ = wsSendMsg(err.Error(), errorMsg)
+		return err
+	}
+	role, err := srv.memberRole(serverDetail.SpaceId, userId)
+	if err != nil {
+		_ = wsSendMsg(err.Error(), errorMsg)
+		return err
+	}
+	auditLog := func(userId int64, action, target, detail string) {
+		srv.audit.Log(&model.AuditLog{
+			SpaceId:  serverDetail.SpaceId,
+			UserId:   userId,
+			Action:   action,
+			ServerId: serverDetail.ID,
+			Target:   target,
+			Detail:   detail,
+		})
+	}
+	if !srv.openSession(serverDetail.SpaceId, userId, policy.MaxSessions) {
+		detail := fmt.Sprintf("同时打开的终端超过%d个", policy.MaxSessions)
+		auditLog(userId, model.AuditTerminalRejected, serverDetail.Name, detail)
+		_ = wsSendMsg(detail+"，请关闭其他终端后重试", errorMsg)
+		return errors.New(detail)
+	}
+	defer srv.closeSession(serverDetail.SpaceId, userId)
+	owner := &terminalUser{id: userId, name: username, readOnly: policy.IsReadOnly(role)}
+
 	if err = wsSendMsg("正在连接服务器...", successMsg); err != nil {
 		return err
 	}
-	sshTerminal, err := srv.ssh.NewTerminal(ssh.ServerConfig{
-		User:     serverDetail.User,
-		Host:     serverDetail.Host,
-		Password: "",
-		Port:     serverDetail.Port,
-	}, 200, 40)
+	var sshTerminal *ssh.Terminal
+	sshConfig, err := SshConfig(srv.db, &serverDetail)
+	if err == nil {
+		sshTerminal, err = srv.ssh.NewTerminal(sshConfig, terminalCols, terminalRows)
+	}
 	if err != nil {
 		_ = wsSendMsg(err.Error(), errorMsg)
 		return err
@@ -129,21 +169,66 @@ func (srv *Service) Terminal(wsConn *websocket.Conn, spaceWithId *common.SpaceWi
 	defer func() {
 		_ = sshTerminal.Close()
 	}()
+	//无法录像时不允许使用终端
+	recorder, err := srv.recording.Start(&model.TerminalSession{
+		SpaceId:  serverDetail.SpaceId,
+		UserId:   userId,
+		ServerId: serverDetail.ID,
+		Cols:     terminalCols,
+		Rows:     terminalRows,
+		Server:   serverDetail,
+	})
+	if err != nil {
+		_ = wsSendMsg("终端录像失败："+err.Error(), errorMsg)
+		return err
+	}
+	defer recorder.Close()
 	if err = wsSendMsg("连接服务器成功！", successMsg); err != nil {
 		return err
 	}
 	if err = wsSendMsg("Hello "+username+"，您所操作的所有命令都将会被记录，请谨慎操作！！！", waringMsg); err != nil {
 		return err
 	}
-	srv.dealMsg(wsConn, sshTerminal)
+	if owner.readOnly {
+		if err = wsSendMsg("当前为只读终端，只能查看输出", waringMsg); err != nil {
+			return err
+		}
+	}
+	live := &liveSession{
+		log:       srv.log,
+		id:        recorder.SessionId(),
+		spaceId:   serverDetail.SpaceId,
+		server:    serverDetail,
+		owner:     owner,
+		terminal:  sshTerminal,
+		recorder:  recorder,
+		guard:     &terminalGuard{policy: commands, onBlock: auditLog},
+		notices:   make(chan string, 10),
+		startedAt: time.Now(),
+		viewers:   make(map[*viewer]struct{}),
+	}
+	srv.startLive(live)
+	defer srv.endLive(live)
+	if err = wsSendMsg(fmt.Sprintf("会话ID：%d，其他成员可以通过该ID加入观看或协助", live.id), defaultMsg); err != nil {
+		return err
+	}
+	srv.dealMsg(wsConn, live, time.Duration(policy.MaxDuration)*time.Minute)
 	return nil
 }
 
-// dealMsg 终端数据交互
-func (srv *Service) dealMsg(wsConn *websocket.Conn, sshTerminal *ssh.Terminal) {
+// dealMsg 终端数据交互，输入、输出和窗口大小变化都会录像，输出同时发送给加入会话的观看者。
+// 输入经过命令策略校验，maxDuration大于0时到时间后断开
+func (srv *Service) dealMsg(wsConn *websocket.Conn, live *liveSession, maxDuration time.Duration) {
+	sshTerminal, recorder := live.terminal, live.recorder
 	connectTimeoutT := time.NewTimer(connectTimeout)
 	bufTimeT := time.NewTimer(buffTime)
 	ctx, cancel := context.WithCancel(context.Background())
+	var maxDurationC <-chan time.Time
+	if maxDuration > 0 {
+		maxDurationT := time.NewTimer(maxDuration)
+		defer maxDurationT.Stop()
+		maxDurationC = maxDurationT.C
+	}
 
 	defer func() {
 		connectTimeoutT.Stop()
@@ -176,9 +261,21 @@ func (srv *Service) dealMsg(wsConn *websocket.Conn, sshTerminal *ssh.Terminal) {
 				switch wsMsg.Typ {
 				case wsMsgTypeResize:
 					err = sshTerminal.WindowChange(wsMsg.Row, wsMsg.Col)
+					srv.record(recorder.WriteResize(wsMsg.Col, wsMsg.Row))
 				case wsMsgTypeHeartbeat:
+				case wsMsgTypeGrant, wsMsgTypeRevoke:
+					live.grant(wsMsg.UserId, wsMsg.Typ == wsMsgTypeGrant)
 				default:
-					_, err = sshTerminal.Write([]byte(wsMsg.Cmd))
+					//ws只能有一个写入者，提示信息交给主循环发送
+					var notices []string
+					notices, err = live.input(live.owner, wsMsg.Cmd)
+					for _, notice := range notices {
+						select {
+						case live.notices <- notice:
+						case <-ctx.Done():
+							return
+						}
+					}
 				}
 				if err != nil {
 					cancel()
@@ -188,26 +285,30 @@ func (srv *Service) dealMsg(wsConn *websocket.Conn, sshTerminal *ssh.Terminal) {
 		}
 	}()
 
-	r := make(chan rune)
-	//读取buf
-	br := bufio.NewReader(sshTerminal)
-
+	r := make(chan []byte)
+	//读取shell输出，末尾不完整的utf8字符留到下次读取后再发送，无效的字节替换为@
 	go func() {
+		p := make([]byte, 32*1024)
+		var pending []byte
 		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				x, size, err := br.ReadRune()
-				if err != nil {
-					cancel()
-					srv.log.Error("处理shell消息出错", zap.Error(err))
-					return
-				}
-				if size > 0 {
-					r <- x
+			n, err := sshTerminal.Read(p)
+			if n > 0 {
+				data := append(pending, p[:n]...)
+				m := asciicast.CompleteRunes(data)
+				pending = append([]byte{}, data[m:]...)
+				if m > 0 {
+					select {
+					case r <- bytes.ToValidUTF8(data[:m], []byte("@")):
+					case <-ctx.Done():
+						return
+					}
 				}
 			}
+			if err != nil {
+				cancel()
+				srv.log.Error("处理shell消息出错", zap.Error(err))
+				return
+			}
 		}
 	}()
 
@@ -218,10 +319,21 @@ func (srv *Service) dealMsg(wsConn *websocket.Conn, sshTerminal *ssh.Terminal) {
 		case <-connectTimeoutT.C:
 			cancel()
 			return
+		case <-maxDurationC:
+			_ = srv.writeNotice(wsConn, recorder, "已达到终端最长使用时间，连接已断开", errorMsg)
+			cancel()
+			return
+		case notice := <-live.notices:
+			if err := srv.writeNotice(wsConn, recorder, notice, waringMsg); err != nil {
+				cancel()
+				return
+			}
 		case <-ctx.Done():
 			return
 		case <-bufTimeT.C:
 			if len(buf) != 0 {
+				srv.record(recorder.WriteOutput(buf))
+				live.broadcast(buf)
 				err := wsConn.WriteMessage(websocket.TextMessage, buf)
 				buf = []byte{}
 				if err != nil {
@@ -233,18 +345,30 @@ func (srv *Service) dealMsg(wsConn *websocket.Conn, sshTerminal *ssh.Terminal) {
 			}
 			bufTimeT.Reset(buffTime)
 		case d := <-r:
-			if d != utf8.RuneError {
-				p := make([]byte, utf8.RuneLen(d))
-				utf8.EncodeRune(p, d)
-				buf = append(buf, p...)
-			} else {
-				buf = append(buf, []byte("@")...)
-			}
+			buf = append(buf, d...)
 			connectTimeoutT.Reset(connectTimeout)
 		}
 	}
 }
 
+// writeNotice 在终端中提示用户，提示信息同样录像
+func (srv *Service) writeNotice(wsConn *websocket.Conn, recorder *recording.Recorder, msg string, typ int) error {
+	data := []byte("\r\n" + terminalMsg(msg, typ))
+	srv.record(recorder.WriteOutput(data))
+	err := wsConn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		srv.log.Error("ws.WriteMessage:", zap.Error(err))
+	}
+	return err
+}
+
+// record 录像出错时只记录日志，不中断终端
+func (srv *Service) record(err error) {
+	if err != nil {
+		srv.log.Error("终端录像出错", zap.Error(err))
+	}
+}
+
 func terminalMsg(msg string, typ int) string {
 	switch typ {
 	case waringMsg:
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"go-walle/app/pkg/repo"
+	"strings"
+)
+
+const (
+	ProviderGithub = "github"
+	ProviderGitlab = "gitlab"
+	ProviderGitea  = "gitea"
+)
+
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// PushEvent 各平台push/tag事件解析后的统一结构
+type PushEvent struct {
+	Ref     string   `json:"ref"`     //完整引用名，如refs/heads/main，refs/tags/v1.0.0
+	Commit  string   `json:"commit"`  //推送后的commit
+	Pusher  string   `json:"pusher"`  //推送人
+	Message string   `json:"message"` //最新一次提交说明
+	Files   []string `json:"-"`       //本次推送修改的文件，为nil时表示未知
+	Ignore  bool     `json:"-"`       //非push事件，或者删除分支/tag
+}
+
+// IsTag 是否为tag推送
+func (e *PushEvent) IsTag() bool {
+	return strings.HasPrefix(e.Ref, "refs/tags/")
+}
+
+// Touches 本次推送是否修改了子目录下的文件，tag推送或者无法判断时返回true
+func (e *PushEvent) Touches(subDir string) bool {
+	if subDir == "" || e.IsTag() || e.Files == nil {
+		return true
+	}
+	for _, file := range e.Files {
+		if repo.InSubDir(subDir, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShortRef 分支名或者tag名
+func (e *PushEvent) ShortRef() string {
+	return strings.TrimPrefix(strings.TrimPrefix(e.Ref, "refs/heads/"), "refs/tags/")
+}
+
+type ReceiveRes struct {
+	TaskId   int64  `json:"task_id"`
+	Released bool   `json:"released"`
+	Message  string `json:"message"`
+}
@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"go-walle/app/internal/errcode"
+	"net/http"
+	"strings"
+)
+
+// provider 代码托管平台的webhook处理
+type provider interface {
+	// verify 校验请求签名
+	verify(header http.Header, body []byte, secret string) error
+	// parse 解析push事件
+	parse(header http.Header, body []byte) (*PushEvent, error)
+}
+
+var providers = map[string]provider{
+	ProviderGithub: &github{},
+	ProviderGitlab: &gitlab{},
+	ProviderGitea:  &gitea{},
+}
+
+type commitPayload struct {
+	Message  string   `json:"message"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+// changedFiles 汇总所有提交修改的文件，total为推送的提交总数，
+// 平台截断了提交列表时返回nil，表示无法判断修改了哪些文件
+func changedFiles(commits []commitPayload, total int) []string {
+	if len(commits) == 0 || total > len(commits) {
+		return nil
+	}
+	files := make([]string, 0)
+	for _, c := range commits {
+		files = append(files, c.Added...)
+		files = append(files, c.Modified...)
+		files = append(files, c.Removed...)
+	}
+	return files
+}
+
+// github 签名头：X-Hub-Signature-256: sha256=<hex>
+type github struct{}
+
+// githubMaxCommits github推送事件最多包含20个提交，并且不提供提交总数
+const githubMaxCommits = 20
+
+func (*github) verify(header http.Header, body []byte, secret string) error {
+	sign := header.Get("X-Hub-Signature-256")
+	if !strings.HasPrefix(sign, "sha256=") {
+		return ErrSignature
+	}
+	return checkHmacSha256(body, secret, strings.TrimPrefix(sign, "sha256="))
+}
+
+func (*github) parse(header http.Header, body []byte) (*PushEvent, error) {
+	if header.Get("X-GitHub-Event") != "push" {
+		return &PushEvent{Ignore: true}, nil
+	}
+	payload := struct {
+		Ref     string `json:"ref"`
+		After   string `json:"after"`
+		Deleted bool   `json:"deleted"`
+		Pusher  struct {
+			Name string `json:"name"`
+		} `json:"pusher"`
+		HeadCommit commitPayload   `json:"head_commit"`
+		Commits    []commitPayload `json:"commits"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, errcode.ErrInvalidParams.Wrap(err)
+	}
+	return &PushEvent{
+		Ref:     payload.Ref,
+		Commit:  payload.After,
+		Pusher:  payload.Pusher.Name,
+		Message: payload.HeadCommit.Message,
+		Files:   changedFiles(payload.Commits, githubTotal(payload.Commits)),
+		Ignore:  payload.Deleted || payload.After == zeroCommit,
+	}, nil
+}
+
+// githubTotal 提交数达到上限时可能已被截断，按多一个提交处理
+func githubTotal(commits []commitPayload) int {
+	if len(commits) >= githubMaxCommits {
+		return len(commits) + 1
+	}
+	return len(commits)
+}
+
+// gitlab 不签名，请求头X-Gitlab-Token直接携带密钥
+type gitlab struct{}
+
+func (*gitlab) verify(header http.Header, body []byte, secret string) error {
+	if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+		return ErrSignature
+	}
+	return nil
+}
+
+func (*gitlab) parse(header http.Header, body []byte) (*PushEvent, error) {
+	event := header.Get("X-Gitlab-Event")
+	if event != "Push Hook" && event != "Tag Push Hook" {
+		return &PushEvent{Ignore: true}, nil
+	}
+	payload := struct {
+		Ref         string          `json:"ref"`
+		After       string          `json:"after"`
+		CheckoutSha string          `json:"checkout_sha"`
+		UserName    string          `json:"user_name"`
+		Commits     []commitPayload `json:"commits"`
+		Total       int             `json:"total_commits_count"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, errcode.ErrInvalidParams.Wrap(err)
+	}
+	res := &PushEvent{
+		Ref:    payload.Ref,
+		Commit: payload.CheckoutSha,
+		Pusher: payload.UserName,
+		Files:  changedFiles(payload.Commits, payload.Total),
+		Ignore: payload.After == zeroCommit,
+	}
+	//tag推送时after是tag对象，checkout_sha才是对应的commit
+	if res.Commit == "" {
+		res.Commit = payload.After
+	}
+	if l := len(payload.Commits); l > 0 {
+		res.Message = payload.Commits[l-1].Message
+	}
+	return res, nil
+}
+
+// gitea 签名头：X-Gitea-Signature: <hex>
+type gitea struct{}
+
+func (*gitea) verify(header http.Header, body []byte, secret string) error {
+	return checkHmacSha256(body, secret, header.Get("X-Gitea-Signature"))
+}
+
+func (*gitea) parse(header http.Header, body []byte) (*PushEvent, error) {
+	if header.Get("X-Gitea-Event") != "push" {
+		return &PushEvent{Ignore: true}, nil
+	}
+	payload := struct {
+		Ref    string `json:"ref"`
+		After  string `json:"after"`
+		Pusher struct {
+			Login    string `json:"login"`
+			Username string `json:"username"`
+		} `json:"pusher"`
+		HeadCommit commitPayload   `json:"head_commit"`
+		Commits    []commitPayload `json:"commits"`
+		Total      int             `json:"total_commits"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, errcode.ErrInvalidParams.Wrap(err)
+	}
+	res := &PushEvent{
+		Ref:     payload.Ref,
+		Commit:  payload.After,
+		Pusher:  payload.Pusher.Login,
+		Message: payload.HeadCommit.Message,
+		Files:   changedFiles(payload.Commits, payload.Total),
+		Ignore:  payload.After == zeroCommit,
+	}
+	if res.Pusher == "" {
+		res.Pusher = payload.Pusher.Username
+	}
+	return res, nil
+}
+
+func checkHmacSha256(body []byte, secret, sign string) error {
+	expected, err := hex.DecodeString(sign)
+	if err != nil || len(expected) == 0 {
+		return ErrSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrSignature
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGithubVerify(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main","after":"a1b2c3d4e5f6"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	header := http.Header{}
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	header.Set("X-GitHub-Event", "push")
+
+	p := providers[ProviderGithub]
+	if err := p.verify(header, body, "secret"); err != nil {
+		t.Error("签名校验失败", err)
+	}
+	if err := p.verify(header, body, "other"); err == nil {
+		t.Error("错误密钥校验通过")
+	}
+	event, err := p.parse(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Ignore || event.IsTag() || event.ShortRef() != "main" {
+		t.Error("解析push事件错误", event)
+	}
+}
+
+func TestMatchRefs(t *testing.T) {
+	rules := "# 注释\nrefs/heads/main\nrelease-*\nrefs/tags/v*"
+	for ref, want := range map[string]bool{
+		"refs/heads/main":      true,
+		"refs/heads/release-1": true,
+		"refs/tags/v1.0.0":     true,
+		"refs/heads/dev":       false,
+		"refs/tags/test":       false,
+	} {
+		if matchRefs(rules, ref) != want {
+			t.Errorf("matchRefs(%s) != %v", ref, want)
+		}
+	}
+}
+
+func TestPushEventTouches(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main","after":"a1b2c3d4e5f6","total_commits_count":2,"commits":[
+		{"message":"web","added":["web/a.js"],"modified":[],"removed":[]},
+		{"message":"doc","added":[],"modified":["README.md"],"removed":["services/api/old.go"]}]}`)
+	header := http.Header{}
+	header.Set("X-Gitlab-Event", "Push Hook")
+	event, err := providers[ProviderGitlab].parse(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !event.Touches("") || !event.Touches("services/api") || !event.Touches("web") {
+		t.Error("修改了子目录的推送应触发", event.Files)
+	}
+	if event.Touches("services/web") {
+		t.Error("未修改子目录的推送不应触发", event.Files)
+	}
+	//提交列表被截断时无法判断，总是触发
+	event.Files = changedFiles([]commitPayload{{Added: []string{"web/a.js"}}}, 30)
+	if !event.Touches("services/web") {
+		t.Error("提交列表截断时应触发")
+	}
+}
+
+func TestGithubTruncatedCommits(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "push")
+	parse := func(n int) *PushEvent {
+		commits := make([]commitPayload, n)
+		for i := range commits {
+			commits[i].Modified = []string{"web/a.js"}
+		}
+		body, _ := json.Marshal(map[string]any{"ref": "refs/heads/main", "after": "a1b2c3d4e5f6", "commits": commits})
+		event, err := providers[ProviderGithub].parse(header, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+	if parse(githubMaxCommits - 1).Touches("services/api") {
+		t.Error("未修改子目录的推送不应触发")
+	}
+	//github最多包含20个提交，可能已被截断，总是触发
+	if !parse(githubMaxCommits).Touches("services/api") {
+		t.Error("提交列表可能截断时应触发")
+	}
+}
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"fmt"
+	"go-walle/app/internal/errcode"
+	"go-walle/app/model"
+	"go-walle/app/service/common"
+	"go-walle/app/service/deploy"
+	"go-walle/app/service/mirror"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var ErrSignature = errcode.ErrForbidden.New("webhook签名校验失败")
+
+var (
+	service     *Service
+	onceService sync.Once
+)
+
+type Service struct {
+	log    *zap.Logger
+	db     *gorm.DB
+	deploy *deploy.Service
+	mirror *mirror.Service
+}
+
+func NewService(log *zap.Logger, db *gorm.DB, deploy *deploy.Service, mirror *mirror.Service) *Service {
+	onceService.Do(func() {
+		service = &Service{log: log, db: db, deploy: deploy, mirror: mirror}
+	})
+	return service
+}
+
+// Receive 处理代码托管平台推送的webhook，符合规则的分支或tag创建上线单
+func (srv *Service) Receive(providerName string, projectId int64, header http.Header, body []byte) (*ReceiveRes, error) {
+	p, ok := providers[providerName]
+	if !ok {
+		return nil, errcode.ErrNotFound.New("不支持的webhook类型：%s", providerName)
+	}
+	project := model.Project{}
+	if err := srv.db.Where("id = ?", projectId).First(&project).Error; err != nil {
+		return nil, err
+	}
+	//未设置密钥的项目不接受webhook
+	if project.HookSecret == "" {
+		return nil, ErrSignature
+	}
+	if err := p.verify(header, body, project.HookSecret.String()); err != nil {
+		return nil, err
+	}
+	event, err := p.parse(header, body)
+	if err != nil {
+		return nil, err
+	}
+	if event.Ignore {
+		return &ReceiveRes{Message: "忽略该事件"}, nil
+	}
+	//仓库有更新，同步本地镜像
+	srv.mirror.Trigger(project.ID)
+	if !matchRefs(project.HookRefs, event.Ref) {
+		return &ReceiveRes{Message: fmt.Sprintf("%s不在触发规则内", event.Ref)}, nil
+	}
+	if subDir := project.RepoOptions().SubDir; !event.Touches(subDir) {
+		return &ReceiveRes{Message: fmt.Sprintf("本次推送未修改%s目录下的文件", subDir)}, nil
+	}
+
+	params := &deploy.CreateReq{
+		UserId:    project.UserId,
+		SpaceId:   project.SpaceId,
+		ProjectId: project.ID,
+		Name:      taskName(providerName, event),
+	}
+	if event.IsTag() {
+		params.Tag = event.ShortRef()
+	} else {
+		params.Branch = event.ShortRef()
+		params.CommitId = event.Commit
+	}
+	if err = srv.db.Model(&model.Server{}).
+		Joins("join project_server on project_server.server_id = servers.id").
+		Where("project_server.project_id = ?", project.ID).
+		Pluck("servers.id", &params.ServerIds).Error; err != nil {
+		return nil, err
+	}
+	task, err := srv.deploy.CreateTask(params)
+	if err != nil {
+		return nil, err
+	}
+	srv.log.Info("webhook创建上线单",
+		zap.String("provider", providerName),
+		zap.Int64("project_id", project.ID),
+		zap.Int64("task_id", task.ID),
+		zap.String("ref", event.Ref),
+		zap.String("commit", event.Commit))
+
+	res := &ReceiveRes{TaskId: task.ID, Message: "上线单创建成功"}
+	//未开启审核，并且设置了自动发布
+	if project.TaskAudit != 1 && project.HookRelease == 1 {
+		err = srv.deploy.Release(&common.SpaceWithId{SpaceId: task.SpaceId, ID: task.ID}, project.UserId)
+		if err != nil {
+			srv.log.Error("webhook自动发布失败", zap.Int64("task_id", task.ID), zap.Error(err))
+			res.Message = "上线单创建成功，自动发布失败：" + err.Error()
+			return res, nil
+		}
+		res.Released = true
+	}
+	return res, nil
+}
+
+// matchRefs 按行匹配规则，支持通配符，如refs/heads/main，refs/tags/v*，也可以直接写分支名或tag名
+func matchRefs(rules string, ref string) bool {
+	shortRef := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+	for _, rule := range strings.Split(rules, "\n") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" || rule[:1] == "#" {
+			continue
+		}
+		if ok, _ := path.Match(rule, ref); ok {
+			return true
+		}
+		if ok, _ := path.Match(rule, shortRef); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func taskName(providerName string, event *PushEvent) string {
+	name := fmt.Sprintf("[%s]%s", providerName, event.ShortRef())
+	if len(event.Commit) >= 8 {
+		name += "@" + event.Commit[:8]
+	}
+	if msg := strings.TrimSpace(strings.SplitN(event.Message, "\n", 2)[0]); msg != "" {
+		name += " " + msg
+	}
+	if event.Pusher != "" {
+		name += " by " + event.Pusher
+	}
+	//上线单名称最长100
+	for len(name) > 100 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
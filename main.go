@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/spf13/cobra"
@@ -99,6 +101,14 @@ func cmdRun(cmd *cobra.Command, args []string) (err error) {
 
 // cmdSetup 初始化数据库
 func cmdSetup(cmd *cobra.Command, args []string) error {
+	//未指定敏感数据加密key时随机生成
+	if setupCfg.Secret.Key == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		return process.SaveConfig(cmd, configFile, process.SaveConfigWithOverride("secret.key", hex.EncodeToString(key)))
+	}
 	return process.SaveConfig(cmd, configFile)
 }
 
@@ -6,6 +6,7 @@ import {
   BranchItems,
   TagItems,
   CommitItems, Detail,
+  RefsRes,
 } from './model';
 import { defHttp } from '/@/utils/http/axios';
 import {GetOptionItemsModel} from "../model/baseModel";
@@ -40,10 +41,10 @@ export const getProjectOptions = (params?: ListReq) =>
   defHttp.get<GetOptionItemsModel>({ url: Api.ProjectOptions, params });
 
 export const getProjectBranches = (id: number) =>
-  defHttp.get<BranchItems[]>({ url: Api.ProjectBranches.replace('{id}', id.toString()) });
+  defHttp.get<RefsRes<BranchItems>>({ url: Api.ProjectBranches.replace('{id}', id.toString()) });
 
 export const getProjectTags = (id: number) =>
-  defHttp.get<TagItems[]>({ url: Api.ProjectTags.replace('{id}', id.toString()) });
+  defHttp.get<RefsRes<TagItems>>({ url: Api.ProjectTags.replace('{id}', id.toString()) });
 
 export const getProjectCommits = (id: number, branch?:string) =>
-  defHttp.get<CommitItems[]>({ url: Api.ProjectCommits.replace('{id}', id.toString()), params: {"branch": branch} });
+  defHttp.get<RefsRes<CommitItems>>({ url: Api.ProjectCommits.replace('{id}', id.toString()), params: {"branch": branch} });
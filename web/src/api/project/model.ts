@@ -90,3 +90,9 @@ export type CommitItems = TagItems & {
   timestamp: string,
   message: string,
 }
+
+// 分支、标签和提交记录读取自本地镜像，fetched_at为镜像最后同步时间
+export type RefsRes<T> = {
+  items: T[],
+  fetched_at?: string,
+}
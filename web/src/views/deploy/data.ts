@@ -100,7 +100,7 @@ export const formSchema: FormSchema[] = [
       return {
         api: getProjectTags,
         params: formModel["project_id"],
-        resultField: '',
+        resultField: 'items',
         immediate: false,
         alwaysLoad: true,
         labelField: 'name',
@@ -122,7 +122,7 @@ export const formSchema: FormSchema[] = [
         params: formModel["project_id"],
         immediate: false,
         alwaysLoad: true,
-        resultField: '',
+        resultField: 'items',
         labelField: 'name',
         valueField: 'name',
         showSearch: true,
@@ -146,7 +146,7 @@ export const formSchema: FormSchema[] = [
         params: [formModel["project_id"], formModel['branch']],
         immediate: false,
         //alwaysLoad: true,
-        resultField: '',
+        resultField: 'items',
         labelField: 'name',
         valueField: 'hash',
         showSearch: true,